@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 
@@ -21,7 +22,12 @@ var AddAlbumCmd = &cobra.Command{
 			log.Fatalln("Service daemon not running.")
 		}
 		defer rpcClient.Close()
-		_, err = rpcClient.SendMessage(socketrpc.CmdAddAlbum, args[0]+"//"+args[1])
+		request, err := json.Marshal(socketrpc.AddToAlbumRequest{Path: args[0], Album: args[1]})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		_, err = rpcClient.SendMessage(socketrpc.CmdAddAlbum, string(request))
 		if err != nil {
 			fmt.Println(err)
 			return
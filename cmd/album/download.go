@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 
@@ -9,6 +10,7 @@ import (
 )
 
 func init() {
+	DownloadAlbumCmd.Flags().StringVar(&serverFlag, "server", "", "Server profile to download the album from")
 }
 
 var DownloadAlbumCmd = &cobra.Command{
@@ -21,7 +23,12 @@ var DownloadAlbumCmd = &cobra.Command{
 			log.Fatalln("Service daemon not running.")
 		}
 		defer rpcClient.Close()
-		_, err = rpcClient.SendMessage(socketrpc.CmdDownloadAlbum, args[0]+"//"+args[1])
+		request, err := json.Marshal(socketrpc.DownloadAlbumRequest{Album: args[0], Path: args[1], Server: serverFlag})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		_, err = rpcClient.SendMessageTimeout(socketrpc.CmdDownloadAlbum, string(request), 0)
 		if err != nil {
 			fmt.Println(err)
 			return
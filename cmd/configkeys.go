@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/JonaEnz/immich-sync/fswatch"
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/spf13/viper"
+)
+
+// configKey is a key of the config file, keys of sections like timeouts are written as timeouts.api.
+type configKey struct {
+	name string
+	// def is the default value, nil if the key has none
+	def any
+}
+
+// configKeys are all keys outside of watch entries and server profiles, which are listed by the tags
+// of the structs they are decoded into (see sectionKeys). Reading the config and doctor use this table.
+var configKeys = []configKey{
+	{"watch", []immichserver.ImageDirectoryConfig{}},
+	{"servers", nil},
+	{"server", ""},
+	{"apikey", ""},
+	{"apikey_file", nil},
+	{"deviceid", "defaultdeviceid"},
+	{"transport", nil},
+	{"schedule", 15},
+	{"schedule-jitter", "30s"},
+	{"concurrent-uploads", 5},
+	{"keepchangedfiles", false},
+	{"metrics", ""},
+	{"log-level", "info"},
+	{"log-format", "text"},
+	// Empty uses defaultStateFile, which depends on the environment
+	{"statefile", ""},
+	{"shutdown-timeout", "30s"},
+	{"quiet-period", "5s"},
+	{"temp-patterns", fswatch.DefaultTempPatterns},
+	{"hash-workers", immichserver.DefaultHashWorkers},
+	{"hash-rate-limit", 0},
+	{"timeouts.api", "30s"},
+	{"timeouts.upload", "30m"},
+	{"timeouts.download", "30m"},
+}
+
+// setDefaults sets the defaults of all config keys on v.
+func setDefaults(v *viper.Viper) {
+	for _, key := range configKeys {
+		if key.def != nil {
+			v.SetDefault(key.name, key.def)
+		}
+	}
+}
+
+// sectionConfigKeys returns the keys of the config section, the top level keys for "".
+func sectionConfigKeys(section string) []string {
+	var keys []string
+	for _, key := range configKeys {
+		name := key.name
+		if section != "" {
+			var ok bool
+			if name, ok = strings.CutPrefix(name, section+"."); !ok {
+				continue
+			}
+		}
+		name, _, _ = strings.Cut(name, ".")
+		if !slices.Contains(keys, name) {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// sectionKeys returns the config keys of a section decoded into T, the mapstructure tags of its fields.
+// Like the decoder, fields without a tag use their lower case name.
+func sectionKeys[T any]() []string {
+	t := reflect.TypeFor[T]()
+	keys := make([]string, 0, t.NumField())
+	for n := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(n).Tag.Get("mapstructure"), ",")
+		if len(name) == 0 {
+			name = strings.ToLower(t.Field(n).Name)
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/JonaEnz/immich-sync/geotag"
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/spf13/viper"
+)
+
+func TestConfigTags(t *testing.T) {
+	for _, typ := range []reflect.Type{
+		reflect.TypeFor[immichserver.ImageDirectoryConfig](),
+		reflect.TypeFor[immichserver.StackConfig](),
+		reflect.TypeFor[immichserver.ServerConfig](),
+		reflect.TypeFor[immichserver.TransportConfig](),
+		reflect.TypeFor[geotag.Config](),
+	} {
+		for n := range typ.NumField() {
+			field := typ.Field(n)
+			key := field.Tag.Get("mapstructure")
+			if key == "" || key != field.Tag.Get("json") || field.Tag.Get("yaml") != "" {
+				t.Errorf("%s.%s: expected matching json and mapstructure tags, got `%s`", typ.Name(), field.Name, field.Tag)
+			}
+		}
+	}
+}
+
+func TestConfigKeys(t *testing.T) {
+	v := viper.New()
+	setDefaults(v)
+	known := append(slices.Clone(knownConfigKeys), prefixed("timeouts.", knownTimeoutKeys)...)
+	for _, key := range v.AllKeys() {
+		if !slices.Contains(known, key) {
+			t.Errorf("Default of %s is not a known key %v", key, known)
+		}
+	}
+	if !slices.Contains(knownWatchKeys, "time_offset") || !slices.Contains(knownTransportKeys, "ca_file") {
+		t.Errorf("Expected the keys of watch entries and transports from their tags, got %v and %v", knownWatchKeys, knownTransportKeys)
+	}
+	type untagged struct {
+		Path   string
+		MaxGap int `mapstructure:"max_gap"`
+	}
+	if keys := sectionKeys[untagged](); !slices.Equal(keys, []string{"path", "max_gap"}) {
+		t.Errorf("Expected the keys of fields without a tag from their names, got %v", keys)
+	}
+}
+
+func prefixed(prefix string, keys []string) []string {
+	result := make([]string, len(keys))
+	for n, key := range keys {
+		result[n] = prefix + key
+	}
+	return result
+}
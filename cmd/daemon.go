@@ -1,96 +1,367 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"slices"
-	"strings"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/JonaEnz/immich-sync/geotag"
 	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/JonaEnz/immich-sync/schedule"
 	"github.com/JonaEnz/immich-sync/socketrpc"
+	"github.com/JonaEnz/immich-sync/systemd"
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 func init() {
+	daemonCmd.AddCommand(daemonStopCmd)
 	rootCmd.AddCommand(daemonCmd)
 }
 
 var (
-	server            *immichserver.ImmichServer
 	concurrentUploads int
-	keepChangedFiles  bool
+	// uploadPool runs the uploads of all directories, it is created by the daemon and scan
+	uploadPool       *immichserver.WorkerPool
+	keepChangedFiles bool
+	stateFile        string
+	shutdownTimeout  time.Duration
+	timeouts         immichserver.Timeouts
+	quietPeriod      time.Duration
+	hashWorkers      int
+	// hashLimiter caps the read throughput of hashing in all directories, hash-rate-limit in MB/s
+	hashLimiter    = immichserver.NewRateLimiter(0)
+	rescanSchedule schedule.Schedule
+	scheduleJitter time.Duration
+	tempPatterns   []string
+	daemonStop     = make(chan os.Signal, 1)
+	daemonReload   = make(chan os.Signal, 1)
+	// daemonCtx is cancelled when the daemon stops waiting for running uploads at shutdown
+	daemonCtx, cancelDaemon = context.WithCancel(context.Background())
 )
 
-func scanAll(imageDirs []*immichserver.ImageDirectory) {
-	for _, dir := range imageDirs {
-		log.Printf("Scanning directory %s...\n", dir.Path())
-		read, err := dir.Read()
-		if err != nil {
-			log.Println(err)
-			continue
-		} else {
-			log.Printf("Found %d new/updated files in %s.\n", read, dir.Path())
+// scanAll reads all directories and queues their new and changed files for upload.
+func scanAll(ctx context.Context) {
+	for _, server := range sortedServers() {
+		scanServer(ctx, server)
+	}
+}
+
+func scanServer(ctx context.Context, server *immichserver.ImmichServer) {
+	for _, dir := range server.Directories() {
+		slog.Info("scanning directory", "op", "scan", "dir", dir.Path())
+		started, err := dir.Rescan(ctx, server, uploadPool, keepChangedFiles)
+		if !started {
+			slog.Info("skipping directory, its previous scan is still running", "op", "scan", "dir", dir.Path())
+		} else if err != nil {
+			slog.Error("failed to scan directory", "op", "scan", "dir", dir.Path(), "err", err)
+		}
+	}
+}
+
+// addImageDirectory creates the directory for a watch entry and adds it to the server of its profile.
+func addImageDirectory(ctx context.Context, cfg immichserver.ImageDirectoryConfig) (*immichserver.ImmichServer, *immichserver.ImageDirectory, error) {
+	server, err := serverByProfile(cfg.Server)
+	if err != nil {
+		return nil, nil, err
+	}
+	idir := newImageDirectory(ctx, server, cfg)
+	server.AddDirectory(idir)
+	return server, idir, nil
+}
+
+func newImageDirectory(ctx context.Context, server *immichserver.ImmichServer, cfg immichserver.ImageDirectoryConfig) *immichserver.ImageDirectory {
+	idir := immichserver.NewImageDirectory(cfg.Path, false)
+	if len(cfg.Album) > 0 {
+		albumUUID, err := server.GetAlbumByUUIDOrName(ctx, cfg.Album)
+		if err == nil {
+			idir.SetAlbum(&albumUUID)
 		}
-		dir.Upload(server, concurrentUploads, keepChangedFiles)
 	}
+	idir.SetStackConfig(cfg.Stack)
+	interval, _ := pollInterval(cfg)
+	idir.SetPollInterval(interval)
+	idir.SetWriteDetection(quietPeriod, tempPatterns)
+	idir.SetPriority(cfg.Priority)
+	idir.SetHashing(hashWorkers, hashLimiter)
+	idir.SetSchedule(directorySchedule(cfg), scheduleJitter)
+	idir.SetGeotag(cfg.GPX)
+	dates, _ := dateCorrection(cfg.TimeOffset, cfg.Timezone)
+	idir.SetDateCorrection(dates)
+	visibility, _ := immichserver.ParseVisibility(cfg.Visibility)
+	idir.SetUploadOptions(immichserver.UploadOptions{
+		Favorite:   cfg.Favorite,
+		Visibility: visibility,
+	})
+	return &idir
+}
+
+// startImageDirectory reads the directory and starts watching it for changes.
+func startImageDirectory(ctx context.Context, server *immichserver.ImmichServer, dir *immichserver.ImageDirectory) {
+	i, err := dir.Read(ctx)
+	if err != nil {
+		slog.Error("failed to scan directory", "op", "scan", "dir", dir.Path(), "err", err)
+		return
+	}
+	// Apply options changed while the daemon was stopped to the assets uploaded before
+	if err = dir.UpdateUploadOptions(ctx, server, dir.UploadOptions()); err != nil {
+		slog.Error("failed to apply changed upload options to uploaded assets", "dir", dir.Path(), "err", err)
+	}
+	dir.StartScan(ctx, server, uploadPool, keepChangedFiles)
+	dir.StartSchedule(ctx, server, uploadPool)
+	slog.Info("watching directory", "dir", dir.Path(), "count", i)
 }
 
 var daemonCmd = &cobra.Command{
 	Use:   "daemon",
 	Short: "Daemon mode, opens a unix socket for communication",
 	Run: func(cmd *cobra.Command, args []string) {
-		server = immichserver.NewImmichServer(apiKey, serverURL, deviceID)
-		server.ImageDirs = make([]*immichserver.ImageDirectory, len(watchDirs))
+		uploadPool = immichserver.NewWorkerPool(concurrentUploads)
+		if len(metricsAddr) > 0 {
+			if err := startMetricsServer(metricsAddr); err != nil {
+				fatal("failed to start metrics server", "err", err)
+			}
+		}
 		for i := range watchDirs {
-			idir := immichserver.NewImageDirectory(watchDirs[i].Path, false)
-			if len(watchDirs[i].Album) > 0 {
-				albumUUID, err := server.GetAlbumByUUIDOrName(watchDirs[0].Album)
-				if err == nil {
-					idir.SetAlbum(&albumUUID)
-				}
+			if _, _, err := addImageDirectory(daemonCtx, watchDirs[i]); err != nil {
+				fatal("failed to add watched directory", "dir", watchDirs[i].Path, "err", err)
 			}
-			server.ImageDirs[i] = &idir
 		}
+		if err := immichserver.LoadState(stateFile, sortedServers()); err != nil {
+			slog.Error("failed to load state, all files will be checked again", "path", stateFile, "err", err)
+		}
+		signal.Notify(daemonStop, syscall.SIGTERM, syscall.SIGINT)
+		signal.Notify(daemonReload, syscall.SIGHUP)
+
 		rpcServer := socketrpc.NewRPCServer()
-		rpcServer.RegisterCallback(socketrpc.CmdScanAll, func(s string) (byte, string) {
-			go scanAll(server.ImageDirs)
+		rpcServer.RegisterCallback(socketrpc.CmdScanAll, func(context.Context, string) (byte, string) {
+			go scanAll(daemonCtx)
 			return socketrpc.ErrOk, ""
 		})
-		rpcServer.RegisterCallback(socketrpc.CmdStatus, func(s string) (byte, string) {
-			result := ""
-			for _, d := range server.ImageDirs {
-				result += d.String() + "\n"
-			}
-			if len(result) > 0 {
-				result = result[:len(result)-1]
-			}
-			return socketrpc.ErrOk, result
-		})
+		rpcServer.RegisterCallback(socketrpc.CmdStatus, status)
+		rpcServer.RegisterCallback(socketrpc.CmdDryRun, dryRunDaemon)
 		rpcServer.RegisterCallback(socketrpc.CmdAddDir, addDir)
 		rpcServer.RegisterCallback(socketrpc.CmdRmDir, rmDir)
+		rpcServer.RegisterCallback(socketrpc.CmdFixDates, fixDates)
 		rpcServer.RegisterCallback(socketrpc.CmdUploadFile, uploadFile)
 		rpcServer.RegisterCallback(socketrpc.CmdCreateAlbum, createAlbum)
 		rpcServer.RegisterCallback(socketrpc.CmdAddAlbum, addToAlbum)
 		rpcServer.RegisterCallback(socketrpc.CmdDownloadAlbum, downloadAlbum)
-		rpcServer.Start()
+		rpcServer.RegisterCallback(socketrpc.CmdLogLevel, setLogLevel)
+		rpcServer.RegisterCallback(socketrpc.CmdExit, exitDaemon)
+		rpcServer.RegisterCallback(socketrpc.CmdReload, reloadDaemon)
+		listeners, err := systemd.Listeners()
+		if err != nil {
+			fatal("failed to use sockets passed by systemd", "err", err)
+		}
+		if len(listeners) > 0 {
+			rpcServer.StartWithListener(listeners[0])
+		} else if err := rpcServer.Start(); err != nil {
+			fatal("failed to start RPC server", "err", err)
+		}
 
-		for _, dir := range server.ImageDirs {
-			i, err := dir.Read()
-			if err != nil {
-				continue
+		for _, server := range sortedServers() {
+			for _, dir := range server.Directories() {
+				startImageDirectory(daemonCtx, server, dir)
 			}
-			dir.StartScan(server, keepChangedFiles)
-			fmt.Printf("Watching directory '%s' (currently %d files)", dir.Path(), i)
 		}
-		rpcServer.WaitForExit()
+		watchConfig()
+		if _, err := systemd.Notify("READY=1\nSTATUS=" + daemonStatusLine()); err != nil {
+			slog.Warn("failed to notify systemd", "err", err)
+		}
+		runMainLoop()
+		shutdown(&rpcServer)
 	},
 }
 
-func addDir(path string) (byte, string) {
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stops the running daemon after finishing running uploads",
+	Run: func(cmd *cobra.Command, args []string) {
+		rpcClient, err := socketrpc.NewRPCClient()
+		if err != nil {
+			fmt.Println("Service daemon not running.")
+			return
+		}
+		defer rpcClient.Close()
+		if _, err = rpcClient.SendMessage(socketrpc.CmdExit, ""); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Daemon is shutting down.")
+	},
+}
+
+const (
+	// checkpointInterval is how often the daemon checks for finished scans and upload batches to save the state
+	checkpointInterval = 10 * time.Second
+	// stateSaveInterval is how often the state is saved while uploads are running, so a crash loses little
+	stateSaveInterval = 5 * time.Minute
+)
+
+// runMainLoop pings the systemd watchdog while the watchers and schedulers make progress,
+// reports the status and saves the state until the daemon is stopped.
+func runMainLoop() {
+	interval := systemd.WatchdogInterval() / 2
+	watchdog := interval > 0
+	if !watchdog {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	checkpoint := time.NewTicker(checkpointInterval)
+	defer checkpoint.Stop()
+	lastSave := time.Now()
+	for {
+		select {
+		case <-checkpoint.C:
+			if stateChanged() || time.Since(lastSave) >= stateSaveInterval {
+				saveState()
+				lastSave = time.Now()
+			}
+		case sig := <-daemonStop:
+			slog.Info("stopping daemon", "signal", sig.String())
+			return
+		case <-daemonReload:
+			if err := reloadConfig(); err != nil {
+				slog.Error("rejected config, keeping the old one", "err", err)
+			}
+		case <-ticker.C:
+			state := "STATUS=" + daemonStatusLine()
+			if watchdog {
+				// A stuck daemon is restarted by systemd once the pings stop
+				if err := checkLiveness(); err != nil {
+					slog.Error("daemon is stuck, not pinging the watchdog", "err", err)
+				} else {
+					state = "WATCHDOG=1\n" + state
+				}
+			}
+			if _, err := systemd.Notify(state); err != nil {
+				slog.Warn("failed to notify systemd", "err", err)
+			}
+		}
+	}
+}
+
+// shutdown stops accepting requests and watching directories, waits for running uploads
+// and saves the state, uploads still running after the timeout are retried on the next start.
+func shutdown(rpcServer *socketrpc.RPCServer) {
+	systemd.Notify("STOPPING=1")
+	rpcServer.Close()
+	for _, dir := range allImageDirs() {
+		dir.Stop()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, dir := range allImageDirs() {
+		if err := dir.Wait(ctx); err != nil {
+			slog.Warn("uploads still running at shutdown, they will be retried on the next start", "dir", dir.Path())
+		}
+	}
+	// Abort the remaining requests, cancelled uploads return their files to the queue
+	cancelDaemon()
+	for _, dir := range allImageDirs() {
+		dir.WaitForUploads(time.Second)
+	}
+	uploadPool.Close()
+	saveState()
+	slog.Info("daemon stopped")
+}
+
+// stateChanged reports whether a scan or a batch of uploads of any directory finished since the last call.
+func stateChanged() bool {
+	changed := false
+	for _, dir := range allImageDirs() {
+		// Every directory is asked, so none reports the same change again
+		changed = dir.StateChanged() || changed
+	}
+	return changed
+}
+
+func saveState() {
+	if err := immichserver.SaveState(stateFile, sortedServers()); err != nil {
+		slog.Error("failed to save state", "path", stateFile, "err", err)
+	}
+}
+
+func exitDaemon(context.Context, string) (byte, string) {
+	select {
+	case daemonStop <- syscall.SIGTERM:
+	default: // Shutdown already requested
+	}
+	return socketrpc.ErrOk, ""
+}
+
+// checkLiveness returns an error for every directory whose watcher or scheduler is stuck.
+func checkLiveness() error {
+	var errs []error
+	for _, dir := range allImageDirs() {
+		errs = append(errs, dir.CheckLiveness(immichserver.DefaultStallTimeout))
+	}
+	return errors.Join(errs...)
+}
+
+func daemonStatusLine() string {
+	pending := 0
+	dirs := allImageDirs()
+	for _, dir := range dirs {
+		pending += dir.Pending()
+	}
+	return fmt.Sprintf("Watching %d directories, %d files queued for upload", len(dirs), pending)
+}
+
+func allImageDirs() []*immichserver.ImageDirectory {
+	dirs := make([]*immichserver.ImageDirectory, 0)
+	for _, server := range sortedServers() {
+		dirs = append(dirs, server.Directories()...)
+	}
+	return dirs
+}
+
+func status(ctx context.Context, path string) (byte, string) {
+	var result any
+	if len(path) == 0 {
+		// Checked concurrently, so the answer takes at most one StatusTimeout
+		sorted := sortedServers()
+		statuses := make([]immichserver.ServerStatus, len(sorted))
+		var wg sync.WaitGroup
+		for n, server := range sorted {
+			wg.Go(func() { statuses[n] = server.Status(ctx) })
+		}
+		wg.Wait()
+		result = statuses
+	} else {
+		for _, d := range allImageDirs() {
+			if fileStatus, ok := d.FileStatus(path); ok {
+				result = fileStatus
+				break
+			}
+		}
+		if result == nil {
+			return socketrpc.ErrFileNotFound, fmt.Sprintf("'%s' is not tracked by immich-sync", path)
+		}
+	}
+	response, err := json.Marshal(result)
+	if err != nil {
+		return socketrpc.ErrGeneric, err.Error()
+	}
+	return socketrpc.ErrOk, string(response)
+}
+
+func addDir(ctx context.Context, arg string) (byte, string) {
+	var request socketrpc.AddDirRequest
+	if err := json.Unmarshal([]byte(arg), &request); err != nil {
+		return socketrpc.ErrWrongArgs, "Could not decode request"
+	}
+	path := request.Path
 	stat, err := os.Stat(path)
 	if err != nil {
 		return socketrpc.ErrFileNotFound, err.Error()
@@ -98,13 +369,18 @@ func addDir(path string) (byte, string) {
 	if !stat.IsDir() {
 		return socketrpc.ErrWrongArgs, fmt.Sprintf("'%s' is not a directory", path)
 	}
-	iDir := immichserver.NewImageDirectory(path, false)
-	server.ImageDirs = append(server.ImageDirs, &iDir)
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	server, iDir, err := addImageDirectory(ctx, immichserver.ImageDirectoryConfig{Path: path, Server: request.Server})
+	if err != nil {
+		return socketrpc.ErrWrongArgs, err.Error()
+	}
+	go startImageDirectory(daemonCtx, server, iDir)
 	updateConfig()
 	return socketrpc.ErrOk, ""
 }
 
-func rmDir(path string) (byte, string) {
+func rmDir(_ context.Context, path string) (byte, string) {
 	stat, err := os.Stat(path)
 	if err != nil {
 		return socketrpc.ErrFileNotFound, err.Error()
@@ -112,9 +388,11 @@ func rmDir(path string) (byte, string) {
 	if !stat.IsDir() {
 		return socketrpc.ErrWrongArgs, fmt.Sprintf("'%s' is not a directory", path)
 	}
-	for i := range server.ImageDirs {
-		if server.ImageDirs[i].Path() == path {
-			server.ImageDirs = slices.Delete(server.ImageDirs, i, i+1)
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	for _, server := range sortedServers() {
+		if dir := server.RemoveDirectory(path); dir != nil {
+			dir.Stop()
 			updateConfig()
 			return socketrpc.ErrOk, ""
 		}
@@ -122,58 +400,113 @@ func rmDir(path string) (byte, string) {
 	return socketrpc.ErrGeneric, fmt.Sprintf("'%s' is not watched by immich-sync and could not be removed.", path)
 }
 
-func createAlbum(albumName string) (byte, string) {
-	_, err := server.CreateNewAlbum(albumName)
+func fixDates(ctx context.Context, arg string) (byte, string) {
+	var request socketrpc.FixDatesRequest
+	if err := json.Unmarshal([]byte(arg), &request); err != nil {
+		return socketrpc.ErrWrongArgs, "Could not decode request"
+	}
+	for _, server := range sortedServers() {
+		for _, dir := range server.Directories() {
+			if dir.Path() != request.Path {
+				continue
+			}
+			dates := dir.DateCorrection()
+			offset, timezone := formatTimeOffset(dates.Offset), formatTimezone(dates.Location)
+			if request.TimeOffset != nil {
+				offset = *request.TimeOffset
+			}
+			if request.Timezone != nil {
+				timezone = *request.Timezone
+			}
+			dates, err := dateCorrection(offset, timezone)
+			if err != nil {
+				return socketrpc.ErrWrongArgs, err.Error()
+			}
+			fixed, skipped, err := dir.FixDates(ctx, server, dates)
+			if err != nil {
+				return socketrpc.ErrGeneric, fmt.Sprintf("Corrected %d files, %d without capture time, failed: %s", fixed, skipped, err)
+			}
+			return socketrpc.ErrOk, fmt.Sprintf("Corrected %d files, %d without capture time", fixed, skipped)
+		}
+	}
+	return socketrpc.ErrWrongArgs, fmt.Sprintf("'%s' is not watched by immich-sync", request.Path)
+}
+
+func createAlbum(ctx context.Context, arg string) (byte, string) {
+	var request socketrpc.CreateAlbumRequest
+	if err := json.Unmarshal([]byte(arg), &request); err != nil {
+		return socketrpc.ErrWrongArgs, "Could not decode request"
+	}
+	server, err := serverByProfile(request.Server)
+	if err != nil {
+		return socketrpc.ErrWrongArgs, err.Error()
+	}
+	_, err = server.CreateNewAlbum(ctx, request.Album)
 	if err != nil {
 		return socketrpc.ErrGeneric, err.Error()
 	}
 	return socketrpc.ErrOk, ""
 }
 
-func addToAlbum(args string) (byte, string) {
-	splitArgs := strings.Split(args, "//")
-	if len(splitArgs) != 2 {
-		return socketrpc.ErrWrongArgs, ""
+func addToAlbum(ctx context.Context, arg string) (byte, string) {
+	var request socketrpc.AddToAlbumRequest
+	if err := json.Unmarshal([]byte(arg), &request); err != nil {
+		return socketrpc.ErrWrongArgs, "Could not decode request"
+	}
+	path, albumName := request.Path, request.Album
+	server, err := serverByPath(path)
+	if err != nil {
+		return socketrpc.ErrGeneric, err.Error()
 	}
-	path, albumName := splitArgs[0], splitArgs[1]
 	imageUUID, err := server.GetImageUUIDByPath(path)
 	if err != nil {
 		return socketrpc.ErrGeneric, err.Error()
 	}
-	albumUUID, err := server.GetAlbumByUUIDOrName(albumName)
+	albumUUID, err := server.GetAlbumByUUIDOrName(ctx, albumName)
 	if err != nil {
 		return socketrpc.ErrGeneric, err.Error()
 	}
-	err = server.AddToAlbum([]uuid.UUID{imageUUID}, albumUUID)
+	err = server.AddToAlbum(ctx, []uuid.UUID{imageUUID}, albumUUID)
 	if err != nil {
 		return socketrpc.ErrGeneric, err.Error()
 	}
 	return socketrpc.ErrOk, ""
 }
 
-func downloadAlbum(args string) (byte, string) {
-	splitArgs := strings.Split(args, "//")
-	if len(splitArgs) != 2 {
-		return socketrpc.ErrWrongArgs, ""
+func downloadAlbum(ctx context.Context, arg string) (byte, string) {
+	var request socketrpc.DownloadAlbumRequest
+	if err := json.Unmarshal([]byte(arg), &request); err != nil {
+		return socketrpc.ErrWrongArgs, "Could not decode request"
+	}
+	albumName, path := request.Album, request.Path
+	server, err := serverByProfile(request.Server)
+	if err != nil {
+		return socketrpc.ErrWrongArgs, err.Error()
 	}
-	albumName, path := splitArgs[0], splitArgs[1]
 
 	// Download album
-	albumUUID, err := server.GetAlbumByUUIDOrName(albumName)
+	albumUUID, err := server.GetAlbumByUUIDOrName(ctx, albumName)
+	if err != nil {
+		return socketrpc.ErrGeneric, err.Error()
+	}
+	album, err := server.Album(ctx, albumUUID)
 	if err != nil {
 		return socketrpc.ErrGeneric, err.Error()
 	}
-	album, _ := server.Album(albumUUID)
 	for _, asset := range album.Assets {
 		if asset.IsTrashed || asset.IsArchived {
 			continue
 		}
 		imageUUID, err := uuid.Parse(asset.ID)
 		if err != nil {
-			log.Println(err)
+			slog.Warn("album contains invalid asset id", "op", "download", "album_id", albumUUID.String(), "asset_id", asset.ID)
 			continue
 		}
-		err = server.Download(path, imageUUID)
+		err = server.Download(ctx, path, imageUUID)
+		if ctx.Err() != nil {
+			slog.Info("album download cancelled", "op", "download", "album_id", albumUUID.String())
+			return socketrpc.ErrGeneric, "download cancelled"
+		}
 		if err != nil {
 			if os.IsNotExist(err) {
 				return socketrpc.ErrFileNotFound, ""
@@ -185,12 +518,16 @@ func downloadAlbum(args string) (byte, string) {
 	return socketrpc.ErrOk, ""
 }
 
-func uploadFile(arg string) (byte, string) {
+func uploadFile(ctx context.Context, arg string) (byte, string) {
 	var uploadRequest socketrpc.UploadFileRequest
 	err := json.Unmarshal([]byte(arg), &uploadRequest)
 	if err != nil {
 		return socketrpc.ErrWrongArgs, "Could not decode request"
 	}
+	server, err := serverByProfile(uploadRequest.Server)
+	if err != nil {
+		return socketrpc.ErrWrongArgs, err.Error()
+	}
 
 	for _, path := range uploadRequest.Paths {
 		stat, err := os.Stat(path)
@@ -201,14 +538,45 @@ func uploadFile(arg string) (byte, string) {
 			return socketrpc.ErrWrongArgs, fmt.Sprintf("'%s' is a directory, this is not currently supported", path)
 		}
 	}
+	var tagger *geotag.Tagger
+	if len(uploadRequest.GPX) > 0 {
+		tagger = geotag.NewTagger(geotag.Config{Tracks: uploadRequest.GPX, Offset: uploadRequest.GPXOffset})
+	}
+	// Requested uploads take the next free upload slots, before watched and scanned files
+	uuids := make([]uuid.UUID, len(uploadRequest.Paths))
+	errs := make([]error, len(uploadRequest.Paths))
+	var wg sync.WaitGroup
+	for n, path := range uploadRequest.Paths {
+		wg.Add(1)
+		submitted := uploadPool.Submit(immichserver.JobRequest, 0, func() {
+			defer wg.Done()
+			if errs[n] = ctx.Err(); errs[n] != nil {
+				return
+			}
+			slog.Info("uploading file", "op", "upload", "path", path)
+			var idString string
+			if idString, errs[n] = server.Upload(ctx, path, nil, time.Time{}, immichserver.UploadOptions{}); errs[n] == nil {
+				uuids[n], errs[n] = uuid.Parse(idString)
+			}
+			if errs[n] == nil && tagger != nil {
+				if _, err := server.Geotag(ctx, uuids[n], path, tagger); err != nil {
+					slog.Error("uploaded file, but could not geotag it", "op", "geotag", "path", path, "err", err)
+				}
+			}
+		})
+		if !submitted {
+			errs[n] = errors.New("daemon is shutting down")
+			wg.Done()
+		}
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		slog.Info("upload cancelled", "op", "upload")
+		return socketrpc.ErrGeneric, "upload cancelled"
+	}
 	success, failed := 0, 0
-	uuids := make([]uuid.UUID, 0)
-	for _, path := range uploadRequest.Paths {
-		log.Printf("Uploading %s\n", path)
-		idString, err := server.Upload(path, nil)
-		uploadedUUID, err2 := uuid.Parse(idString)
-		uuids = append(uuids, uploadedUUID)
-		if err != nil || err2 != nil {
+	for _, err := range errs {
+		if err != nil {
 			failed += 1
 		} else {
 			success += 1
@@ -219,11 +587,11 @@ func uploadFile(arg string) (byte, string) {
 		return socketrpc.ErrGeneric, answer
 	}
 	if len(uploadRequest.Album) > 0 {
-		albumUUID, err := server.GetAlbumByUUIDOrName(uploadRequest.Album)
+		albumUUID, err := server.GetAlbumByUUIDOrName(ctx, uploadRequest.Album)
 		if err != nil {
 			return socketrpc.ErrGeneric, err.Error()
 		}
-		err = server.AddToAlbum(uuids, albumUUID)
+		err = server.AddToAlbum(ctx, uuids, albumUUID)
 		if err != nil {
 			return socketrpc.ErrGeneric, fmt.Sprintf("could not add image to album: %s", err.Error())
 		}
@@ -231,14 +599,85 @@ func uploadFile(arg string) (byte, string) {
 	return socketrpc.ErrOk, answer
 }
 
+func setLogLevel(_ context.Context, level string) (byte, string) {
+	if len(level) == 0 {
+		return socketrpc.ErrOk, logLevel.Level().String()
+	}
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return socketrpc.ErrWrongArgs, fmt.Sprintf("unknown log level '%s', expected debug, info, warn or error", level)
+	}
+	slog.Info("changed log level", "level", logLevel.Level().String())
+	return socketrpc.ErrOk, logLevel.Level().String()
+}
+
+func formatPollInterval(interval time.Duration) string {
+	if interval == 0 {
+		return ""
+	}
+	return interval.String()
+}
+
+// formatTimeOffset returns the time_offset of a watch entry, empty if the camera clock is not corrected.
+func formatTimeOffset(offset time.Duration) string {
+	if offset == 0 {
+		return ""
+	}
+	return offset.String()
+}
+
+func formatTimezone(loc *time.Location) string {
+	if loc == nil {
+		return ""
+	}
+	return loc.String()
+}
+
+// formatSchedule returns the schedule of a watch entry, empty if the directory uses the global one.
+func formatSchedule(s schedule.Schedule) string {
+	switch {
+	case s == nil && rescanSchedule == nil:
+		return ""
+	case s == nil:
+		return "off"
+	case rescanSchedule != nil && s.String() == rescanSchedule.String():
+		return ""
+	}
+	return s.String()
+}
+
 func updateConfig() {
 	paths := []immichserver.ImageDirectoryConfig{}
-	for i := range server.ImageDirs {
-		paths = append(paths, immichserver.ImageDirectoryConfig{
-			Path:  server.ImageDirs[i].Path(),
-			Album: server.ImageDirs[i].AlbumUUID(),
-		})
+	for _, server := range sortedServers() {
+		profile := server.Profile
+		if profile == defaultProfile {
+			profile = ""
+		}
+		for _, dir := range server.Directories() {
+			paths = append(paths, immichserver.ImageDirectoryConfig{
+				Path:       dir.Path(),
+				Server:     profile,
+				Album:      dir.AlbumUUID(),
+				Stack:      dir.StackConfig(),
+				Favorite:   dir.UploadOptions().Favorite,
+				Visibility: string(dir.UploadOptions().Visibility),
+				Poll:       formatPollInterval(dir.PollInterval()),
+				Priority:   dir.Priority(),
+				Schedule:   formatSchedule(dir.Schedule()),
+				GPX:        dir.GeotagConfig(),
+				TimeOffset: formatTimeOffset(dir.DateCorrection().Offset),
+				Timezone:   formatTimezone(dir.DateCorrection().Location),
+			})
+		}
+	}
+	// Use a separate instance, values set on the global one would override later reloads
+	v := viper.New()
+	v.SetConfigFile(viper.ConfigFileUsed())
+	if err := v.ReadInConfig(); err != nil {
+		slog.Error("failed to update config file", "path", viper.ConfigFileUsed(), "err", err)
+		return
+	}
+	v.Set("watch", paths)
+	if err := v.WriteConfig(); err != nil {
+		slog.Error("failed to update config file", "path", viper.ConfigFileUsed(), "err", err)
 	}
-	viper.Set("watch", paths)
-	viper.WriteConfig()
 }
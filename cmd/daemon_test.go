@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/JonaEnz/immich-sync/immichtest"
+	"github.com/JonaEnz/immich-sync/socketrpc"
+)
+
+// useFakeServer configures the default profile to use a fake Immich server.
+func useFakeServer(t *testing.T) *immichtest.Server {
+	fake := immichtest.NewServer(t)
+	serverProfiles = map[string]immichserver.ServerConfig{
+		defaultProfile: {Server: fake.URL, APIKey: fake.APIKey, DeviceID: "test-device"},
+	}
+	servers = make(map[string]*immichserver.ImmichServer)
+	concurrentUploads = 2
+	uploadPool = immichserver.NewWorkerPool(concurrentUploads)
+	t.Cleanup(func() {
+		uploadPool.Close()
+		serverProfiles = nil
+		servers = make(map[string]*immichserver.ImmichServer)
+	})
+	return fake
+}
+
+func TestUploadFileRPC(t *testing.T) {
+	fake := useFakeServer(t)
+	albumID := fake.AddAlbum("Trip")
+	path := filepath.Join(t.TempDir(), "a.jpg")
+	os.WriteFile(path, []byte("a"), 0o600)
+
+	request, _ := json.Marshal(socketrpc.UploadFileRequest{Paths: []string{path}, Album: "Trip"})
+	if code, answer := uploadFile(context.Background(), string(request)); code != socketrpc.ErrOk {
+		t.Fatalf("Expected upload to succeed, got %d: %s", code, answer)
+	}
+	album, _ := fake.Album(albumID)
+	if len(fake.Assets()) != 1 || len(album.AssetIDs) != 1 || album.AssetIDs[0] != fake.Assets()[0].ID {
+		t.Errorf("Expected the uploaded file in album Trip, got assets %v and album %v", fake.Assets(), album.AssetIDs)
+	}
+
+	request, _ = json.Marshal(socketrpc.UploadFileRequest{Paths: []string{path}, Server: "unknown"})
+	if code, _ := uploadFile(context.Background(), string(request)); code != socketrpc.ErrWrongArgs {
+		t.Errorf("Expected an unknown server profile to be rejected, got %d", code)
+	}
+}
+
+func TestUploadFileRPCGeotag(t *testing.T) {
+	fake := useFakeServer(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.jpg")
+	os.WriteFile(path, immichtest.JPEG("2024:07:14 11:01:00", "+00:00", false), 0o600)
+	track := filepath.Join(dir, "track.gpx")
+	os.WriteFile(track, []byte(`<gpx><trk><trkseg>
+		<trkpt lat="47.0" lon="11.0"><time>2024-07-14T11:00:00Z</time></trkpt>
+		<trkpt lat="47.2" lon="11.2"><time>2024-07-14T11:02:00Z</time></trkpt>
+	</trkseg></trk></gpx>`), 0o600)
+
+	request, _ := json.Marshal(socketrpc.UploadFileRequest{Paths: []string{path}, GPX: []string{track}})
+	if code, answer := uploadFile(context.Background(), string(request)); code != socketrpc.ErrOk {
+		t.Fatalf("Expected upload to succeed, got %d: %s", code, answer)
+	}
+	if assets := fake.Assets(); len(assets) != 1 || assets[0].Latitude == nil || *assets[0].Latitude != 47.1 {
+		t.Errorf("Expected the uploaded file to be geotagged, got %+v", assets)
+	}
+}
+
+func TestFixDatesRPC(t *testing.T) {
+	fake := useFakeServer(t)
+	path := t.TempDir()
+	os.WriteFile(filepath.Join(path, "a.jpg"), immichtest.JPEG("2024:07:14 13:05:00", "+02:00", false), 0o600)
+	server, dir, err := addImageDirectory(context.Background(), immichserver.ImageDirectoryConfig{Path: path, TimeOffset: "5m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanServer(context.Background(), server)
+	if !dir.WaitForUploads(10 * time.Second) {
+		t.Fatal("uploads did not finish")
+	}
+	if assets := fake.Assets(); len(assets) != 1 || assets[0].DateTimeOriginal != "2024-07-14T13:00:00+02:00" {
+		t.Fatalf("Expected the capture time corrected by the time_offset, got %+v", assets)
+	}
+
+	offset := "-1h"
+	request, _ := json.Marshal(socketrpc.FixDatesRequest{Path: path, TimeOffset: &offset})
+	if code, answer := fixDates(context.Background(), string(request)); code != socketrpc.ErrOk {
+		t.Fatalf("Expected fixing dates to succeed, got %d: %s", code, answer)
+	}
+	if assets := fake.Assets(); assets[0].DateTimeOriginal != "2024-07-14T14:05:00+02:00" {
+		t.Errorf("Expected the capture time corrected by the given offset, got %s", assets[0].DateTimeOriginal)
+	}
+
+	timezone := "Nowhere/Atlantis"
+	request, _ = json.Marshal(socketrpc.FixDatesRequest{Path: path, Timezone: &timezone})
+	if code, _ := fixDates(context.Background(), string(request)); code != socketrpc.ErrWrongArgs {
+		t.Errorf("Expected an invalid time zone to be rejected, got %d", code)
+	}
+	request, _ = json.Marshal(socketrpc.FixDatesRequest{Path: t.TempDir()})
+	if code, _ := fixDates(context.Background(), string(request)); code != socketrpc.ErrWrongArgs {
+		t.Errorf("Expected a directory that is not watched to be rejected, got %d", code)
+	}
+}
+
+func TestAlbumRPC(t *testing.T) {
+	fake := useFakeServer(t)
+	// Album names may contain the separator of the old argument format
+	create, _ := json.Marshal(socketrpc.CreateAlbumRequest{Album: "Holiday//2024"})
+	if code, answer := createAlbum(context.Background(), string(create)); code != socketrpc.ErrOk {
+		t.Fatalf("Expected album creation to succeed, got %d: %s", code, answer)
+	}
+	if code, _ := createAlbum(context.Background(), string(create)); code != socketrpc.ErrGeneric {
+		t.Errorf("Expected creating an existing album to fail, got %d", code)
+	}
+	if _, ok := fake.AlbumByName("Holiday//2024"); !ok {
+		t.Fatalf("Expected album Holiday//2024 on the server")
+	}
+
+	path := t.TempDir()
+	os.WriteFile(filepath.Join(path, "a.jpg"), []byte("a"), 0o600)
+	server, dir, err := addImageDirectory(context.Background(), immichserver.ImageDirectoryConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanServer(context.Background(), server)
+	if !dir.WaitForUploads(10 * time.Second) {
+		t.Fatal("uploads did not finish")
+	}
+	add, _ := json.Marshal(socketrpc.AddToAlbumRequest{Path: filepath.Join(path, "a.jpg"), Album: "Holiday//2024"})
+	if code, answer := addToAlbum(context.Background(), string(add)); code != socketrpc.ErrOk {
+		t.Fatalf("Expected adding to the album to succeed, got %d: %s", code, answer)
+	}
+
+	target := t.TempDir()
+	download, _ := json.Marshal(socketrpc.DownloadAlbumRequest{Album: "Holiday//2024", Path: target})
+	if code, answer := downloadAlbum(context.Background(), string(download)); code != socketrpc.ErrOk {
+		t.Fatalf("Expected album download to succeed, got %d: %s", code, answer)
+	}
+	album, _ := fake.AlbumByName("Holiday//2024")
+	data, err := os.ReadFile(filepath.Join(target, album.AssetIDs[0]))
+	if err != nil || string(data) != "a" {
+		t.Errorf("Expected the downloaded asset to contain 'a', got '%s' (%v)", data, err)
+	}
+
+	code, answer := status(context.Background(), "")
+	var statuses []immichserver.ServerStatus
+	if code != socketrpc.ErrOk || json.Unmarshal([]byte(answer), &statuses) != nil {
+		t.Fatalf("Expected status to succeed, got %d: %s", code, answer)
+	}
+	if len(statuses) != 1 || !statuses[0].Online || statuses[0].Directories[0].Files[immichserver.FileUploaded] != 1 {
+		t.Errorf("Expected one online server with one uploaded file, got %+v", statuses)
+	}
+}
+
+func TestConcurrentScansAndRPC(t *testing.T) {
+	useFakeServer(t)
+	paths := make([]string, 0)
+	for n := range 3 {
+		path := t.TempDir()
+		for m := range 5 {
+			os.WriteFile(filepath.Join(path, fmt.Sprintf("%d-%d.jpg", n, m)), []byte(fmt.Sprint(n, m)), 0o600)
+		}
+		paths = append(paths, path)
+	}
+
+	var wg sync.WaitGroup
+	for _, path := range paths {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if _, _, err := addImageDirectory(context.Background(), immichserver.ImageDirectoryConfig{Path: path}); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			scanAll(context.Background())
+		}()
+		go func() {
+			defer wg.Done()
+			status(context.Background(), "")
+			status(context.Background(), filepath.Join(path, "0-0.jpg"))
+			daemonStatusLine()
+		}()
+	}
+	wg.Wait()
+	scanAll(context.Background())
+	for _, dir := range allImageDirs() {
+		if !dir.WaitForUploads(10 * time.Second) {
+			t.Fatal("uploads did not finish")
+		}
+	}
+
+	code, answer := status(context.Background(), "")
+	var statuses []immichserver.ServerStatus
+	if code != socketrpc.ErrOk || json.Unmarshal([]byte(answer), &statuses) != nil {
+		t.Fatalf("Expected status to succeed, got %d: %s", code, answer)
+	}
+	if len(statuses[0].Directories) != 3 {
+		t.Fatalf("Expected 3 directories, got %+v", statuses[0].Directories)
+	}
+	for _, dir := range statuses[0].Directories {
+		if dir.Files[immichserver.FileUploaded] != 5 {
+			t.Errorf("Expected all files of %s to be uploaded, got %v", dir.Path, dir.Files)
+		}
+	}
+}
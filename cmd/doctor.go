@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/JonaEnz/immich-sync/geotag"
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/JonaEnz/immich-sync/socketrpc"
+	"github.com/google/uuid"
+	"github.com/ogen-go/ogen/validate"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	knownConfigKeys    = sectionConfigKeys("")
+	knownTimeoutKeys   = sectionConfigKeys("timeouts")
+	knownWatchKeys     = sectionKeys[immichserver.ImageDirectoryConfig]()
+	knownStackKeys     = sectionKeys[immichserver.StackConfig]()
+	knownGPXKeys       = sectionKeys[geotag.Config]()
+	knownProfileKeys   = sectionKeys[immichserver.ServerConfig]()
+	knownTransportKeys = sectionKeys[immichserver.TransportConfig]()
+)
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks the config, the watched directories, the Immich servers and the daemon",
+	// The config is checked by the command itself
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		d := &doctor{out: os.Stdout}
+		d.checkConfig()
+		if configErr == nil {
+			d.checkWatchPaths()
+			d.checkServers(cmd.Context())
+		}
+		d.checkSocket()
+		if d.failed > 0 {
+			fmt.Printf("\n%d problem(s) found.\n", d.failed)
+			os.Exit(1)
+		}
+		fmt.Println("\nNo problems found.")
+	},
+}
+
+type doctor struct {
+	out    io.Writer
+	warned int
+	failed int
+}
+
+func (d *doctor) ok(format string, args ...any) {
+	fmt.Fprintf(d.out, "[ OK ] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) warn(fix string, format string, args ...any) {
+	d.warned++
+	fmt.Fprintf(d.out, "[WARN] %s\n", fmt.Sprintf(format, args...))
+	if len(fix) > 0 {
+		fmt.Fprintf(d.out, "       fix: %s\n", fix)
+	}
+}
+
+func (d *doctor) fail(fix string, format string, args ...any) {
+	d.failed++
+	fmt.Fprintf(d.out, "[FAIL] %s\n", fmt.Sprintf(format, args...))
+	if len(fix) > 0 {
+		fmt.Fprintf(d.out, "       fix: %s\n", fix)
+	}
+}
+
+func (d *doctor) checkConfig() {
+	if len(viper.ConfigFileUsed()) == 0 {
+		d.fail("create $HOME/.config/immich-sync/config.yaml or /etc/immich-sync/config.yaml, or pass --config",
+			"no config file found")
+		return
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		d.fail("fix the syntax of the config file", "config file %s could not be read: %s", viper.ConfigFileUsed(), err)
+		return
+	}
+	d.ok("config file %s", viper.ConfigFileUsed())
+
+	d.checkKeys("", viper.AllSettings(), knownConfigKeys)
+	d.checkKeys("timeouts.", viper.Get("timeouts"), knownTimeoutKeys)
+	d.checkKeys("transport.", viper.Get("transport"), knownTransportKeys)
+	if profiles, ok := viper.Get("servers").(map[string]any); ok {
+		for name, profile := range profiles {
+			d.checkKeys(fmt.Sprintf("servers.%s.", name), profile, knownProfileKeys)
+			if m, ok := profile.(map[string]any); ok {
+				d.checkKeys(fmt.Sprintf("servers.%s.transport.", name), m["transport"], knownTransportKeys)
+			}
+		}
+	}
+	if entries, ok := viper.Get("watch").([]any); ok {
+		for n, entry := range entries {
+			d.checkKeys(fmt.Sprintf("watch[%d].", n), entry, knownWatchKeys)
+			if m, ok := entry.(map[string]any); ok {
+				d.checkKeys(fmt.Sprintf("watch[%d].stack.", n), m["stack"], knownStackKeys)
+				d.checkKeys(fmt.Sprintf("watch[%d].gpx.", n), m["gpx"], knownGPXKeys)
+			}
+		}
+	}
+
+	if configErr != nil {
+		d.fail("correct the config entries named in the error", "invalid config: %s", configErr)
+		return
+	}
+	for name, profile := range serverProfiles {
+		if !strings.HasSuffix(strings.TrimRight(profile.Server, "/"), "/api") {
+			d.warn(fmt.Sprintf("set the server url to '%s/api'", strings.TrimRight(profile.Server, "/")),
+				"server url of profile '%s' does not end in /api", name)
+		}
+	}
+}
+
+// checkKeys reports keys of a config section that are not used by immich-sync, usually typos.
+func (d *doctor) checkKeys(prefix string, section any, known []string) {
+	m, ok := section.(map[string]any)
+	if !ok {
+		return
+	}
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		if !slices.Contains(known, key) {
+			d.warn(fmt.Sprintf("remove '%s%s' or use one of: %s", prefix, key, strings.Join(known, ", ")),
+				"unknown config key '%s%s'", prefix, key)
+		}
+	}
+}
+
+func (d *doctor) checkWatchPaths() {
+	if len(watchDirs) == 0 {
+		d.warn("add directories to 'watch' or use 'immich-sync watch add <path>'", "no directories are watched")
+	}
+	for _, w := range watchDirs {
+		stat, err := os.Stat(w.Path)
+		if err != nil {
+			d.fail("create the directory or remove it from 'watch'", "%s: %s", w.Path, err)
+			continue
+		}
+		if !stat.IsDir() {
+			d.fail("watch the directory containing the file instead", "%s is not a directory", w.Path)
+			continue
+		}
+		dir, err := os.Open(w.Path)
+		if err == nil {
+			_, err = dir.Readdirnames(1)
+			dir.Close()
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			d.fail("give the user running immich-sync read access to the directory", "%s is not readable: %s", w.Path, err)
+			continue
+		}
+		d.ok("%s is readable", w.Path)
+		for _, track := range w.GPX.Tracks {
+			if _, err := os.Stat(track); err != nil {
+				d.warn("create the directory of the GPX tracks or remove it from 'gpx'", "%s: GPX tracks: %s", w.Path, err)
+			}
+		}
+	}
+}
+
+func (d *doctor) checkServers(ctx context.Context) {
+	for _, name := range slices.Sorted(maps.Keys(serverProfiles)) {
+		server, err := serverByProfile(name)
+		if err != nil {
+			d.fail("fix the server profile", "%s", err)
+			continue
+		}
+		if err := server.Ping(ctx); err != nil {
+			d.fail("check the server url (including the trailing /api) and that Immich is reachable from this host",
+				"server '%s' (%s) is not reachable: %s", name, server.URL(), err)
+			continue
+		}
+		d.ok("server '%s' (%s) is reachable", name, server.URL())
+
+		missing, err := server.MissingPermissions(ctx)
+		var statusErr *validate.UnexpectedStatusCodeError
+		switch {
+		case errors.As(err, &statusErr) && statusErr.StatusCode == 401:
+			d.fail("create a new API key at <immich>/user-settings?isOpen=api-keys",
+				"API key of server '%s' was rejected", name)
+			continue
+		case err != nil:
+			d.fail("check the API key of the profile", "API key of server '%s' could not be checked: %s", name, err)
+			continue
+		case len(missing) > 0:
+			names := make([]string, len(missing))
+			for i := range missing {
+				names[i] = string(missing[i])
+			}
+			d.fail("grant the permissions to the key or create a key with all permissions",
+				"API key of server '%s' is missing permissions: %s", name, strings.Join(names, ", "))
+		default:
+			d.ok("API key of server '%s' has all required permissions", name)
+		}
+
+		version, err := server.Version(ctx)
+		switch {
+		case err != nil:
+			d.fail("check that the server is an Immich server", "version of server '%s' could not be determined: %s", name, err)
+		case !version.IsMinimumVersion(immichserver.MinCopyMetadataVersion):
+			d.warn(fmt.Sprintf("upgrade Immich to %s or later", immichserver.MinCopyMetadataVersion),
+				"server '%s' runs Immich %s, albums and favorites are not copied to replaced assets before %s",
+				name, version, immichserver.MinCopyMetadataVersion)
+		default:
+			d.ok("server '%s' runs Immich %s", name, version)
+		}
+
+		d.checkAlbums(ctx, name, server)
+	}
+}
+
+// checkAlbums reports watch entries whose album no longer exists.
+func (d *doctor) checkAlbums(ctx context.Context, name string, server *immichserver.ImmichServer) {
+	for _, w := range watchDirs {
+		if len(w.Album) == 0 {
+			continue
+		}
+		if profile, _ := profileName(w.Server); profile != name {
+			continue
+		}
+		albumUUID, err := server.GetAlbumByUUIDOrName(ctx, w.Album)
+		if err == nil && albumUUID != uuid.Nil {
+			_, err = server.Album(ctx, albumUUID)
+		}
+		if err != nil {
+			d.fail(fmt.Sprintf("create the album with 'immich-sync album create \"%s\"' or change 'album' of the entry", w.Album),
+				"album '%s' of %s not found on server '%s'", w.Album, w.Path, name)
+			continue
+		}
+		d.ok("album '%s' of %s exists", w.Album, w.Path)
+	}
+}
+
+func (d *doctor) checkSocket() {
+	path := socketrpc.SocketAddr()
+	if _, err := os.Stat(path); err != nil {
+		d.warn("start the daemon with 'immich-sync daemon' or 'systemctl start immich-sync'", "daemon is not running (no socket at %s)", path)
+		return
+	}
+	rpcClient, err := socketrpc.NewRPCClient()
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) {
+			d.fail(fmt.Sprintf("remove the stale socket with 'rm %s' or restart the daemon", path), "socket %s does not accept connections: %s", path, err)
+		} else {
+			d.fail("check the permissions of the socket", "socket %s: %s", path, err)
+		}
+		return
+	}
+	defer rpcClient.Close()
+	// Reading the log level makes no network calls, so a slow Immich server is not taken for a hung daemon
+	if _, err = rpcClient.SendMessage(socketrpc.CmdLogLevel, ""); err != nil {
+		d.fail("restart the daemon", "daemon does not answer on %s: %s", path, err)
+		return
+	}
+	d.ok("daemon answers on %s", path)
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/JonaEnz/immich-sync/oapi"
+)
+
+func TestDoctorConfig(t *testing.T) {
+	useConfig(t, `
+server: http://immich.local/api
+apikey: key
+scheduel: 30
+watch:
+  - path: /photos
+    albm: Camera
+    gpx:
+      track: [/tracks]
+`)
+	configErr = nil
+	var out strings.Builder
+	d := &doctor{out: &out}
+	d.checkConfig()
+	for _, key := range []string{"'scheduel'", "'watch[0].albm'", "'watch[0].gpx.track'"} {
+		if !strings.Contains(out.String(), "unknown config key "+key) {
+			t.Errorf("Expected %s to be reported, got:\n%s", key, out.String())
+		}
+	}
+	if d.warned != 3 || d.failed != 0 {
+		t.Errorf("Expected 3 warnings, got %d warnings and %d failures:\n%s", d.warned, d.failed, out.String())
+	}
+}
+
+func TestDoctorWatchPaths(t *testing.T) {
+	t.Cleanup(func() { watchDirs = nil })
+	path := t.TempDir()
+	file := filepath.Join(path, "a.jpg")
+	os.WriteFile(file, []byte("a"), 0o600)
+	watchDirs = []immichserver.ImageDirectoryConfig{
+		{Path: path},
+		{Path: filepath.Join(path, "missing")},
+		{Path: file},
+	}
+	var out strings.Builder
+	d := &doctor{out: &out}
+	d.checkWatchPaths()
+	if d.failed != 2 || !strings.Contains(out.String(), "[ OK ] "+path+" is readable") {
+		t.Errorf("Expected the missing directory and the file to fail, got:\n%s", out.String())
+	}
+}
+
+func TestDoctorServers(t *testing.T) {
+	tests := []struct {
+		version     oapi.ServerVersionResponseDto
+		permissions []oapi.Permission
+		output      string
+		warned      int
+		failed      int
+	}{
+		{oapi.ServerVersionResponseDto{Major: 2, Minor: 2}, []oapi.Permission{oapi.PermissionAll}, "[ OK ] server 'default' runs Immich 2.2.0", 0, 0},
+		{oapi.ServerVersionResponseDto{Major: 1, Minor: 135}, []oapi.Permission{oapi.PermissionAll}, "[WARN] server 'default' runs Immich 1.135.0", 1, 0},
+		{oapi.ServerVersionResponseDto{Major: 2, Minor: 2}, []oapi.Permission{oapi.PermissionAssetRead}, "is missing permissions", 0, 1},
+	}
+	for _, test := range tests {
+		fake := useFakeServer(t)
+		fake.Version, fake.Permissions = test.version, test.permissions
+		var out strings.Builder
+		d := &doctor{out: &out}
+		d.checkServers(context.Background())
+		if !strings.Contains(out.String(), test.output) || d.warned != test.warned || d.failed != test.failed {
+			t.Errorf("Expected '%s' with %d warnings and %d failures, got:\n%s", test.output, test.warned, test.failed, out.String())
+		}
+	}
+
+	fake := useFakeServer(t)
+	serverProfiles[defaultProfile] = immichserver.ServerConfig{Server: fake.URL, APIKey: "wrong-key"}
+	var out strings.Builder
+	d := &doctor{out: &out}
+	d.checkServers(context.Background())
+	if d.failed != 1 || !strings.Contains(out.String(), "was rejected") {
+		t.Errorf("Expected the wrong API key to be rejected, got:\n%s", out.String())
+	}
+}
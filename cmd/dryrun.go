@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/JonaEnz/immich-sync/socketrpc"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dryRun     bool
+	dryRunJSON bool
+)
+
+type dryRunResult struct {
+	Files []immichserver.PlannedFile `json:"files"`
+	Bytes int64                      `json:"bytes"`
+}
+
+// addDryRunFlags adds --dry-run and --json to a command that uploads files.
+func addDryRunFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only show what would be uploaded, replaced, skipped or deleted")
+	cmd.Flags().BoolVar(&dryRunJSON, "json", false, "Print the dry run result as JSON")
+}
+
+// dryRunScan plans the upload of all watched directories, starting from the saved file state.
+// It is used when no daemon is running, otherwise the daemon is asked with dryRunRPC.
+func dryRunScan(ctx context.Context) ([]immichserver.PlannedFile, error) {
+	for i := range watchDirs {
+		if _, _, err := addImageDirectory(ctx, watchDirs[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := immichserver.LoadState(stateFile, sortedServers()); err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	planned, err := planAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range watchDirs {
+		if len(w.Album) == 0 {
+			continue
+		}
+		server, _ := serverByProfile(w.Server)
+		if _, err := server.GetAlbumByUUIDOrName(ctx, w.Album); err != nil {
+			fmt.Fprintf(os.Stderr, "album '%s' of %s not found, files would not be added to an album\n", w.Album, w.Path)
+		}
+	}
+	return planned, nil
+}
+
+// dryRunDaemon plans the upload of the directories watched by the daemon from their current state.
+func dryRunDaemon(ctx context.Context, _ string) (byte, string) {
+	planned, err := planAll(ctx)
+	if err != nil {
+		return socketrpc.ErrGeneric, err.Error()
+	}
+	response, err := json.Marshal(planned)
+	if err != nil {
+		return socketrpc.ErrGeneric, err.Error()
+	}
+	return socketrpc.ErrOk, string(response)
+}
+
+// dryRunRPC asks the running daemon for its plan, the daemon may hash files so the answer is not timed out.
+func dryRunRPC(rpcClient *socketrpc.RPCClient) ([]immichserver.PlannedFile, error) {
+	answer, err := rpcClient.SendMessageTimeout(socketrpc.CmdDryRun, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	var planned []immichserver.PlannedFile
+	if err := json.Unmarshal([]byte(answer), &planned); err != nil {
+		return nil, err
+	}
+	return planned, nil
+}
+
+// planAll plans the upload of all directories of all servers.
+func planAll(ctx context.Context) ([]immichserver.PlannedFile, error) {
+	planned := make([]immichserver.PlannedFile, 0)
+	for _, server := range sortedServers() {
+		serverPlan, err := server.PlanDirectories(ctx, keepChangedFiles)
+		if err != nil {
+			return nil, err
+		}
+		planned = append(planned, serverPlan...)
+	}
+	return planned, nil
+}
+
+func printDryRun(planned []immichserver.PlannedFile) {
+	result := dryRunResult{Files: planned, Bytes: immichserver.PlannedBytes(planned)}
+	if dryRunJSON {
+		out, _ := json.Marshal(result)
+		fmt.Println(string(out))
+		return
+	}
+	counts := make(map[immichserver.PlannedAction]int)
+	for _, file := range planned {
+		counts[file.Action] += 1
+		line := fmt.Sprintf("%-16s %s", file.Action, file.Path)
+		switch file.Action {
+		case immichserver.ActionUpload, immichserver.ActionReplace:
+			line += fmt.Sprintf(" (%s)", formatBytes(file.Size))
+		}
+		if len(file.AssetID) > 0 {
+			line += fmt.Sprintf(" asset %s", file.AssetID)
+		}
+		if len(file.Album) > 0 && file.Action != immichserver.ActionDelete {
+			line += fmt.Sprintf(" -> album %s", file.Album)
+		}
+		if len(file.Reason) > 0 {
+			line += fmt.Sprintf(": %s", file.Reason)
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("\n%d to upload, %d to replace, %d duplicates, %d unsupported, %d to delete, %s in total\n",
+		counts[immichserver.ActionUpload], counts[immichserver.ActionReplace], counts[immichserver.ActionSkipDuplicate],
+		counts[immichserver.ActionSkipFile], counts[immichserver.ActionDelete], formatBytes(result.Bytes))
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
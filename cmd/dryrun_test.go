@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/JonaEnz/immich-sync/socketrpc"
+)
+
+func TestDryRunDaemon(t *testing.T) {
+	fake := useFakeServer(t)
+	existing := fake.AddAsset("existing.jpg", []byte("existing"))
+	path := t.TempDir()
+	changed := filepath.Join(path, "a.jpg")
+	os.WriteFile(changed, []byte("a"), 0o600)
+	server, dir, err := addImageDirectory(context.Background(), immichserver.ImageDirectoryConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanServer(context.Background(), server)
+	if !dir.WaitForUploads(10 * time.Second) {
+		t.Fatal("uploads did not finish")
+	}
+	uploaded, _ := dir.FileStatus(changed)
+
+	os.WriteFile(changed, []byte("changed"), 0o600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(changed, later, later)
+	os.WriteFile(filepath.Join(path, "b.jpg"), []byte("b"), 0o600)
+	os.WriteFile(filepath.Join(path, "dup.jpg"), []byte("existing"), 0o600)
+
+	code, answer := dryRunDaemon(context.Background(), "")
+	if code != socketrpc.ErrOk {
+		t.Fatalf("Expected the dry run to succeed, got %d: %s", code, answer)
+	}
+	var planned []immichserver.PlannedFile
+	if err := json.Unmarshal([]byte(answer), &planned); err != nil {
+		t.Fatal(err)
+	}
+	expected := []immichserver.PlannedFile{
+		{Path: changed, Action: immichserver.ActionReplace, AssetID: uploaded.AssetID},
+		{Path: changed, Action: immichserver.ActionDelete, AssetID: uploaded.AssetID},
+		{Path: filepath.Join(path, "b.jpg"), Action: immichserver.ActionUpload},
+		{Path: filepath.Join(path, "dup.jpg"), Action: immichserver.ActionSkipDuplicate, AssetID: existing},
+	}
+	if len(planned) != len(expected) {
+		t.Fatalf("Expected %d planned actions, got %+v", len(expected), planned)
+	}
+	for n, want := range expected {
+		if got := planned[n]; got.Path != want.Path || got.Action != want.Action || got.AssetID != want.AssetID {
+			t.Errorf("Expected planned action %d to be %s %s %s, got %+v", n, want.Action, want.Path, want.AssetID, got)
+		}
+	}
+	if status, _ := dir.FileStatus(changed); status.State != immichserver.FileUploaded {
+		t.Errorf("Expected the dry run not to change the state of the daemon, got %+v", status)
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/JonaEnz/immich-sync/socketrpc"
+	"github.com/spf13/cobra"
+)
+
+var (
+	timeOffsetFlag string
+	timezoneFlag   string
+)
+
+func init() {
+	fixDatesCmd.Flags().StringVar(&timeOffsetFlag, "time-offset", "", "How far the camera clock is ahead of the real time (5m, -1h), instead of the time_offset of the directory")
+	fixDatesCmd.Flags().StringVar(&timezoneFlag, "timezone", "", "Time zone of the camera clock (Europe/Berlin, +01:00), instead of the timezone of the directory")
+	rootCmd.AddCommand(fixDatesCmd)
+}
+
+var fixDatesCmd = &cobra.Command{
+	Use:   "fix-dates <directory>",
+	Short: "Corrects the capture times of the files already uploaded from a watched directory",
+	Long: `Corrects the capture times of the files already uploaded from a watched directory
+with the time_offset and timezone of the directory, or the ones given as flags.
+The capture times are read from the files again, so running it more than once is safe.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rpcClient, err := socketrpc.NewRPCClient()
+		if err != nil {
+			fmt.Println("Failed to connect to daemon, is the service running?")
+			return
+		}
+		defer rpcClient.Close()
+		path := args[0]
+		if absPath, err := filepath.Abs(path); err == nil {
+			path = absPath
+		}
+		request := socketrpc.FixDatesRequest{Path: path}
+		if cmd.Flags().Changed("time-offset") {
+			request.TimeOffset = &timeOffsetFlag
+		}
+		if cmd.Flags().Changed("timezone") {
+			request.Timezone = &timezoneFlag
+		}
+		jsonRequest, err := json.Marshal(request)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		answer, err := rpcClient.SendMessageTimeout(socketrpc.CmdFixDates, string(jsonRequest), 0)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		fmt.Println(answer)
+	},
+}
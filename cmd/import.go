@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/JonaEnz/immich-sync/takeout"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importServerFlag string
+	importJSON       bool
+)
+
+func init() {
+	importTakeoutCmd.Flags().StringVar(&importServerFlag, "server", "", "Server profile to import to")
+	importTakeoutCmd.Flags().BoolVar(&importJSON, "json", false, "Print the import report as JSON")
+	importCmd.AddCommand(importTakeoutCmd)
+	rootCmd.AddCommand(importCmd)
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Imports exports of other photo services",
+}
+
+var importTakeoutCmd = &cobra.Command{
+	Use:   "takeout <dir|zip>...",
+	Short: "Imports a Google Photos export of Google Takeout, with dates, locations, descriptions and albums",
+	Long: `Imports a Google Photos export of Google Takeout, given as the extracted folder or the zip archives.
+The media files are matched to their JSON sidecars and uploaded with the capture time,
+description, location and favorite of the sidecar. Album folders are added to albums of the same name.
+Files without a sidecar are uploaded as they are and listed in the report.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		server, err := serverByProfile(importServerFlag)
+		if err != nil {
+			fatal("failed to connect to server", "err", err)
+		}
+		export, err := takeout.Open(args...)
+		if err != nil {
+			fatal("failed to read export", "err", err)
+		}
+		defer export.Close()
+		pool := immichserver.NewWorkerPool(concurrentUploads)
+		defer pool.Close()
+		report := server.ImportTakeout(cmd.Context(), export, pool)
+		if !printImportReport(report) {
+			export.Close()
+			os.Exit(1)
+		}
+	},
+}
+
+// printImportReport prints the files that need attention and a summary, it returns false if files failed.
+func printImportReport(report *immichserver.ImportReport) bool {
+	counts := make(map[immichserver.ImportResult]int)
+	for _, f := range report.Files {
+		counts[f.Result] += 1
+	}
+	if importJSON {
+		out, _ := json.Marshal(report)
+		fmt.Println(string(out))
+		return counts[immichserver.ImportFailed] == 0
+	}
+	for _, f := range report.Files {
+		if f.Result == immichserver.ImportUploaded || f.Result == immichserver.ImportLinked {
+			if len(f.Error) > 0 {
+				fmt.Printf("%-16s %s: %s\n", "incomplete", f.Name, f.Error)
+			}
+			continue
+		}
+		fmt.Printf("%-16s %s: %s\n", f.Result, f.Name, f.Error)
+	}
+	unmatched := report.Unmatched()
+	for _, name := range unmatched {
+		fmt.Printf("%-16s %s\n", "no-sidecar", name)
+	}
+	for _, name := range report.UnusedSidecars {
+		fmt.Printf("%-16s %s\n", "unused-sidecar", name)
+	}
+	fmt.Printf("\n%d uploaded, %d linked to identical files, %d unsupported, %d failed, %d without sidecar, %d unused sidecars\n",
+		counts[immichserver.ImportUploaded], counts[immichserver.ImportLinked], counts[immichserver.ImportUnsupported],
+		counts[immichserver.ImportFailed], len(unmatched), len(report.UnusedSidecars))
+	return counts[immichserver.ImportFailed] == 0
+}
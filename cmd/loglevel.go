@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/JonaEnz/immich-sync/socketrpc"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(logLevelCmd)
+}
+
+var logLevelCmd = &cobra.Command{
+	Use:   "log-level [debug|info|warn|error]",
+	Short: "Shows or changes the log level of the service daemon",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rpcClient, err := socketrpc.NewRPCClient()
+		if err != nil {
+			fatal("service daemon not running", "err", err)
+		}
+		defer rpcClient.Close()
+		level := ""
+		if len(args) > 0 {
+			level = args[0]
+		}
+		answer, err := rpcClient.SendMessage(socketrpc.CmdLogLevel, level)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Log level: %s\n", answer)
+	},
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// startMetricsServer installs a global meter provider backed by a Prometheus exporter
+// and serves the collected metrics at http://<addr>/metrics.
+func startMetricsServer(addr string) error {
+	handler, err := metricsHandler()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			slog.Error("metrics server stopped", "err", err)
+		}
+	}()
+	slog.Info("serving metrics", "addr", "http://"+listener.Addr().String()+"/metrics")
+	return nil
+}
+
+// metricsHandler installs a global meter provider backed by a Prometheus exporter
+// and returns the handler serving its metrics and those of the Go runtime.
+func metricsHandler() (http.Handler, error) {
+	registry := prom.NewRegistry()
+	if err := registry.Register(collectors.NewGoCollector()); err != nil {
+		return nil, err
+	}
+	if err := registry.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})); err != nil {
+		return nil, err
+	}
+	exporter, err := prometheus.New(prometheus.WithRegisterer(registry))
+	if err != nil {
+		return nil, err
+	}
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter)))
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), nil
+}
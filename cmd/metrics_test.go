@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"go.opentelemetry.io/otel"
+)
+
+func TestMetricsScrape(t *testing.T) {
+	previous := otel.GetMeterProvider()
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+	handler, err := metricsHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFakeServer(t)
+	path := t.TempDir()
+	os.WriteFile(filepath.Join(path, "a.jpg"), []byte("abc"), 0o600)
+	server, dir, err := addImageDirectory(context.Background(), immichserver.ImageDirectoryConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanServer(context.Background(), server)
+	if !dir.WaitForUploads(10 * time.Second) {
+		t.Fatal("uploads did not finish")
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+	labels := fmt.Sprintf(`dir="%s",`, path)
+	for _, expected := range []string{
+		"immich_sync_uploaded_bytes_total{",
+		"immich_sync_upload_duration_seconds_count{",
+		"immich_sync_files_tracked{" + labels,
+		"immich_sync_upload_queue{" + labels,
+		"immich_sync_scan_last_success_seconds{" + labels,
+		"go_goroutines ",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected %s in the scraped metrics, got:\n%s", expected, body)
+		}
+	}
+}
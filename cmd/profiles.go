@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/JonaEnz/immich-sync/oapi"
+	"github.com/spf13/viper"
+)
+
+// defaultProfile is used for the top level server, apikey and deviceid and for watch entries without a server.
+const defaultProfile = "default"
+
+var (
+	// serversMu guards serverProfiles and servers, which are changed by reloads and RPC requests
+	serversMu      sync.RWMutex
+	serverProfiles map[string]immichserver.ServerConfig
+	servers        = make(map[string]*immichserver.ImmichServer)
+)
+
+func setServerProfiles(profiles map[string]immichserver.ServerConfig) {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+	serverProfiles = profiles
+}
+
+// readServerConfig reads the named profiles from 'servers' and adds the top level server as default profile.
+func readServerConfig(v *viper.Viper) (map[string]immichserver.ServerConfig, error) {
+	profiles := make(map[string]immichserver.ServerConfig)
+	if err := v.UnmarshalKey("servers", &profiles); err != nil {
+		return nil, err
+	}
+	if _, ok := profiles[defaultProfile]; !ok && len(v.GetString("server")) > 0 {
+		profiles[defaultProfile] = immichserver.ServerConfig{
+			Server:     v.GetString("server"),
+			APIKey:     v.GetString("apikey"),
+			APIKeyFile: v.GetString("apikey_file"),
+			DeviceID:   v.GetString("deviceid"),
+		}
+	}
+	var transport immichserver.TransportConfig
+	if err := v.UnmarshalKey("transport", &transport); err != nil {
+		return nil, fmt.Errorf("transport: %w", err)
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("server and apikey or at least one entry in 'servers' need to be set in config file")
+	}
+	for name, profile := range profiles {
+		profile = resolveAPIKey(name, profile)
+		if len(profile.Server) == 0 || (len(profile.APIKey) == 0 && len(profile.APIKeyFile) == 0) {
+			return nil, fmt.Errorf("server profile '%s' needs server and apikey", name)
+		}
+		if len(profile.DeviceID) == 0 {
+			profile.DeviceID = v.GetString("deviceid")
+		}
+		if profile.Transport.IsZero() {
+			profile.Transport = transport
+		}
+		if _, err := immichserver.NewHTTPClient(profile.Transport); err != nil {
+			return nil, fmt.Errorf("server profile '%s': %w", name, err)
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+// resolveAPIKey selects the key source of a profile. In order of precedence these are
+// apikey_file, a systemd credential in $CREDENTIALS_DIRECTORY (LoadCredential=),
+// the environment variable IMMICH_SYNC_APIKEY and apikey in the config file.
+// Named profiles use the credential apikey-<profile> and IMMICH_SYNC_APIKEY_<PROFILE>.
+func resolveAPIKey(name string, profile immichserver.ServerConfig) immichserver.ServerConfig {
+	if len(profile.APIKeyFile) > 0 {
+		return profile
+	}
+	credential, env := "apikey", "IMMICH_SYNC_APIKEY"
+	if name != defaultProfile {
+		credential += "-" + name
+		env += "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	}
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); len(dir) > 0 {
+		if path := filepath.Join(dir, credential); fileExists(path) {
+			profile.APIKeyFile = path
+			return profile
+		}
+	}
+	if key := os.Getenv(env); len(key) > 0 {
+		profile.APIKey = key
+	}
+	return profile
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// profileName resolves the server profile of a watch entry or request, an empty name
+// selects the default profile or the only configured one.
+func profileName(name string) (string, error) {
+	if len(name) == 0 {
+		if _, ok := serverProfiles[defaultProfile]; ok || len(serverProfiles) != 1 {
+			name = defaultProfile
+		} else {
+			name = slices.Collect(maps.Keys(serverProfiles))[0]
+		}
+	}
+	if _, ok := serverProfiles[name]; !ok {
+		return "", fmt.Errorf("unknown server profile '%s'", name)
+	}
+	return name, nil
+}
+
+// serverByProfile returns the server of a profile, creating it on first use.
+func serverByProfile(name string) (*immichserver.ImmichServer, error) {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+	name, err := profileName(name)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := servers[name]; ok {
+		return s, nil
+	}
+	profile := serverProfiles[name]
+	client, err := immichserver.NewHTTPClient(profile.Transport)
+	if err != nil {
+		return nil, fmt.Errorf("server profile '%s': %w", name, err)
+	}
+	s, err := immichserver.NewImmichServer(immichserver.NewSecuritySource(profile), profile.Server, profile.DeviceID, oapi.WithClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("server profile '%s': %w", name, err)
+	}
+	s.Profile = name
+	s.SetTimeouts(timeouts)
+	servers[name] = s
+	return s, nil
+}
+
+// sortedServers returns all servers in a stable order.
+func sortedServers() []*immichserver.ImmichServer {
+	serversMu.RLock()
+	defer serversMu.RUnlock()
+	result := make([]*immichserver.ImmichServer, 0, len(servers))
+	for _, name := range slices.Sorted(maps.Keys(servers)) {
+		result = append(result, servers[name])
+	}
+	return result
+}
+
+// serverByPath returns the server that watches the file at path.
+func serverByPath(path string) (*immichserver.ImmichServer, error) {
+	for _, s := range sortedServers() {
+		if _, err := s.GetImageUUIDByPath(path); err == nil {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' is not in the watched directories", path)
+}
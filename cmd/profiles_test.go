@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/spf13/viper"
+)
+
+// useConfig makes viper read the config file with the given content.
+func useConfig(t *testing.T, config string) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProfileName(t *testing.T) {
+	t.Cleanup(func() { serverProfiles = nil })
+	tests := []struct {
+		profiles []string
+		name     string
+		expected string
+	}{
+		{[]string{defaultProfile, "work"}, "", defaultProfile},
+		{[]string{defaultProfile, "work"}, "work", "work"},
+		{[]string{"work"}, "", "work"}, // The only profile
+		{[]string{"home", "work"}, "", ""},
+		{[]string{defaultProfile}, "work", ""},
+	}
+	for _, test := range tests {
+		serverProfiles = make(map[string]immichserver.ServerConfig)
+		for _, name := range test.profiles {
+			serverProfiles[name] = immichserver.ServerConfig{Server: "http://" + name}
+		}
+		name, err := profileName(test.name)
+		if test.expected == "" && err == nil {
+			t.Errorf("%v, '%s': expected an error, got '%s'", test.profiles, test.name, name)
+		} else if test.expected != "" && (err != nil || name != test.expected) {
+			t.Errorf("%v, '%s': expected '%s', got '%s' (%v)", test.profiles, test.name, test.expected, name, err)
+		}
+	}
+}
+
+func TestReadServerConfig(t *testing.T) {
+	t.Setenv("IMMICH_SYNC_APIKEY_WORK_NAS", "env-key")
+	useConfig(t, `
+server: http://home
+apikey: home-key
+deviceid: laptop
+transport:
+  proxy: http://proxy:3128
+servers:
+  work-nas:
+    server: http://work
+    transport:
+      proxy: http://work-proxy:3128
+`)
+	profiles, err := readServerConfig(viper.GetViper())
+	if err != nil {
+		t.Fatal(err)
+	}
+	home, work := profiles[defaultProfile], profiles["work-nas"]
+	if len(profiles) != 2 || home.Server != "http://home" || home.APIKey != "home-key" || home.DeviceID != "laptop" {
+		t.Errorf("Expected the top level server as default profile, got %+v", profiles)
+	}
+	if home.Transport.Proxy != "http://proxy:3128" || work.Transport.Proxy != "http://work-proxy:3128" {
+		t.Errorf("Expected the global transport unless the profile sets one, got %+v, %+v", home.Transport, work.Transport)
+	}
+	if work.APIKey != "env-key" || work.DeviceID != "laptop" {
+		t.Errorf("Expected the key from the environment and the global device id, got %+v", work)
+	}
+
+	useConfig(t, `
+servers:
+  work:
+    server: http://work
+`)
+	if _, err := readServerConfig(viper.GetViper()); err == nil {
+		t.Errorf("Expected a profile without apikey to be rejected")
+	}
+	useConfig(t, `deviceid: laptop`)
+	if _, err := readServerConfig(viper.GetViper()); err == nil {
+		t.Errorf("Expected a config without servers to be rejected")
+	}
+}
+
+func TestServerByProfileConcurrent(t *testing.T) {
+	useFakeServer(t)
+	var wg sync.WaitGroup
+	created := make([]*immichserver.ImmichServer, 10)
+	for n := range created {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			created[n], _ = serverByProfile("")
+		}()
+	}
+	wg.Wait()
+	for _, s := range created {
+		if s == nil || s != created[0] {
+			t.Fatalf("Expected all requests to share one server, got %v", created)
+		}
+	}
+}
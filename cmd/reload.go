@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/JonaEnz/immich-sync/socketrpc"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var reloadMu sync.Mutex
+
+func init() {
+	rootCmd.AddCommand(reloadCmd)
+}
+
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reloads the configuration of the service daemon",
+	Run: func(cmd *cobra.Command, args []string) {
+		rpcClient, err := socketrpc.NewRPCClient()
+		if err != nil {
+			fatal("service daemon not running", "err", err)
+		}
+		defer rpcClient.Close()
+		// Applying the config looks up albums and updates uploaded assets, which can take a while
+		_, err = rpcClient.SendMessageTimeout(socketrpc.CmdReload, "", 0)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Done")
+	},
+}
+
+// watchConfig reloads the configuration whenever the config file changes.
+// A separate instance watches the file, the global one would read a changed file before it is validated.
+func watchConfig() {
+	watcher := viper.New()
+	watcher.SetConfigFile(viper.ConfigFileUsed())
+	watcher.OnConfigChange(func(e fsnotify.Event) {
+		if err := reloadConfig(); err != nil {
+			slog.Error("rejected changed config, keeping the old one", "path", e.Name, "err", err)
+		}
+	})
+	watcher.WatchConfig()
+}
+
+func reloadDaemon(context.Context, string) (byte, string) {
+	if err := reloadConfig(); err != nil {
+		return socketrpc.ErrGeneric, fmt.Sprintf("config rejected, keeping the old one: %s", err)
+	}
+	return socketrpc.ErrOk, ""
+}
+
+// reloadConfig reads the config file again and applies it to the running daemon.
+// Nothing is applied if the new config is invalid.
+func reloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	data, err := os.ReadFile(viper.ConfigFileUsed())
+	if err != nil {
+		return err
+	}
+	// Validate the new config before it replaces the one read by viper
+	v := viper.New()
+	setDefaults(v)
+	v.AutomaticEnv()
+	v.SetConfigFile(viper.ConfigFileUsed())
+	if err = v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	newWatchDirs, err := readWatchConfig(v)
+	if err != nil {
+		return err
+	}
+	var newLogLevel slog.Level
+	if err = newLogLevel.UnmarshalText([]byte(v.GetString("log-level"))); err != nil {
+		return fmt.Errorf("unknown log level '%s'", v.GetString("log-level"))
+	}
+	if v.GetInt("concurrent-uploads") < 1 {
+		return fmt.Errorf("concurrent-uploads needs to be at least 1")
+	}
+	newHashWorkers, newHashRate, err := readHashConfig(v)
+	if err != nil {
+		return err
+	}
+	newSchedule, newJitter, err := readScheduleConfig(v)
+	if err != nil {
+		return err
+	}
+	newProfiles, err := readServerConfig(v)
+	if err != nil {
+		return err
+	}
+	for name, profile := range newProfiles {
+		if old, ok := serverProfiles[name]; ok && !reflect.DeepEqual(old, profile) {
+			slog.Warn("changes to server, apikey, deviceid and transport of an existing profile require a restart", "server", name)
+			newProfiles[name] = old
+		}
+	}
+	oldProfiles := serverProfiles
+	setServerProfiles(newProfiles)
+	// Creates the servers of new profiles, so they cannot fail after the config was applied
+	for _, cfg := range newWatchDirs {
+		if _, err = serverByProfile(cfg.Server); err != nil {
+			setServerProfiles(oldProfiles)
+			return err
+		}
+	}
+
+	var oldLogLevel slog.Level
+	oldLogLevel.UnmarshalText([]byte(viper.GetString("log-level")))
+	if err = viper.ReadConfig(bytes.NewReader(data)); err != nil {
+		setServerProfiles(oldProfiles)
+		return err
+	}
+	// Keeps a level set with 'immich-sync log-level' unless the config file changes it
+	if newLogLevel != oldLogLevel {
+		logLevel.Set(newLogLevel)
+	}
+	concurrentUploads = viper.GetInt("concurrent-uploads")
+	uploadPool.Resize(concurrentUploads)
+	keepChangedFiles = viper.GetBool("keepchangedfiles")
+	rescanSchedule, scheduleJitter = newSchedule, newJitter
+	hashWorkers = newHashWorkers
+	hashLimiter.SetRate(newHashRate)
+
+	dirs := make(map[string][]*immichserver.ImageDirectory)
+	for _, cfg := range newWatchDirs {
+		server, _ := serverByProfile(cfg.Server)
+		current := server.Directories()
+		i := slices.IndexFunc(current, func(d *immichserver.ImageDirectory) bool {
+			return d.Path() == cfg.Path
+		})
+		if i < 0 {
+			dir := newImageDirectory(daemonCtx, server, cfg)
+			go startImageDirectory(daemonCtx, server, dir)
+			dirs[server.Profile] = append(dirs[server.Profile], dir)
+			slog.Info("added directory", "dir", cfg.Path, "server", server.Profile)
+			continue
+		}
+		dir := current[i]
+		applyImageDirectoryConfig(daemonCtx, server, dir, cfg)
+		dir.SetKeepChangedFiles(keepChangedFiles)
+		dirs[server.Profile] = append(dirs[server.Profile], dir)
+	}
+	for _, server := range sortedServers() {
+		for _, dir := range server.Directories() {
+			if !slices.Contains(dirs[server.Profile], dir) {
+				dir.Stop()
+				slog.Info("removed directory", "dir", dir.Path(), "server", server.Profile)
+			}
+		}
+		server.SetDirectories(dirs[server.Profile])
+	}
+	watchDirs = newWatchDirs
+	slog.Info("reloaded config", "path", viper.ConfigFileUsed())
+	return nil
+}
+
+// applyImageDirectoryConfig applies a changed watch entry to a running directory.
+func applyImageDirectoryConfig(ctx context.Context, server *immichserver.ImmichServer, dir *immichserver.ImageDirectory, cfg immichserver.ImageDirectoryConfig) {
+	if len(cfg.Album) > 0 {
+		albumUUID, err := server.GetAlbumByUUIDOrName(ctx, cfg.Album)
+		if err == nil {
+			dir.SetAlbum(&albumUUID)
+		} else {
+			slog.Error("album of watched directory not found", "dir", cfg.Path, "album_id", cfg.Album, "err", err)
+		}
+	} else {
+		dir.SetAlbum(nil)
+	}
+	dir.SetStackConfig(cfg.Stack)
+	dir.SetPriority(cfg.Priority)
+	dir.SetHashing(hashWorkers, hashLimiter)
+	dir.SetSchedule(directorySchedule(cfg), scheduleJitter)
+	dir.SetGeotag(cfg.GPX)
+	dates, _ := dateCorrection(cfg.TimeOffset, cfg.Timezone)
+	dir.SetDateCorrection(dates)
+	if interval, _ := pollInterval(cfg); interval != dir.PollInterval() {
+		slog.Warn("changes to poll of a watched directory require a restart", "dir", cfg.Path)
+	}
+	visibility, _ := immichserver.ParseVisibility(cfg.Visibility)
+	options := immichserver.UploadOptions{
+		Favorite:   cfg.Favorite,
+		Visibility: visibility,
+	}
+	if err := dir.UpdateUploadOptions(ctx, server, options); err != nil {
+		slog.Error("failed to apply changed upload options to uploaded assets", "dir", cfg.Path, "err", err)
+	}
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestReloadRejectedConfig(t *testing.T) {
+	fake := useFakeServer(t)
+	logLevel.Set(slog.LevelInfo)
+	t.Cleanup(func() { logLevel.Set(slog.LevelInfo) })
+	config := func(extra string) string {
+		return fmt.Sprintf("server: %s\napikey: %s\ndeviceid: test-device\n%s", fake.URL, fake.APIKey, extra)
+	}
+	useConfig(t, config("concurrent-uploads: 2\n"))
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log level is valid, but concurrent-uploads is not
+	if err := os.WriteFile(viper.ConfigFileUsed(), []byte(config("log-level: debug\nconcurrent-uploads: 0\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig(); err == nil {
+		t.Fatal("Expected the config to be rejected")
+	}
+	if logLevel.Level() != slog.LevelInfo || viper.GetString("log-level") != "info" || viper.GetInt("concurrent-uploads") != 2 {
+		t.Errorf("Expected nothing of the rejected config to be applied, got log level %s, config %s and %d uploads",
+			logLevel.Level(), viper.GetString("log-level"), viper.GetInt("concurrent-uploads"))
+	}
+
+	if err := os.WriteFile(viper.ConfigFileUsed(), []byte(config("log-level: debug\nconcurrent-uploads: 3\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if logLevel.Level() != slog.LevelDebug || concurrentUploads != 3 {
+		t.Errorf("Expected the valid config to be applied, got log level %s and %d uploads", logLevel.Level(), concurrentUploads)
+	}
+
+	// A level set at runtime is kept while the config file does not change it
+	logLevel.Set(slog.LevelWarn)
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if logLevel.Level() != slog.LevelWarn {
+		t.Errorf("Expected the log level set at runtime to be kept, got %s", logLevel.Level())
+	}
+}
@@ -1,25 +1,36 @@
 package cmd
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/JonaEnz/immich-sync/immichserver"
+	"github.com/JonaEnz/immich-sync/schedule"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
 	// Used for flags.
-	cfgFile   string
-	serverURL string
-	apiKey    string
-	deviceID  string
-	watchDirs []immichserver.ImageDirectoryConfig
+	cfgFile     string
+	metricsAddr string
+	watchDirs   []immichserver.ImageDirectoryConfig
+	// configErr is reported by all commands except doctor, which explains it instead
+	configErr error
 
 	rootCmd = &cobra.Command{
 		Use:   "immich-sync",
 		Short: "A client for uploading images to Immich",
 		Long:  "A client for uploading images to Immich",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			if configErr != nil {
+				fatal("invalid config, run 'immich-sync doctor' for details", "err", configErr)
+			}
+		},
 	}
 )
 
@@ -31,13 +42,121 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.config/immich-sync/config.yaml)")
-	viper.SetDefault("watch", []immichserver.ImageDirectoryConfig{})
-	viper.SetDefault("deviceid", "defaultdeviceid")
-	viper.SetDefault("server", "")
-	viper.SetDefault("apikey", "")
-	viper.SetDefault("schedule", 15)
-	viper.SetDefault("concurrent-uploads", 5)
-	viper.SetDefault("keepchangedfiles", false)
+	setDefaults(viper.GetViper())
+}
+
+// defaultStateFile uses the state directory set by systemd (StateDirectory=), $XDG_STATE_HOME or ~/.local/state.
+// It is empty if none of them is known, 'statefile' then needs to be set.
+func defaultStateFile() string {
+	if dir := os.Getenv("STATE_DIRECTORY"); dir != "" {
+		return filepath.Join(dir, "state.json")
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "immich-sync", "state.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "immich-sync", "state.json")
+}
+
+func readWatchConfig(v *viper.Viper) ([]immichserver.ImageDirectoryConfig, error) {
+	dirs := []immichserver.ImageDirectoryConfig{}
+	if err := v.UnmarshalKey("watch", &dirs); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(dirs))
+	for _, w := range dirs {
+		if _, err := immichserver.ParseVisibility(w.Visibility); err != nil {
+			return nil, fmt.Errorf("'%s': %w", w.Path, err)
+		}
+		if _, err := pollInterval(w); err != nil {
+			return nil, fmt.Errorf("'%s': %w", w.Path, err)
+		}
+		if _, err := schedule.Parse(w.Schedule); err != nil {
+			return nil, fmt.Errorf("'%s': %w", w.Path, err)
+		}
+		if err := w.Stack.Validate(); err != nil {
+			return nil, fmt.Errorf("'%s': %w", w.Path, err)
+		}
+		if err := w.GPX.Validate(); err != nil {
+			return nil, fmt.Errorf("'%s': %w", w.Path, err)
+		}
+		if _, err := dateCorrection(w.TimeOffset, w.Timezone); err != nil {
+			return nil, fmt.Errorf("'%s': %w", w.Path, err)
+		}
+		if seen[w.Path] {
+			return nil, fmt.Errorf("'%s' is watched more than once", w.Path)
+		}
+		seen[w.Path] = true
+	}
+	return dirs, nil
+}
+
+// directorySchedule returns when a watched directory is rescanned, its own schedule or the global one.
+func directorySchedule(w immichserver.ImageDirectoryConfig) schedule.Schedule {
+	if len(w.Schedule) == 0 {
+		return rescanSchedule
+	}
+	s, _ := schedule.Parse(w.Schedule)
+	return s
+}
+
+// readScheduleConfig parses the global rescan schedule and its jitter.
+func readScheduleConfig(v *viper.Viper) (schedule.Schedule, time.Duration, error) {
+	s, err := schedule.Parse(v.GetString("schedule"))
+	if err != nil {
+		return nil, 0, err
+	}
+	jitter := v.GetDuration("schedule-jitter")
+	if jitter < 0 {
+		return nil, 0, fmt.Errorf("schedule-jitter needs to be positive")
+	}
+	return s, jitter, nil
+}
+
+// readHashConfig reads the number of hash workers and the hashing throughput limit in bytes per second.
+func readHashConfig(v *viper.Viper) (int, int64, error) {
+	workers := v.GetInt("hash-workers")
+	if workers < 1 {
+		return 0, 0, fmt.Errorf("hash-workers needs to be at least 1")
+	}
+	limit := v.GetFloat64("hash-rate-limit")
+	if limit < 0 {
+		return 0, 0, fmt.Errorf("hash-rate-limit needs to be positive")
+	}
+	return workers, int64(limit * 1e6), nil
+}
+
+// pollInterval parses the poll interval of a watch entry, 0 means inotify is used.
+func pollInterval(w immichserver.ImageDirectoryConfig) (time.Duration, error) {
+	if len(w.Poll) == 0 {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(w.Poll)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid poll interval '%s', expected a duration like 30s", w.Poll)
+	}
+	return interval, nil
+}
+
+// dateCorrection parses the clock offset and time zone of a camera.
+func dateCorrection(offset, timezone string) (immichserver.DateCorrection, error) {
+	var dates immichserver.DateCorrection
+	if len(offset) > 0 {
+		d, err := time.ParseDuration(offset)
+		if err != nil {
+			return dates, fmt.Errorf("invalid time_offset '%s', expected a duration like -1h5m", offset)
+		}
+		dates.Offset = d
+	}
+	loc, err := immichserver.ParseTimezone(timezone)
+	if err != nil {
+		return dates, err
+	}
+	dates.Location = loc
+	return dates, nil
 }
 
 func initConfig() {
@@ -53,20 +172,65 @@ func initConfig() {
 
 	viper.AutomaticEnv()
 
-	if err := viper.ReadInConfig(); err != nil {
-		log.Printf("Error reading config: %s\n", err.Error())
+	readErr := viper.ReadInConfig()
+	if err := setupLogging(viper.GetString("log-level"), viper.GetString("log-format")); err != nil {
+		configErr = fmt.Errorf("failed to set up logging: %w", err)
+		setupLogging("info", "text")
+	}
+	if readErr != nil {
+		slog.Warn("error reading config", "err", readErr)
 	}
+	configErr = errors.Join(configErr, loadConfig())
+}
 
-	if !viper.IsSet("server") || !viper.IsSet("apikey") {
-		log.Fatal("Server and apikey need to be set in config file!")
+// loadConfig parses the config read by viper into the globals.
+func loadConfig() error {
+	var err error
+	serverProfiles, err = readServerConfig(viper.GetViper())
+	if err != nil {
+		return fmt.Errorf("failed to parse server config: %w", err)
 	}
-	err := viper.UnmarshalKey("watch", &watchDirs)
+	watchDirs, err = readWatchConfig(viper.GetViper())
 	if err != nil {
-		log.Fatalf("failed to parse config file entry 'watch': %s", err.Error())
+		return fmt.Errorf("failed to parse config file entry 'watch': %w", err)
+	}
+	for _, w := range watchDirs {
+		if _, err = profileName(w.Server); err != nil {
+			return fmt.Errorf("failed to parse config file entry 'watch': '%s': %w", w.Path, err)
+		}
 	}
-	deviceID = viper.GetString("deviceid")
-	serverURL = viper.GetString("server")
-	apiKey = viper.GetString("apikey")
 	concurrentUploads = viper.GetInt("concurrent-uploads")
 	keepChangedFiles = viper.GetBool("keepchangedfiles")
+	metricsAddr = viper.GetString("metrics")
+	stateFile = viper.GetString("statefile")
+	if len(stateFile) == 0 {
+		stateFile = defaultStateFile()
+	}
+	if len(stateFile) == 0 {
+		// A state file in the temp directory would be lost on reboot and all files uploaded again
+		return errors.New("statefile needs to be set, no state directory was found in $STATE_DIRECTORY, $XDG_STATE_HOME or $HOME")
+	}
+	shutdownTimeout = viper.GetDuration("shutdown-timeout")
+	quietPeriod = viper.GetDuration("quiet-period")
+	tempPatterns = viper.GetStringSlice("temp-patterns")
+	for _, pattern := range tempPatterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid temp pattern '%s': %w", pattern, err)
+		}
+	}
+	timeouts = immichserver.Timeouts{
+		API:      viper.GetDuration("timeouts.api"),
+		Upload:   viper.GetDuration("timeouts.upload"),
+		Download: viper.GetDuration("timeouts.download"),
+	}
+	var hashRate int64
+	if hashWorkers, hashRate, err = readHashConfig(viper.GetViper()); err != nil {
+		return err
+	}
+	hashLimiter.SetRate(hashRate)
+	rescanSchedule, scheduleJitter, err = readScheduleConfig(viper.GetViper())
+	if err != nil {
+		return fmt.Errorf("failed to parse config file entry 'schedule': %w", err)
+	}
+	return nil
 }
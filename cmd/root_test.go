@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestReadWatchConfig(t *testing.T) {
+	useConfig(t, `
+watch:
+  - path: /photos
+    stack:
+      burst: 2s
+      primary: raw
+`)
+	dirs, err := readWatchConfig(viper.GetViper())
+	if err != nil || len(dirs) != 1 || dirs[0].Stack.Primary != "raw" {
+		t.Fatalf("Expected the valid entry to be read, got %+v (%v)", dirs, err)
+	}
+
+	for entry, expected := range map[string]string{
+		"stack: {primary: newest}": "invalid stack primary 'newest'",
+		"stack: {burst: -1s}":      "stack burst needs to be positive",
+		"visibility: secret":       "invalid visibility 'secret'",
+		"time_offset: soon":        "invalid time_offset 'soon'",
+	} {
+		useConfig(t, "watch:\n  - path: /photos\n    "+entry+"\n")
+		if _, err := readWatchConfig(viper.GetViper()); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error '%s', got %v", entry, expected, err)
+		}
+	}
+}
+
+func TestDefaultStateFile(t *testing.T) {
+	t.Setenv("STATE_DIRECTORY", "")
+	t.Setenv("XDG_STATE_HOME", "/state")
+	if path := defaultStateFile(); path != "/state/immich-sync/state.json" {
+		t.Errorf("Expected the state file in $XDG_STATE_HOME, got %s", path)
+	}
+	t.Setenv("STATE_DIRECTORY", "/var/lib/immich-sync")
+	if path := defaultStateFile(); path != "/var/lib/immich-sync/state.json" {
+		t.Errorf("Expected the state file in the systemd state directory, got %s", path)
+	}
+
+	// Without a state directory the state must not end up in the temp directory
+	t.Setenv("STATE_DIRECTORY", "")
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("HOME", "")
+	if path := defaultStateFile(); path != "" {
+		t.Errorf("Expected no default state file without a home directory, got %s", path)
+	}
+	t.Cleanup(func() { serverProfiles = nil })
+	useConfig(t, "server: http://immich.local/api\napikey: key\nstatefile: \"\"\n")
+	if err := loadConfig(); err == nil || !strings.Contains(err.Error(), "statefile needs to be set") {
+		t.Errorf("Expected loading the config to fail without a state file, got %v", err)
+	}
+}
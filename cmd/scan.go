@@ -1,12 +1,15 @@
 package cmd
 
 import (
+	"log/slog"
+
 	"github.com/JonaEnz/immich-sync/immichserver"
 	"github.com/JonaEnz/immich-sync/socketrpc"
 	"github.com/spf13/cobra"
 )
 
 func init() {
+	addDryRunFlags(scanCmd)
 	rootCmd.AddCommand(scanCmd)
 }
 
@@ -14,22 +17,42 @@ var scanCmd = &cobra.Command{
 	Use:   "scan",
 	Short: "Scans for new images, uses the daemon if it is running",
 	Run: func(cmd *cobra.Command, args []string) {
-		server = immichserver.NewImmichServer(apiKey, serverURL, deviceID)
-
 		rpcClient, err := socketrpc.NewRPCClient()
+		if dryRun {
+			var planned []immichserver.PlannedFile
+			if err != nil {
+				planned, err = dryRunScan(cmd.Context())
+			} else {
+				defer rpcClient.Close()
+				planned, err = dryRunRPC(rpcClient)
+			}
+			if err != nil {
+				fatal("dry run failed", "err", err)
+			}
+			printDryRun(planned)
+			return
+		}
 		if err != nil {
-			imageDirs := make([]*immichserver.ImageDirectory, len(watchDirs))
 			for i := range watchDirs {
-				idir := immichserver.NewImageDirectory(watchDirs[i].Path, false)
-				if len(watchDirs[i].Album) > 0 {
-					albumUUID, err := server.GetAlbumByUUIDOrName(watchDirs[0].Album)
-					if err == nil {
-						idir.SetAlbum(&albumUUID)
-					}
+				if _, _, err := addImageDirectory(cmd.Context(), watchDirs[i]); err != nil {
+					fatal("failed to add watched directory", "dir", watchDirs[i].Path, "err", err)
+				}
+			}
+			// No daemon, scan yourself, starting from the state the daemon and dry runs use
+			if err := immichserver.LoadState(stateFile, sortedServers()); err != nil {
+				slog.Error("failed to load state, all files will be checked again", "path", stateFile, "err", err)
+			}
+			uploadPool = immichserver.NewWorkerPool(concurrentUploads)
+			defer uploadPool.Close()
+			scanAll(cmd.Context())
+			for _, dir := range allImageDirs() {
+				if err := dir.Wait(cmd.Context()); err != nil {
+					// Cancelled uploads are saved as pending and retried by the next scan
+					saveState()
+					fatal("scan cancelled", "dir", dir.Path(), "err", err)
 				}
-				imageDirs[i] = &idir
 			}
-			scanAll(imageDirs) // No daemon, scan yourself
+			saveState()
 			return
 		}
 		defer rpcClient.Close()
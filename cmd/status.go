@@ -1,35 +1,154 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/JonaEnz/immich-sync/immichserver"
 	"github.com/JonaEnz/immich-sync/socketrpc"
 	"github.com/spf13/cobra"
 )
 
+// Exit codes of the status command
+const (
+	StatusOk          = 0
+	StatusNotRunning  = 1
+	StatusOffline     = 2
+	StatusDegraded    = 3
+	StatusNotTracked  = 4
+	StatusNotUploaded = 5
+)
+
+var statusJSON bool
+
 func init() {
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "Print the status as JSON")
 	rootCmd.AddCommand(statusCmd)
 }
 
 var statusCmd = &cobra.Command{
-	Use:   "status",
-	Short: "Checks the status of the service daemon",
+	Use:   "status [path]",
+	Short: "Checks the status of the service daemon or of a single file",
+	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		rpcClient, err := socketrpc.NewRPCClient()
 		if err != nil {
-			log.Fatalln("Service daemon not running.")
+			fmt.Fprintln(os.Stderr, "Service daemon not running.")
+			os.Exit(StatusNotRunning)
 		}
 		defer rpcClient.Close()
-		answer, err := rpcClient.SendMessage(socketrpc.CmdStatus, "")
+		if len(args) > 0 {
+			os.Exit(fileStatus(rpcClient, args[0]))
+		}
+		// The daemon reports a server that does not answer in time as offline
+		answer, err := rpcClient.SendMessageTimeout(socketrpc.CmdStatus, "", immichserver.StatusTimeout+socketrpc.ResponseTimout)
 		if err != nil {
-			fmt.Println(err)
-			return
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(StatusNotRunning)
+		}
+		var statuses []immichserver.ServerStatus
+		if err = json.Unmarshal([]byte(answer), &statuses); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(StatusNotRunning)
 		}
-		fmt.Println("Immich-Sync status:")
-		for _, l := range strings.Split(answer, "\n") {
-			fmt.Println(l)
+		if statusJSON {
+			fmt.Println(answer)
+		} else {
+			fmt.Println("Immich-Sync status:")
+			for _, status := range statuses {
+				printStatus(status)
+			}
 		}
+		code := StatusOk
+		for _, status := range statuses {
+			if !status.Online {
+				os.Exit(StatusOffline)
+			}
+			for _, d := range status.Directories {
+				if !d.Watching || d.Files[immichserver.FileFailed] > 0 {
+					code = StatusDegraded
+				}
+			}
+		}
+		os.Exit(code)
 	},
 }
+
+func printStatus(status immichserver.ServerStatus) {
+	if status.Online {
+		fmt.Printf("Server %s: %s (online, version %s)\n", status.Profile, status.Server, status.Version)
+	} else {
+		fmt.Printf("Server %s: %s (offline)\n", status.Profile, status.Server)
+	}
+	for _, d := range status.Directories {
+		fmt.Printf("%s:\n", d.Path)
+		if len(d.Album) > 0 {
+			fmt.Printf("  album:     %s\n", d.Album)
+		}
+		if d.Watching && len(d.WatchMode) > 0 {
+			fmt.Printf("  watching:  %t (%s)\n", d.Watching, d.WatchMode)
+		} else {
+			fmt.Printf("  watching:  %t\n", d.Watching)
+		}
+		fmt.Printf("  last scan: %s\n", d.LastScan.Format("Mon Jan 2 15:04:05 MST 2006"))
+		if len(d.Schedule) > 0 && !d.NextScan.IsZero() {
+			fmt.Printf("  next scan: %s (%s)\n", d.NextScan.Format("Mon Jan 2 15:04:05 MST 2006"), d.Schedule)
+		}
+		counts := make([]string, 0, len(d.Files))
+		for _, state := range []immichserver.FileState{
+			immichserver.FilePending,
+			immichserver.FileUploading,
+			immichserver.FileUploaded,
+			immichserver.FileFailed,
+			immichserver.FileSkipped,
+		} {
+			counts = append(counts, fmt.Sprintf("%d %s", d.Files[state], state))
+		}
+		fmt.Printf("  files:     %s\n", strings.Join(counts, ", "))
+		if len(d.LastError) > 0 {
+			fmt.Printf("  error:     %s\n", d.LastError)
+		}
+	}
+}
+
+func fileStatus(rpcClient *socketrpc.RPCClient, path string) int {
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	answer, err := rpcClient.SendMessage(socketrpc.CmdStatus, path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return StatusNotTracked
+	}
+	var status immichserver.FileStatus
+	if err = json.Unmarshal([]byte(answer), &status); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return StatusNotRunning
+	}
+	if statusJSON {
+		fmt.Println(answer)
+	} else {
+		fmt.Printf("%s:\n", status.Path)
+		fmt.Printf("  directory: %s\n", status.Directory)
+		fmt.Printf("  state:     %s\n", status.State)
+		if len(status.AssetID) > 0 {
+			fmt.Printf("  asset:     %s\n", status.AssetID)
+		}
+		fmt.Printf("  sha1:      %s\n", status.Sha1)
+		fmt.Printf("  size:      %d bytes, modified %s\n", status.Size, status.Modified.Format("Mon Jan 2 15:04:05 MST 2006"))
+		if len(status.LastError) > 0 {
+			fmt.Printf("  error:     %s\n", status.LastError)
+		}
+	}
+	switch status.State {
+	case immichserver.FileUploaded:
+		return StatusOk
+	case immichserver.FileFailed:
+		return StatusDegraded
+	default:
+		return StatusNotUploaded
+	}
+}
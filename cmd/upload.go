@@ -1,18 +1,31 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/JonaEnz/immich-sync/immichserver"
 	"github.com/JonaEnz/immich-sync/socketrpc"
 	"github.com/spf13/cobra"
 )
 
-var albumFlag string
+var (
+	albumFlag     string
+	serverFlag    string
+	gpxFlag       []string
+	gpxOffsetFlag time.Duration
+)
 
 func init() {
 	uploadCmd.PersistentFlags().StringVar(&albumFlag, "album", "", "Add uploaded image to album with this name")
+	uploadCmd.PersistentFlags().StringVar(&serverFlag, "server", "", "Server profile to upload to")
+	uploadCmd.Flags().StringSliceVar(&gpxFlag, "gpx", nil, "Geotag the uploaded images from these GPX files or directories")
+	uploadCmd.Flags().DurationVar(&gpxOffsetFlag, "gpx-offset", 0, "How far the camera clock is ahead of the real time, negative if it is behind")
+	addDryRunFlags(uploadCmd)
 	rootCmd.AddCommand(uploadCmd)
 }
 
@@ -21,24 +34,34 @@ var uploadCmd = &cobra.Command{
 	Short: "Uploads image(s) to Immich",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		server = immichserver.NewImmichServer(apiKey, serverURL, deviceID)
-
+		if dryRun {
+			planned, err := dryRunUpload(cmd.Context(), args)
+			if err != nil {
+				fatal("dry run failed", "err", err)
+			}
+			printDryRun(planned)
+			return
+		}
 		rpcClient, err := socketrpc.NewRPCClient()
 		if err != nil {
 			fmt.Println("Failed to connect to daemon, is the service running?")
 			return
 		}
 		defer rpcClient.Close()
+		// The daemon may run in another working directory
 		request := socketrpc.UploadFileRequest{
-			Paths: args,
-			Album: albumFlag,
+			Paths:     absPaths(args),
+			Album:     albumFlag,
+			Server:    serverFlag,
+			GPX:       absPaths(gpxFlag),
+			GPXOffset: gpxOffsetFlag,
 		}
 		jsonRequest, err := json.Marshal(request)
 		if err != nil {
 			fmt.Println(err.Error())
 			return
 		}
-		answer, err := rpcClient.SendMessage(socketrpc.CmdUploadFile, string(jsonRequest))
+		answer, err := rpcClient.SendMessageTimeout(socketrpc.CmdUploadFile, string(jsonRequest), 0)
 		if err != nil {
 			fmt.Println(err.Error())
 			return
@@ -46,3 +69,40 @@ var uploadCmd = &cobra.Command{
 		fmt.Printf("Success: %s\n", answer)
 	},
 }
+
+// dryRunUpload plans the upload of the files without the daemon.
+func dryRunUpload(ctx context.Context, paths []string) ([]immichserver.PlannedFile, error) {
+	server, err := serverByProfile(serverFlag)
+	if err != nil {
+		return nil, err
+	}
+	paths = absPaths(paths)
+	for i := range paths {
+		stat, err := os.Stat(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		if stat.IsDir() {
+			return nil, fmt.Errorf("'%s' is a directory, this is not currently supported", paths[i])
+		}
+	}
+	album := albumFlag
+	if len(albumFlag) > 0 {
+		if _, err := server.GetAlbumByUUIDOrName(ctx, albumFlag); err != nil {
+			return nil, err
+		}
+	}
+	return server.PlanUpload(ctx, paths, album)
+}
+
+// absPaths returns the paths made absolute, paths that cannot be resolved are kept as they are.
+func absPaths(paths []string) []string {
+	result := make([]string, len(paths))
+	for i, path := range paths {
+		result[i] = path
+		if absPath, err := filepath.Abs(path); err == nil {
+			result[i] = absPath
+		}
+	}
+	return result
+}
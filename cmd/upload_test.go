@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAbsPaths(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	paths := []string{"img.jpg", "./sub/b.jpg", "/photos/c.jpg"}
+	expected := []string{filepath.Join(dir, "img.jpg"), filepath.Join(dir, "sub", "b.jpg"), "/photos/c.jpg"}
+	if result := absPaths(paths); !slices.Equal(result, expected) {
+		t.Errorf("Expected the paths relative to the working directory %v, got %v", expected, result)
+	}
+	if paths[0] != "img.jpg" {
+		t.Errorf("Expected the arguments not to be changed, got %v", paths)
+	}
+}
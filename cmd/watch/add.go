@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 
@@ -8,7 +9,10 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var serverFlag string
+
 func init() {
+	AddWatchCmd.Flags().StringVar(&serverFlag, "server", "", "Server profile to upload the directory to")
 }
 
 var AddWatchCmd = &cobra.Command{
@@ -21,7 +25,12 @@ var AddWatchCmd = &cobra.Command{
 			log.Fatalln("Service daemon not running.")
 		}
 		defer rpcClient.Close()
-		_, err = rpcClient.SendMessage(socketrpc.CmdAddDir, args[0])
+		request, err := json.Marshal(socketrpc.AddDirRequest{Path: args[0], Server: serverFlag})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		_, err = rpcClient.SendMessage(socketrpc.CmdAddDir, string(request))
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -0,0 +1,211 @@
+// Package exif reads the capture time and whether a position is stored from the EXIF data of
+// JPEG files and TIFF based RAW files (NEF, CR2, ARW, DNG, ORF, RW2, PEF).
+package exif
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNoExif is returned for files without EXIF data or in a format that is not supported.
+var ErrNoExif = errors.New("no EXIF data")
+
+// Info is the EXIF data used for dates and geotagging.
+type Info struct {
+	// DateTimeOriginal is the capture time as shown by the camera clock, zero if the file has none.
+	// Its location is time.UTC unless the file stores the time zone (OffsetTimeOriginal).
+	DateTimeOriginal time.Time
+	// HasZone is true if the file stores the time zone of DateTimeOriginal
+	HasZone bool
+	// HasGPS is true if the file stores a position
+	HasGPS bool
+}
+
+const (
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagDateTimeDigitized  = 0x9004
+	tagOffsetTimeOriginal = 0x9011
+	tagGPSLatitude        = 0x0002
+
+	typeASCII = 2
+	typeLong  = 4
+	// typeIFD is used by some writers instead of LONG for the offsets of the Exif and GPS IFDs
+	typeIFD = 13
+
+	// maxEntries skips IFDs that are too large to be valid
+	maxEntries = 1000
+)
+
+const dateLayout = "2006:01:02 15:04:05"
+
+// Read reads the EXIF data of the file.
+func Read(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+// Decode reads the EXIF data of a JPEG or TIFF file.
+func Decode(r io.ReaderAt) (*Info, error) {
+	var magic [4]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return nil, ErrNoExif
+	}
+	if magic[0] == 0xFF && magic[1] == 0xD8 {
+		tiff, err := findJPEGExif(r)
+		if err != nil {
+			return nil, err
+		}
+		return decodeTIFF(tiff)
+	}
+	return decodeTIFF(r)
+}
+
+// findJPEGExif returns the TIFF structure in the APP1 segment of a JPEG file.
+func findJPEGExif(r io.ReaderAt) (io.ReaderAt, error) {
+	offset := int64(2)
+	var header [4]byte
+	for {
+		if _, err := r.ReadAt(header[:], offset); err != nil || header[0] != 0xFF {
+			return nil, ErrNoExif
+		}
+		marker := header[1]
+		// Start of scan or end of image, the metadata segments come before
+		if marker == 0xDA || marker == 0xD9 {
+			return nil, ErrNoExif
+		}
+		length := int64(binary.BigEndian.Uint16(header[2:]))
+		if marker == 0xE1 && length > 8 {
+			var id [6]byte
+			if _, err := r.ReadAt(id[:], offset+4); err == nil && string(id[:]) == "Exif\x00\x00" {
+				return io.NewSectionReader(r, offset+10, length-8), nil
+			}
+		}
+		offset += 2 + length
+	}
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value [4]byte
+}
+
+type tiffReader struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+}
+
+func decodeTIFF(r io.ReaderAt) (*Info, error) {
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return nil, ErrNoExif
+	}
+	t := tiffReader{r: r}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, ErrNoExif
+	}
+	// 42 for TIFF, ORF and RW2 use their own magic numbers
+	if magic := t.order.Uint16(header[2:]); magic != 42 && magic != 0x4F52 && magic != 0x5352 && magic != 0x55 {
+		return nil, ErrNoExif
+	}
+	ifd0, err := t.readIFD(t.order.Uint32(header[4:]))
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{}
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if gps, err := t.readIFD(t.long(e)); err == nil {
+			_, info.HasGPS = gps[tagGPSLatitude]
+		}
+	}
+	e, ok := ifd0[tagExifIFD]
+	if !ok {
+		return info, nil
+	}
+	exifIFD, err := t.readIFD(t.long(e))
+	if err != nil {
+		return info, nil
+	}
+	date, ok := exifIFD[tagDateTimeOriginal]
+	if !ok {
+		date, ok = exifIFD[tagDateTimeDigitized]
+	}
+	if !ok {
+		return info, nil
+	}
+	taken, err := time.Parse(dateLayout, t.ascii(date))
+	if err != nil {
+		// Cameras without a set clock store 0000:00:00 00:00:00
+		return info, nil
+	}
+	info.DateTimeOriginal = taken
+	if e, ok := exifIFD[tagOffsetTimeOriginal]; ok {
+		if zone, err := time.Parse("-07:00", t.ascii(e)); err == nil {
+			_, seconds := zone.Zone()
+			info.DateTimeOriginal = time.Date(taken.Year(), taken.Month(), taken.Day(), taken.Hour(), taken.Minute(), taken.Second(), 0,
+				time.FixedZone("", seconds))
+			info.HasZone = true
+		}
+	}
+	return info, nil
+}
+
+func (t tiffReader) readIFD(offset uint32) (map[uint16]ifdEntry, error) {
+	var count [2]byte
+	if _, err := t.r.ReadAt(count[:], int64(offset)); err != nil {
+		return nil, ErrNoExif
+	}
+	n := int(t.order.Uint16(count[:]))
+	if n > maxEntries {
+		return nil, ErrNoExif
+	}
+	data := make([]byte, 12*n)
+	if _, err := t.r.ReadAt(data, int64(offset)+2); err != nil {
+		return nil, ErrNoExif
+	}
+	entries := make(map[uint16]ifdEntry, n)
+	for i := range n {
+		raw := data[12*i : 12*(i+1)]
+		e := ifdEntry{typ: t.order.Uint16(raw[2:]), count: t.order.Uint32(raw[4:])}
+		copy(e.value[:], raw[8:])
+		entries[t.order.Uint16(raw)] = e
+	}
+	return entries, nil
+}
+
+func (t tiffReader) long(e ifdEntry) uint32 {
+	if e.typ != typeLong && e.typ != typeIFD {
+		return 0
+	}
+	return t.order.Uint32(e.value[:])
+}
+
+// ascii returns a string value, values longer than 4 bytes are stored at an offset.
+func (t tiffReader) ascii(e ifdEntry) string {
+	if e.typ != typeASCII || e.count > 64 {
+		return ""
+	}
+	data := e.value[:]
+	if e.count > 4 {
+		data = make([]byte, e.count)
+		if _, err := t.r.ReadAt(data, int64(t.order.Uint32(e.value[:]))); err != nil {
+			return ""
+		}
+	}
+	return strings.TrimRight(string(data[:min(int(e.count), len(data))]), "\x00 ")
+}
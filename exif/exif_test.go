@@ -0,0 +1,83 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/immichtest"
+)
+
+func TestDecodeJPEG(t *testing.T) {
+	info, err := Decode(bytes.NewReader(immichtest.JPEG("2024:07:14 13:05:09", "", false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.DateTimeOriginal.Equal(time.Date(2024, time.July, 14, 13, 5, 9, 0, time.UTC)) || info.HasZone || info.HasGPS {
+		t.Errorf("Expected the capture time without zone and position, got %+v", info)
+	}
+
+	info, err = Decode(bytes.NewReader(immichtest.JPEG("2024:07:14 13:05:09", "+02:00", true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.DateTimeOriginal.Equal(time.Date(2024, time.July, 14, 11, 5, 9, 0, time.UTC)) || !info.HasZone || !info.HasGPS {
+		t.Errorf("Expected the capture time in +02:00 and a position, got %+v", info)
+	}
+}
+
+func TestDecodeTIFF(t *testing.T) {
+	jpeg := immichtest.JPEG("2024:07:14 13:05:09", "", false)
+	// A TIFF based RAW file is the TIFF structure of the APP1 segment
+	info, err := Decode(bytes.NewReader(jpeg[12 : len(jpeg)-2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.DateTimeOriginal.IsZero() {
+		t.Errorf("Expected the capture time, got %+v", info)
+	}
+}
+
+func TestDecodeWithoutExif(t *testing.T) {
+	for _, data := range [][]byte{[]byte("not an image"), {0xFF, 0xD8, 0xFF, 0xDA, 0, 2}, {}} {
+		if _, err := Decode(bytes.NewReader(data)); !errors.Is(err, ErrNoExif) {
+			t.Errorf("Expected ErrNoExif for %v, got %v", data, err)
+		}
+	}
+}
+
+// withIFDType changes the type of the Exif and GPS IFD pointers in IFD0 of a JPEG written by immichtest.JPEG.
+func withIFDType(jpeg []byte, typ uint16) []byte {
+	jpeg = bytes.Clone(jpeg)
+	tiff := jpeg[12:]
+	ifd0 := binary.LittleEndian.Uint32(tiff[4:])
+	for n := range binary.LittleEndian.Uint16(tiff[ifd0:]) {
+		entry := tiff[ifd0+2+12*uint32(n):]
+		if tag := binary.LittleEndian.Uint16(entry); tag == tagExifIFD || tag == tagGPSIFD {
+			binary.LittleEndian.PutUint16(entry[2:], typ)
+		}
+	}
+	return jpeg
+}
+
+func TestDecodeIFDPointerType(t *testing.T) {
+	jpeg := withIFDType(immichtest.JPEG("2024:07:14 13:05:09", "+02:00", true), typeIFD)
+	info, err := Decode(bytes.NewReader(jpeg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.DateTimeOriginal.Equal(time.Date(2024, time.July, 14, 11, 5, 9, 0, time.UTC)) || !info.HasZone || !info.HasGPS {
+		t.Errorf("Expected the capture time and position behind IFD typed pointers, got %+v", info)
+	}
+
+	// Pointers of other types are not followed
+	info, err = Decode(bytes.NewReader(withIFDType(jpeg, typeASCII)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.DateTimeOriginal.IsZero() || info.HasGPS {
+		t.Errorf("Expected no capture time and position behind ASCII typed pointers, got %+v", info)
+	}
+}
@@ -0,0 +1,146 @@
+package fswatch
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultTempPatterns match the names of files that are still being written by common tools
+// and are renamed once they are complete (browsers, rsync, Syncthing, Nextcloud, Resilio, editors).
+var DefaultTempPatterns = []string{
+	"*.tmp", "*.temp", "*.part", "*.partial", "*.crdownload", "*.download", "*.!sync",
+	".syncthing.*", "~syncthing~*", ".~tmp~*", "*.swp", ".*.??????",
+}
+
+// IsTemporary reports whether the name of the file at path matches one of the patterns.
+func IsTemporary(path string, patterns []string) bool {
+	name := filepath.Base(path)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type pendingFile struct {
+	op    Op
+	state fileState
+	due   time.Time
+}
+
+// Debouncer reports files only once they are completely written: after their size and modification time
+// did not change for the quiet period, or as soon as a writer closed them (IN_CLOSE_WRITE).
+// Files matching the temp patterns are ignored, they are reported when renamed to their final name.
+type Debouncer struct {
+	watcher  Watcher
+	quiet    time.Duration
+	patterns []string
+	events   chan Event
+	done     chan any
+	once     sync.Once
+}
+
+func NewDebouncer(w Watcher, quiet time.Duration, patterns []string) *Debouncer {
+	d := &Debouncer{
+		watcher:  w,
+		quiet:    quiet,
+		patterns: patterns,
+		events:   make(chan Event, 64),
+		done:     make(chan any),
+	}
+	go d.run()
+	return d
+}
+
+func (d *Debouncer) run() {
+	defer close(d.events)
+	pending := make(map[string]*pendingFile)
+	ticker := time.NewTicker(max(d.quiet/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		ready := make([]Event, 0)
+		select {
+		case <-d.done:
+			return
+		case event, ok := <-d.watcher.Events():
+			if !ok {
+				return
+			}
+			switch {
+			case event.Op == Overflow:
+				ready = append(ready, event)
+			case IsTemporary(event.Path, d.patterns):
+			case event.Op == Closed || d.quiet <= 0:
+				ready = append(ready, Event{Path: event.Path, Op: completedOp(pending[event.Path], event.Op)})
+				delete(pending, event.Path)
+			default:
+				p, ok := pending[event.Path]
+				if !ok {
+					p = &pendingFile{op: event.Op}
+					pending[event.Path] = p
+				}
+				p.state, _ = stat(event.Path)
+				p.due = time.Now().Add(d.quiet)
+			}
+		case now := <-ticker.C:
+			for path, p := range pending {
+				if now.Before(p.due) {
+					continue
+				}
+				state, err := stat(path)
+				switch {
+				case err != nil:
+					delete(pending, path) // Removed before it was complete
+				case state != p.state:
+					p.state, p.due = state, now.Add(d.quiet)
+				default:
+					ready = append(ready, Event{Path: path, Op: completedOp(p, Write)})
+					delete(pending, path)
+				}
+			}
+		}
+		for _, event := range ready {
+			select {
+			case d.events <- event:
+			case <-d.done:
+				return
+			}
+		}
+	}
+}
+
+// completedOp is Create for files that appeared while they were pending and Write otherwise.
+func completedOp(p *pendingFile, op Op) Op {
+	if op == Create || (p != nil && p.op == Create) {
+		return Create
+	}
+	return Write
+}
+
+func stat(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+func (d *Debouncer) Events() <-chan Event {
+	return d.events
+}
+
+func (d *Debouncer) Errors() <-chan error {
+	return d.watcher.Errors()
+}
+
+func (d *Debouncer) Mode() string {
+	return d.watcher.Mode()
+}
+
+func (d *Debouncer) Close() error {
+	d.once.Do(func() { close(d.done) })
+	return d.watcher.Close()
+}
@@ -0,0 +1,114 @@
+// Package fswatch reports new and written files in a directory tree, using inotify
+// where possible and polling for file systems that do not support it.
+package fswatch
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+type Op uint8
+
+const (
+	// Create is sent for files that appeared in the tree, e.g. created or moved in.
+	Create Op = iota + 1
+	// Write is sent for files whose content changed.
+	Write
+	// Closed is sent when a file opened for writing was closed (inotify only), it is usually complete.
+	Closed
+	// Overflow is sent when events were lost, the whole tree needs to be scanned again.
+	Overflow
+)
+
+func (o Op) String() string {
+	switch o {
+	case Create:
+		return "create"
+	case Write:
+		return "write"
+	case Closed:
+		return "closed"
+	case Overflow:
+		return "overflow"
+	}
+	return "unknown"
+}
+
+type Event struct {
+	Path string
+	Op   Op
+}
+
+// Watcher watches a directory tree recursively. Events is closed once the watcher is closed.
+type Watcher interface {
+	Events() <-chan Event
+	Errors() <-chan error
+	// Mode is "inotify" or "poll".
+	Mode() string
+	Close() error
+}
+
+// DefaultPollInterval is used when inotify is not available for a directory and no interval is configured.
+const DefaultPollInterval = time.Minute
+
+// ErrUnsupported is returned by NewInotify if the directory can not be watched with inotify.
+var ErrUnsupported = errors.New("inotify is not supported for this directory")
+
+// New watches root with inotify, or by polling every pollInterval if it is set.
+// Network file systems and directories inotify fails for are polled every DefaultPollInterval.
+func New(root string, pollInterval time.Duration) (Watcher, error) {
+	if pollInterval > 0 {
+		return NewPoller(root, pollInterval)
+	}
+	w, err := NewInotify(root)
+	if err == nil {
+		return w, nil
+	}
+	p, pollErr := NewPoller(root, DefaultPollInterval)
+	if pollErr != nil {
+		return nil, pollErr
+	}
+	return p, &FallbackError{Err: err}
+}
+
+// FallbackError is returned by New with a working polling watcher if inotify could not be used.
+type FallbackError struct {
+	Err error
+}
+
+func (e *FallbackError) Error() string {
+	return "falling back to polling: " + e.Err.Error()
+}
+
+func (e *FallbackError) Unwrap() error {
+	return e.Err
+}
+
+// walkFiles calls f for every regular file below root, unreadable directories are skipped.
+func walkFiles(root string, f func(path string, info fs.FileInfo)) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			f(path, info)
+		}
+		return nil
+	})
+}
+
+// sendError passes err on without blocking, errors are dropped if nobody reads them.
+func sendError(errs chan error, err error) {
+	select {
+	case errs <- err:
+	default:
+	}
+}
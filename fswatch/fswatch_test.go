@@ -0,0 +1,147 @@
+package fswatch
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// expectEvent waits for an event with one of ops for path, events for other paths are skipped.
+func expectEvent(t *testing.T, w Watcher, path string, ops ...Op) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-w.Events():
+			if event.Path == path && slices.Contains(ops, event.Op) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no %v event for %s", ops, path)
+		}
+	}
+}
+
+func testWatcher(t *testing.T, root string, w Watcher) {
+	file := filepath.Join(root, "a.jpg")
+	os.WriteFile(file, []byte("a"), 0o600)
+	expectEvent(t, w, file, Create, Closed)
+
+	moved := filepath.Join(root, "moved.jpg")
+	os.Rename(file, moved)
+	expectEvent(t, w, moved, Create)
+
+	// Files in new directories are reported, even if they were created before the directory was watched
+	sub := filepath.Join(root, "sub", "deeper")
+	os.MkdirAll(sub, 0o700)
+	nested := filepath.Join(sub, "b.jpg")
+	os.WriteFile(nested, []byte("b"), 0o600)
+	expectEvent(t, w, nested, Create)
+
+	os.WriteFile(nested, []byte("changed"), 0o600)
+	expectEvent(t, w, nested, Write, Closed)
+
+	w.Close()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-w.Events():
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("events were not closed")
+		}
+	}
+}
+
+func TestInotify(t *testing.T) {
+	root := t.TempDir()
+	w, err := NewInotify(root)
+	if err != nil {
+		t.Skipf("inotify not available: %s", err)
+	}
+	testWatcher(t, root, w)
+}
+
+func TestPoller(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "existing.jpg"), []byte("e"), 0o600)
+	w, err := NewPoller(root, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWatcher(t, root, w)
+}
+
+func TestNewFallsBackToPolling(t *testing.T) {
+	if _, err := New(filepath.Join(t.TempDir(), "missing"), 0); err == nil {
+		t.Errorf("Expected an error for a missing directory")
+	}
+	w, err := New(t.TempDir(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Mode() != "poll" {
+		t.Errorf("Expected a configured interval to poll, got %s", w.Mode())
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	root := t.TempDir()
+	source, err := NewPoller(root, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewDebouncer(source, 200*time.Millisecond, DefaultTempPatterns)
+	defer w.Close()
+
+	// A file that keeps growing is reported once it stopped changing
+	file := filepath.Join(root, "a.jpg")
+	f, _ := os.Create(file)
+	start := time.Now()
+	for range 5 {
+		f.Write([]byte("data"))
+		time.Sleep(50 * time.Millisecond)
+	}
+	f.Close()
+	temp := filepath.Join(root, "b.jpg.part")
+	os.WriteFile(temp, []byte("b"), 0o600)
+
+	select {
+	case event := <-w.Events():
+		if event.Path != file || event.Op != Create {
+			t.Fatalf("Expected a create event for %s, got %+v", file, event)
+		}
+		if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+			t.Errorf("Expected the event after the quiet period, got it after %s", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event for the written file")
+	}
+
+	// Temporary files are reported under their final name
+	final := filepath.Join(root, "b.jpg")
+	os.Rename(temp, final)
+	expectEvent(t, w, final, Create)
+}
+
+func TestIsTemporary(t *testing.T) {
+	for path, temporary := range map[string]bool{
+		"/a/IMG_0001.JPG":                false,
+		"/a/IMG_0001.JPG.part":           true,
+		"/a/.syncthing.IMG_0001.JPG.tmp": true,
+		"/a/.IMG_0001.JPG.a1B2c3":        true,
+		"/a/~syncthing~IMG_0001.JPG.tmp": true,
+		"/a/.hidden.jpg":                 false,
+		"/a/download.crdownload":         true,
+		"/a/partial/IMG_0002.JPG":        false,
+	} {
+		if IsTemporary(path, DefaultTempPatterns) != temporary {
+			t.Errorf("Expected IsTemporary(%s) to be %t", path, temporary)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package fswatch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	smb2SuperMagic = 0xfe534d42
+
+	dirMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM |
+		unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW | unix.IN_EXCL_UNLINK
+)
+
+// Inotify watches a directory tree with one inotify watch per directory.
+// Directories created or moved into the tree are added as they appear.
+type Inotify struct {
+	fd     int
+	file   *os.File
+	events chan Event
+	errors chan error
+	done   chan any
+	once   sync.Once
+	// Only used by the read loop after NewInotify returned
+	watches map[int]string
+	root    string
+}
+
+// NewInotify starts watching root. It returns ErrUnsupported for network file systems,
+// which do not report changes made by other hosts.
+func NewInotify(root string) (*Inotify, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(root, &st); err != nil {
+		return nil, err
+	}
+	switch uint32(st.Type) {
+	case unix.NFS_SUPER_MAGIC, unix.SMB_SUPER_MAGIC, unix.CIFS_SUPER_MAGIC, smb2SuperMagic:
+		return nil, fmt.Errorf("%w: %s is a network file system", ErrUnsupported, root)
+	}
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupported, err)
+	}
+	w := &Inotify{
+		fd: fd,
+		// A non-blocking file uses the runtime poller, so Close interrupts a pending Read
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		events:  make(chan Event, 64),
+		errors:  make(chan error, 16),
+		done:    make(chan any),
+		watches: make(map[int]string),
+		root:    root,
+	}
+	if err = w.addRecursive(root, nil); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// addRecursive adds watches for dir and all directories below it.
+// If found is set, it is called for all files found, these may have been created before the watch existed.
+func (w *Inotify) addRecursive(dir string, found func(path string)) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			sendError(w.errors, err)
+			return nil
+		}
+		if !d.IsDir() {
+			if found != nil && d.Type().IsRegular() {
+				found(path)
+			}
+			return nil
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, path, dirMask)
+		if errors.Is(err, unix.ENOSPC) {
+			return fmt.Errorf("%w: inotify watch limit reached, raise fs.inotify.max_user_watches", ErrUnsupported)
+		}
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			sendError(w.errors, fmt.Errorf("failed to watch %s: %w", path, err))
+			return fs.SkipDir
+		}
+		w.watches[wd] = path
+		return nil
+	})
+}
+
+func (w *Inotify) run() {
+	defer close(w.events)
+	defer w.file.Close()
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				sendError(w.errors, err)
+			}
+			return
+		}
+		pending := make([]Event, 0)
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			name := string(buf[nameStart : nameStart+int(raw.Len)])
+			offset = nameStart + int(raw.Len)
+			pending = w.handle(int(raw.Wd), raw.Mask, strings.TrimRight(name, "\x00"), pending)
+		}
+		for _, event := range pending {
+			select {
+			case w.events <- event:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// handle converts a raw inotify event and appends the resulting events to pending.
+func (w *Inotify) handle(wd int, mask uint32, name string, pending []Event) []Event {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		return append(pending, Event{Path: w.root, Op: Overflow})
+	}
+	dir, ok := w.watches[wd]
+	if !ok {
+		return pending
+	}
+	switch {
+	case mask&unix.IN_IGNORED != 0:
+		delete(w.watches, wd)
+	case mask&unix.IN_ISDIR != 0 && mask&unix.IN_MOVED_FROM != 0:
+		// The directory left the tree, it is added again under its new name if moved within the tree
+		w.removeRecursive(filepath.Join(dir, name))
+	case mask&unix.IN_ISDIR != 0 && mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		path := filepath.Join(dir, name)
+		err := w.addRecursive(path, func(file string) {
+			pending = append(pending, Event{Path: file, Op: Create})
+		})
+		if err != nil {
+			sendError(w.errors, err)
+			if errors.Is(err, ErrUnsupported) {
+				pending = append(pending, Event{Path: w.root, Op: Overflow})
+			}
+		}
+	case mask&unix.IN_ISDIR != 0:
+	case mask&unix.IN_MOVED_TO != 0:
+		pending = append(pending, Event{Path: filepath.Join(dir, name), Op: Create})
+	case mask&unix.IN_CREATE != 0:
+		// New files are reported when they are written to or closed
+	case mask&unix.IN_MODIFY != 0:
+		pending = append(pending, Event{Path: filepath.Join(dir, name), Op: Write})
+	case mask&unix.IN_CLOSE_WRITE != 0:
+		pending = append(pending, Event{Path: filepath.Join(dir, name), Op: Closed})
+	}
+	return pending
+}
+
+// removeRecursive removes the watches of dir and all directories below it.
+func (w *Inotify) removeRecursive(dir string) {
+	for wd, path := range w.watches {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+		}
+	}
+}
+
+func (w *Inotify) Events() <-chan Event {
+	return w.events
+}
+
+func (w *Inotify) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *Inotify) Mode() string {
+	return "inotify"
+}
+
+func (w *Inotify) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+		// Interrupts the pending read, the read loop closes the file
+		w.file.SetReadDeadline(time.Now())
+	})
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package fswatch
+
+// Inotify is only available on Linux, other systems always poll.
+type Inotify struct {
+	Poller
+}
+
+func NewInotify(root string) (*Inotify, error) {
+	return nil, ErrUnsupported
+}
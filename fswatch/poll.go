@@ -0,0 +1,104 @@
+package fswatch
+
+import (
+	"io/fs"
+	"sync"
+	"time"
+)
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Poller detects changes by walking the tree in an interval and comparing size and modification time.
+type Poller struct {
+	root     string
+	interval time.Duration
+	events   chan Event
+	errors   chan error
+	done     chan any
+	once     sync.Once
+	files    map[string]fileState
+}
+
+// NewPoller starts polling root. Files that exist when it starts are not reported.
+func NewPoller(root string, interval time.Duration) (*Poller, error) {
+	p := &Poller{
+		root:     root,
+		interval: interval,
+		events:   make(chan Event, 64),
+		errors:   make(chan error, 16),
+		done:     make(chan any),
+		files:    make(map[string]fileState),
+	}
+	err := walkFiles(root, func(path string, info fs.FileInfo) {
+		p.files[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+	})
+	if err != nil {
+		return nil, err
+	}
+	go p.run()
+	return p, nil
+}
+
+func (p *Poller) run() {
+	defer close(p.events)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		if !p.poll() {
+			return
+		}
+	}
+}
+
+// poll walks the tree once and sends the changes, it returns false if the poller was closed.
+func (p *Poller) poll() bool {
+	seen := make(map[string]fileState, len(p.files))
+	changed := make([]Event, 0)
+	err := walkFiles(p.root, func(path string, info fs.FileInfo) {
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		seen[path] = state
+		if old, ok := p.files[path]; !ok {
+			changed = append(changed, Event{Path: path, Op: Create})
+		} else if old != state {
+			changed = append(changed, Event{Path: path, Op: Write})
+		}
+	})
+	if err != nil {
+		sendError(p.errors, err)
+		return true
+	}
+	p.files = seen
+	for _, event := range changed {
+		select {
+		case p.events <- event:
+		case <-p.done:
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Poller) Events() <-chan Event {
+	return p.events
+}
+
+func (p *Poller) Errors() <-chan error {
+	return p.errors
+}
+
+func (p *Poller) Mode() string {
+	return "poll"
+}
+
+func (p *Poller) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
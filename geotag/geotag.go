@@ -0,0 +1,254 @@
+// Package geotag finds the position of photos in GPX tracks by their capture time.
+package geotag
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/exif"
+)
+
+// DefaultMaxGap is used if Config.MaxGap is not set.
+const DefaultMaxGap = 5 * time.Minute
+
+// Config sets the position of uploaded files from GPX tracks.
+type Config struct {
+	// Tracks are GPX files and directories with GPX files, new and changed files are read before each lookup
+	Tracks []string `json:"tracks" mapstructure:"tracks"`
+	// Offset is how far the camera clock is ahead of the real time, negative if it is behind
+	Offset time.Duration `json:"offset" mapstructure:"offset"`
+	// MaxGap is how far apart the track points around the capture time may be, and how far
+	// the capture time may be before or after a track, for a position to be used
+	MaxGap time.Duration `json:"max_gap" mapstructure:"max_gap"`
+}
+
+func (c Config) Enabled() bool {
+	return len(c.Tracks) > 0
+}
+
+func (c Config) Equal(other Config) bool {
+	return slices.Equal(c.Tracks, other.Tracks) && c.Offset == other.Offset && c.MaxGap == other.MaxGap
+}
+
+func (c Config) Validate() error {
+	if c.MaxGap < 0 {
+		return errors.New("gpx max_gap needs to be positive")
+	}
+	for _, track := range c.Tracks {
+		if track == "" {
+			return errors.New("gpx tracks contain an empty path")
+		}
+	}
+	return nil
+}
+
+func (c Config) maxGap() time.Duration {
+	if c.MaxGap == 0 {
+		return DefaultMaxGap
+	}
+	return c.MaxGap
+}
+
+// Point is a position of a track.
+type Point struct {
+	Time      time.Time
+	Latitude  float64
+	Longitude float64
+}
+
+// Segment is a continuous part of a track, ordered by time.
+type Segment []Point
+
+type gpxDocument struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []struct {
+				Latitude  float64 `xml:"lat,attr"`
+				Longitude float64 `xml:"lon,attr"`
+				Time      string  `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// ParseGPX reads the track segments of a GPX file, points without a time are skipped.
+func ParseGPX(r io.Reader) ([]Segment, error) {
+	var doc gpxDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	var segments []Segment
+	for _, track := range doc.Tracks {
+		for _, s := range track.Segments {
+			var segment Segment
+			for _, p := range s.Points {
+				t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(p.Time))
+				if err != nil {
+					continue
+				}
+				segment = append(segment, Point{Time: t, Latitude: p.Latitude, Longitude: p.Longitude})
+			}
+			if len(segment) == 0 {
+				continue
+			}
+			slices.SortStableFunc(segment, func(a, b Point) int { return a.Time.Compare(b.Time) })
+			segments = append(segments, segment)
+		}
+	}
+	return segments, nil
+}
+
+// Position returns the position at time t, interpolated between the points around it.
+// If these are more than maxGap apart, the nearer one is used if it is at most maxGap away.
+func (s Segment) Position(t time.Time, maxGap time.Duration) (Point, bool) {
+	if len(s) == 0 || t.Before(s[0].Time.Add(-maxGap)) || t.After(s[len(s)-1].Time.Add(maxGap)) {
+		return Point{}, false
+	}
+	n, _ := slices.BinarySearchFunc(s, t, func(p Point, t time.Time) int { return p.Time.Compare(t) })
+	switch {
+	case n == 0:
+		return s[0], true
+	case n == len(s):
+		return s[len(s)-1], true
+	}
+	before, after := s[n-1], s[n]
+	gap := after.Time.Sub(before.Time)
+	if gap > maxGap {
+		if t.Sub(before.Time) <= after.Time.Sub(t) {
+			return before, t.Sub(before.Time) <= maxGap
+		}
+		return after, after.Time.Sub(t) <= maxGap
+	}
+	f := float64(t.Sub(before.Time)) / float64(gap)
+	return Point{
+		Time:      t,
+		Latitude:  before.Latitude + f*(after.Latitude-before.Latitude),
+		Longitude: before.Longitude + f*(after.Longitude-before.Longitude),
+	}, true
+}
+
+type gpxFile struct {
+	modTime  time.Time
+	size     int64
+	segments []Segment
+}
+
+// Tagger finds positions in the GPX files of a Config.
+type Tagger struct {
+	cfg   Config
+	mu    sync.Mutex
+	files map[string]gpxFile
+}
+
+func NewTagger(cfg Config) *Tagger {
+	return &Tagger{cfg: cfg, files: make(map[string]gpxFile)}
+}
+
+func (t *Tagger) Config() Config {
+	return t.cfg
+}
+
+// Locate returns the position of a photo at its capture time, corrected by the clock offset.
+// Files without a capture time and files that already have a position are not located.
+// Capture times without a time zone are taken as local time.
+func (t *Tagger) Locate(path string) (Point, bool, error) {
+	info, err := exif.Read(path)
+	if errors.Is(err, exif.ErrNoExif) {
+		return Point{}, false, nil
+	}
+	if err != nil {
+		return Point{}, false, err
+	}
+	if info.HasGPS || info.DateTimeOriginal.IsZero() {
+		return Point{}, false, nil
+	}
+	taken := info.DateTimeOriginal
+	if !info.HasZone {
+		taken = time.Date(taken.Year(), taken.Month(), taken.Day(), taken.Hour(), taken.Minute(), taken.Second(), 0, time.Local)
+	}
+	return t.Position(taken.Add(-t.cfg.Offset))
+}
+
+// Position returns the position at time at in the tracks. Errors reading single GPX files are returned
+// together with the position found in the other files.
+func (t *Tagger) Position(at time.Time) (Point, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := t.refresh()
+	for _, name := range slices.Sorted(maps.Keys(t.files)) {
+		for _, segment := range t.files[name].segments {
+			if p, ok := segment.Position(at, t.cfg.maxGap()); ok {
+				return p, true, err
+			}
+		}
+	}
+	return Point{}, false, err
+}
+
+// refresh reads new and changed GPX files and forgets removed ones. t.mu must be held.
+func (t *Tagger) refresh() error {
+	var errs []error
+	seen := make(map[string]bool)
+	add := func(path string, info fs.FileInfo) {
+		seen[path] = true
+		if cached, ok := t.files[path]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return
+		}
+		segments, err := readGPX(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read track '%s': %w", path, err))
+			delete(t.files, path)
+			return
+		}
+		t.files[path] = gpxFile{modTime: info.ModTime(), size: info.Size(), segments: segments}
+	}
+	for _, track := range t.cfg.Tracks {
+		stat, err := os.Stat(track)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !stat.IsDir() {
+			add(track, stat)
+			continue
+		}
+		err = filepath.WalkDir(track, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".gpx") {
+				return err
+			}
+			info, err := d.Info()
+			if err == nil {
+				add(path, info)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for path := range t.files {
+		if !seen[path] {
+			delete(t.files, path)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func readGPX(path string) ([]Segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseGPX(f)
+}
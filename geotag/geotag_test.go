@@ -0,0 +1,102 @@
+package geotag
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/immichtest"
+)
+
+const track = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><name>Hike</name>
+    <trkseg>
+      <trkpt lat="47.0" lon="11.0"><ele>1000</ele><time>2024-07-14T11:00:00Z</time></trkpt>
+      <trkpt lat="47.1" lon="11.2"><ele>1100</ele><time>2024-07-14T11:02:00Z</time></trkpt>
+      <trkpt lat="47.2" lon="11.4"><time>2024-07-14T11:30:00Z</time></trkpt>
+      <trkpt lat="48.0" lon="12.0"></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+func TestSegmentPosition(t *testing.T) {
+	segments, err := ParseGPX(strings.NewReader(track))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 || len(segments[0]) != 3 {
+		t.Fatalf("Expected one segment with the 3 points with a time, got %v", segments)
+	}
+	base := time.Date(2024, time.July, 14, 11, 0, 0, 0, time.UTC)
+	tests := []struct {
+		at       time.Duration
+		ok       bool
+		lat, lon float64
+	}{
+		{time.Minute, true, 47.05, 11.1},     // interpolated
+		{-4 * time.Minute, true, 47.0, 11.0}, // shortly before the track
+		{-6 * time.Minute, false, 0, 0},
+		{5 * time.Minute, true, 47.1, 11.2}, // points 28 minutes apart, the earlier one is near
+		{15 * time.Minute, false, 0, 0},
+		{27 * time.Minute, true, 47.2, 11.4},
+		{34 * time.Minute, true, 47.2, 11.4}, // shortly after the track
+		{36 * time.Minute, false, 0, 0},
+	}
+	for _, test := range tests {
+		p, ok := segments[0].Position(base.Add(test.at), DefaultMaxGap)
+		if ok != test.ok {
+			t.Errorf("%s: expected found %v, got %v", test.at, test.ok, ok)
+			continue
+		}
+		if ok && (math.Abs(p.Latitude-test.lat) > 1e-9 || math.Abs(p.Longitude-test.lon) > 1e-9) {
+			t.Errorf("%s: expected %f, %f, got %f, %f", test.at, test.lat, test.lon, p.Latitude, p.Longitude)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	dir := t.TempDir()
+	tracks := filepath.Join(dir, "tracks")
+	if err := os.Mkdir(tracks, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	write := func(path string, data []byte) {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The camera clock is set to CEST and runs 2 minutes fast
+	tagger := NewTagger(Config{Tracks: []string{tracks}, Offset: 2 * time.Minute})
+	photo := filepath.Join(dir, "photo.jpg")
+	write(photo, immichtest.JPEG("2024:07:14 13:03:00", "+02:00", false))
+	if _, ok, err := tagger.Locate(photo); ok || err != nil {
+		t.Errorf("Expected no position without tracks, got %v, %v", ok, err)
+	}
+
+	// Tracks added later are read
+	write(filepath.Join(tracks, "hike.gpx"), []byte(track))
+	p, ok, err := tagger.Locate(photo)
+	if !ok || err != nil || math.Abs(p.Latitude-47.05) > 1e-9 {
+		t.Errorf("Expected the position at 11:01 UTC, got %+v, %v, %v", p, ok, err)
+	}
+
+	tagged := filepath.Join(dir, "tagged.jpg")
+	write(tagged, immichtest.JPEG("2024:07:14 13:03:00", "+02:00", true))
+	if _, ok, _ := tagger.Locate(tagged); ok {
+		t.Errorf("Expected files with a position not to be located")
+	}
+	other := filepath.Join(dir, "other.jpg")
+	write(other, immichtest.JPEG("2024:07:15 13:03:00", "+02:00", false))
+	if _, ok, _ := tagger.Locate(other); ok {
+		t.Errorf("Expected files taken outside the tracks not to be located")
+	}
+
+	write(filepath.Join(tracks, "broken.gpx"), []byte("<gpx"))
+	if _, ok, err := tagger.Locate(photo); !ok || err == nil {
+		t.Errorf("Expected the position and an error for the broken track, got %v, %v", ok, err)
+	}
+}
@@ -4,75 +4,105 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/JonaEnz/immich-sync/oapi"
 	"github.com/google/uuid"
 )
 
+// ImmichAlbumCache is safe for concurrent use, requests to the server are made without holding the lock.
 type ImmichAlbumCache struct {
+	mu    *sync.Mutex
 	cache map[string]*oapi.AlbumResponseDto
 }
 
 func NewImmichAlbumCache() ImmichAlbumCache {
 	return ImmichAlbumCache{
+		mu:    &sync.Mutex{},
 		cache: make(map[string]*oapi.AlbumResponseDto),
 	}
 }
 
-func (a *ImmichAlbumCache) FillCache(server *ImmichServer) error {
+func (a *ImmichAlbumCache) get(albumID string) (*oapi.AlbumResponseDto, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	album, ok := a.cache[albumID]
+	return album, ok
+}
+
+func (a *ImmichAlbumCache) set(album *oapi.AlbumResponseDto) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cache[album.ID] = album
+}
+
+func (a *ImmichAlbumCache) remove(albumID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.cache, albumID)
+}
+
+// byName returns the first cached album with the name.
+func (a *ImmichAlbumCache) byName(name string) (*oapi.AlbumResponseDto, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, album := range a.cache {
+		if album.AlbumName == name {
+			return album, true
+		}
+	}
+	return nil, false
+}
+
+func (a *ImmichAlbumCache) FillCache(ctx context.Context, server *ImmichServer) error {
+	ctx, cancel := withTimeout(ctx, server.timeouts.API)
+	defer cancel()
 	assetUUID := oapi.OptUUID{}
 	assetUUID.Reset()
-	result, err := server.oapiClient.GetAllAlbums(context.Background(), oapi.GetAllAlbumsParams{AssetId: assetUUID})
+	result, err := server.oapiClient.GetAllAlbums(ctx, oapi.GetAllAlbumsParams{AssetId: assetUUID})
 	if err != nil {
 		return err
 	}
 	for _, album := range result {
-		a.cache[album.ID] = &album
+		a.set(&album)
 	}
 	return nil
 }
 
-func (a *ImmichAlbumCache) GetAlbumUUIDByName(server *ImmichServer, name string) (uuid.UUID, error) {
-	for _, a := range a.cache {
-		if a.AlbumName == name {
-			u, err := uuid.Parse(a.ID)
-			if err != nil {
-				return uuid.UUID{}, err
-			}
-			return u, nil
-		}
+// GetAlbumUUIDByName returns the album of the name, the error wraps ErrAlbumNotFound if the server has none.
+func (a *ImmichAlbumCache) GetAlbumUUIDByName(ctx context.Context, server *ImmichServer, name string) (uuid.UUID, error) {
+	if album, ok := a.byName(name); ok {
+		return uuid.Parse(album.ID)
 	}
-	a.FillCache(server)
-	for _, a := range a.cache {
-		if a.AlbumName == name {
-			u, err := uuid.Parse(a.ID)
-			if err != nil {
-				return uuid.UUID{}, err
-			}
-			return u, nil
-		}
+	if err := a.FillCache(ctx, server); err != nil {
+		return uuid.UUID{}, fmt.Errorf("failed to list albums: %w", err)
+	}
+	if album, ok := a.byName(name); ok {
+		return uuid.Parse(album.ID)
 	}
-	return uuid.UUID{}, fmt.Errorf("an album with name %s does not exist.", name)
+	return uuid.UUID{}, fmt.Errorf("%w: %s", ErrAlbumNotFound, name)
 }
 
-func (a *ImmichAlbumCache) updateAlbum(server *ImmichServer, albumUUID uuid.UUID) error {
-	resp, err := server.oapiClient.GetAlbumInfo(context.Background(), oapi.GetAlbumInfoParams{
+func (a *ImmichAlbumCache) updateAlbum(ctx context.Context, server *ImmichServer, albumUUID uuid.UUID) error {
+	ctx, cancel := withTimeout(ctx, server.timeouts.API)
+	defer cancel()
+	resp, err := server.oapiClient.GetAlbumInfo(ctx, oapi.GetAlbumInfoParams{
 		ID:            albumUUID,
 		WithoutAssets: oapi.NewOptBool(false),
 	})
 	if err != nil {
 		return err
 	}
-	a.cache[resp.ID] = resp
+	a.set(resp)
 	return nil
 }
 
-func (a *ImmichAlbumCache) Album(server *ImmichServer, albumUUID uuid.UUID) (*oapi.AlbumResponseDto, error) {
+func (a *ImmichAlbumCache) Album(ctx context.Context, server *ImmichServer, albumUUID uuid.UUID) (*oapi.AlbumResponseDto, error) {
 	tryGet := func(server *ImmichServer, albumUUID uuid.UUID) (*oapi.AlbumResponseDto, error) {
-		if album, ok := a.cache[albumUUID.String()]; ok {
+		if album, ok := a.get(albumUUID.String()); ok {
 			if album.AssetCount != len(album.Assets) {
-				a.updateAlbum(server, albumUUID)
-				album = a.cache[albumUUID.String()]
+				a.updateAlbum(ctx, server, albumUUID)
+				album, _ = a.get(albumUUID.String())
 			}
 			return album, nil
 		}
@@ -82,6 +112,6 @@ func (a *ImmichAlbumCache) Album(server *ImmichServer, albumUUID uuid.UUID) (*oa
 	if err == nil {
 		return album, nil
 	}
-	a.FillCache(server)
+	a.FillCache(ctx, server)
 	return tryGet(server, albumUUID)
 }
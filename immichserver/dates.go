@@ -0,0 +1,130 @@
+package immichserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/exif"
+	"github.com/JonaEnz/immich-sync/oapi"
+	"github.com/google/uuid"
+)
+
+// DateCorrection corrects the capture times of a camera whose clock is off or does not know its time zone.
+type DateCorrection struct {
+	// Offset is how far the camera clock is ahead of the real time, negative if it is behind
+	Offset time.Duration
+	// Location is the time zone the camera clock is set to, used for files that do not store one.
+	// nil uses the local time zone and does not change the time zone of the assets.
+	Location *time.Location
+}
+
+func (c DateCorrection) Enabled() bool {
+	return c.Offset != 0 || c.Location != nil
+}
+
+// ParseTimezone reads a time zone, an IANA name (Europe/Berlin) or a fixed offset (+01:00) for cameras that ignore
+// daylight saving time. An empty name returns nil.
+func ParseTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(name, "+") || strings.HasPrefix(name, "-") {
+		zone, err := time.Parse("-07:00", name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone '%s', expected a name like Europe/Berlin or an offset like +01:00", name)
+		}
+		_, seconds := zone.Zone()
+		return time.FixedZone(name, seconds), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone '%s': %w", name, err)
+	}
+	return loc, nil
+}
+
+// CaptureTime returns the corrected capture time of a file from its EXIF data, false if the file has none.
+func (c DateCorrection) CaptureTime(path string) (time.Time, bool, error) {
+	info, err := exif.Read(path)
+	if errors.Is(err, exif.ErrNoExif) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	taken := info.DateTimeOriginal
+	if taken.IsZero() {
+		return time.Time{}, false, nil
+	}
+	if !info.HasZone {
+		loc := c.Location
+		if loc == nil {
+			loc = time.Local
+		}
+		taken = time.Date(taken.Year(), taken.Month(), taken.Day(), taken.Hour(), taken.Minute(), taken.Second(), 0, loc)
+	}
+	// Keep the time zone of the camera for the corrected time
+	return taken.Add(-c.Offset).In(taken.Location()), true, nil
+}
+
+// timeZoneName returns the time zone sent to Immich, IANA names as they are and fixed offsets as UTC+01:00.
+func timeZoneName(loc *time.Location) string {
+	name := loc.String()
+	if strings.HasPrefix(name, "+") || strings.HasPrefix(name, "-") {
+		return "UTC" + name
+	}
+	return name
+}
+
+// SetCaptureTime sets the capture time (dateTimeOriginal) of assets. Capture times in the time zone of the
+// correction move the assets to it, others keep their offset and Immich the time zone of the assets.
+func (i *ImmichServer) SetCaptureTime(ctx context.Context, assetUUIDs []uuid.UUID, captured time.Time, correction DateCorrection) error {
+	request := &oapi.AssetBulkUpdateDto{
+		Ids:              assetUUIDs,
+		DateTimeOriginal: oapi.NewOptString(captured.Format(time.RFC3339)),
+	}
+	if correction.Location != nil && captured.Location() == correction.Location {
+		request.TimeZone = oapi.NewOptString(timeZoneName(correction.Location))
+	}
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	return i.oapiClient.UpdateAssets(ctx, request)
+}
+
+// FixDates sets the capture times of all files uploaded from the directory, corrected by dates,
+// e.g. after the date correction of the directory was set. Files without an EXIF capture time are left untouched.
+// It returns the number of corrected files and of files without a capture time.
+func (i *ImageDirectory) FixDates(ctx context.Context, server *ImmichServer, dates DateCorrection) (fixed, skipped int, err error) {
+	files := i.files()
+	var errs []error
+	for _, filePath := range slices.Sorted(maps.Keys(files)) {
+		entry := files[filePath]
+		if !entry.uploaded {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return fixed, skipped, err
+		}
+		captured, ok, err := dates.CaptureTime(filePath)
+		if err == nil && ok {
+			err = server.SetCaptureTime(ctx, []uuid.UUID{entry.uuid}, captured, dates)
+		}
+		switch {
+		case err != nil:
+			slog.Error("failed to correct capture time", "op", "dates", "dir", i.path, "path", filePath, "asset_id", entry.uuid.String(), "err", err)
+			errs = append(errs, fmt.Errorf("%s: %w", filePath, err))
+		case ok:
+			slog.Debug("corrected capture time", "op", "dates", "dir", i.path, "path", filePath, "asset_id", entry.uuid.String(), "captured", captured.Format(time.RFC3339))
+			fixed += 1
+		default:
+			skipped += 1
+		}
+	}
+	return fixed, skipped, errors.Join(errs...)
+}
@@ -0,0 +1,101 @@
+package immichserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/immichtest"
+)
+
+func TestParseTimezone(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int
+		ok     bool
+	}{
+		{"", 0, true},
+		{"UTC", 0, true},
+		{"+01:00", 3600, true},
+		{"-05:30", -19800, true},
+		{"+1", 0, false},
+		{"Mars/Olympus", 0, false},
+	}
+	summer := time.Date(2024, time.July, 14, 12, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		loc, err := ParseTimezone(test.name)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.ok, err)
+			continue
+		}
+		if err != nil || loc == nil {
+			continue
+		}
+		if _, offset := summer.In(loc).Zone(); offset != test.offset {
+			t.Errorf("%s: expected offset %d, got %d", test.name, test.offset, offset)
+		}
+	}
+	if loc, _ := ParseTimezone(""); loc != nil {
+		t.Errorf("Expected no time zone for an empty name, got %s", loc)
+	}
+}
+
+func TestDateCorrection(t *testing.T) {
+	fake, server := newTestServer(t)
+	path := t.TempDir()
+	files := map[string][]byte{
+		// The camera runs 5 minutes fast and does not store its time zone
+		"local.jpg":  immichtest.JPEG("2024:07:14 13:05:00", "", false),
+		"zoned.jpg":  immichtest.JPEG("2024:07:14 13:05:00", "+02:00", false),
+		"noexif.jpg": []byte("no exif"),
+	}
+	base := time.Now().Add(-time.Hour)
+	for name, data := range files {
+		file := filepath.Join(path, name)
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(file, base, base)
+	}
+	zone, _ := ParseTimezone("+01:00")
+	dir := NewImageDirectory(path, false)
+	dir.SetDateCorrection(DateCorrection{Offset: 5 * time.Minute, Location: zone})
+	uploadDirectory(t, server, &dir, false)
+
+	asset := func(name string) immichtest.Asset {
+		status, _ := dir.FileStatus(filepath.Join(path, name))
+		a, ok := fake.Asset(status.AssetID)
+		if !ok {
+			t.Fatalf("Expected %s to be uploaded", name)
+		}
+		return a
+	}
+	local := asset("local.jpg")
+	if local.DateTimeOriginal != "2024-07-14T13:00:00+01:00" || local.TimeZone != "UTC+01:00" {
+		t.Errorf("Expected local.jpg taken at 13:00 in UTC+01:00, got %s in %q", local.DateTimeOriginal, local.TimeZone)
+	}
+	if !local.FileCreatedAt.Equal(time.Date(2024, time.July, 14, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected local.jpg created at the corrected capture time, got %s", local.FileCreatedAt)
+	}
+	// Files with a time zone keep it
+	if zoned := asset("zoned.jpg"); zoned.DateTimeOriginal != "2024-07-14T13:00:00+02:00" || zoned.TimeZone != "" {
+		t.Errorf("Expected zoned.jpg taken at 13:00 in +02:00, got %s in %q", zoned.DateTimeOriginal, zoned.TimeZone)
+	}
+	if noexif := asset("noexif.jpg"); noexif.DateTimeOriginal != "" {
+		t.Errorf("Expected noexif.jpg not to get a capture time, got %s", noexif.DateTimeOriginal)
+	}
+
+	// The camera was actually 1 hour behind
+	fixed, skipped, err := dir.FixDates(context.Background(), server, DateCorrection{Offset: -time.Hour, Location: zone})
+	if err != nil || fixed != 2 || skipped != 1 {
+		t.Fatalf("Expected 2 corrected files and 1 without capture time, got %d, %d, %v", fixed, skipped, err)
+	}
+	if local := asset("local.jpg"); local.DateTimeOriginal != "2024-07-14T14:05:00+01:00" {
+		t.Errorf("Expected local.jpg taken at 14:05, got %s", local.DateTimeOriginal)
+	}
+	if zoned := asset("zoned.jpg"); zoned.DateTimeOriginal != "2024-07-14T14:05:00+02:00" {
+		t.Errorf("Expected zoned.jpg taken at 14:05, got %s", zoned.DateTimeOriginal)
+	}
+}
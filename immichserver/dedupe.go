@@ -0,0 +1,144 @@
+package immichserver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// assetIndex maps the sha1 of uploaded content to its asset across all directories of a server,
+// so identical files in several directories are uploaded once and linked to the same asset.
+type assetIndex struct {
+	mu       sync.Mutex
+	seeded   bool
+	assets   map[string]uuid.UUID
+	inflight map[string]*pendingUpload
+}
+
+type pendingUpload struct {
+	done  chan any
+	asset uuid.UUID
+	err   error
+}
+
+// uploadOnce returns the asset of content that was already uploaded from any directory of the server,
+// otherwise it uploads the content with upload. Concurrent calls for the same content wait for the first
+// upload instead of transferring the file again. deduped is true if upload was not called.
+func (i *ImmichServer) uploadOnce(ctx context.Context, sha1 string, upload func() (uuid.UUID, error)) (asset uuid.UUID, deduped bool, err error) {
+	x := &i.assets
+	for {
+		x.mu.Lock()
+		if !x.seeded {
+			x.seed(i.Directories())
+		}
+		if asset, ok := x.assets[sha1]; ok {
+			x.mu.Unlock()
+			return asset, true, nil
+		}
+		p, ok := x.inflight[sha1]
+		if !ok {
+			break
+		}
+		x.mu.Unlock()
+		select {
+		case <-p.done:
+			if p.err == nil {
+				return p.asset, true, nil
+			}
+			// The other upload failed, try it with this file
+		case <-ctx.Done():
+			return uuid.UUID{}, false, ctx.Err()
+		}
+	}
+	p := &pendingUpload{done: make(chan any)}
+	x.inflight[sha1] = p
+	x.mu.Unlock()
+
+	p.asset, p.err = upload()
+	x.mu.Lock()
+	delete(x.inflight, sha1)
+	if p.err == nil {
+		x.assets[sha1] = p.asset
+	}
+	x.mu.Unlock()
+	close(p.done)
+	return p.asset, false, p.err
+}
+
+// seed indexes the files uploaded before, e.g. restored from the state file. x.mu must be held.
+func (x *assetIndex) seed(dirs []*ImageDirectory) {
+	x.assets = make(map[string]uuid.UUID)
+	x.inflight = make(map[string]*pendingUpload)
+	x.addUploaded(dirs)
+	x.seeded = true
+}
+
+// index adds the uploaded files of directories added to the server after the index was seeded.
+func (x *assetIndex) index(dirs ...*ImageDirectory) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.seeded {
+		x.addUploaded(dirs)
+	}
+}
+
+// addUploaded indexes the uploaded files of the directories, content already indexed keeps its asset. x.mu must be held.
+func (x *assetIndex) addUploaded(dirs []*ImageDirectory) {
+	for _, dir := range dirs {
+		dir.mu.RLock()
+		for _, entry := range dir.contentCache {
+			if entry.uploaded && !entry.updated && len(entry.hashSha1) > 0 {
+				if _, ok := x.assets[entry.HashHexString()]; !ok {
+					x.assets[entry.HashHexString()] = entry.uuid
+				}
+			}
+		}
+		dir.mu.RUnlock()
+	}
+}
+
+// forgetAsset removes a deleted asset from the index.
+func (i *ImmichServer) forgetAsset(asset uuid.UUID) {
+	x := &i.assets
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for sha1, a := range x.assets {
+		if a == asset {
+			delete(x.assets, sha1)
+		}
+	}
+}
+
+// assetInUse reports whether a file other than exceptPath in any directory of the server is linked to the asset.
+func (i *ImmichServer) assetInUse(asset uuid.UUID, exceptPath string) bool {
+	for _, dir := range i.Directories() {
+		dir.mu.RLock()
+		for filePath, entry := range dir.contentCache {
+			if filePath != exceptPath && entry.uploaded && entry.uuid == asset {
+				dir.mu.RUnlock()
+				return true
+			}
+		}
+		dir.mu.RUnlock()
+	}
+	return false
+}
+
+// assetsOutside returns the assets linked to files in the directories of the server other than dir.
+func (i *ImmichServer) assetsOutside(dir *ImageDirectory) map[uuid.UUID]bool {
+	assets := make(map[uuid.UUID]bool)
+	for _, other := range i.Directories() {
+		if other == dir {
+			continue
+		}
+		other.mu.RLock()
+		for _, entry := range other.contentCache {
+			if entry.uploaded {
+				assets[entry.uuid] = true
+			}
+		}
+		other.mu.RUnlock()
+	}
+	return assets
+}
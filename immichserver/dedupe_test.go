@@ -0,0 +1,165 @@
+package immichserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/geotag"
+	"github.com/JonaEnz/immich-sync/immichtest"
+	"github.com/JonaEnz/immich-sync/oapi"
+	"github.com/google/uuid"
+)
+
+func TestDeduplicateAcrossDirectories(t *testing.T) {
+	fake, server := newTestServer(t)
+	pool := newTestPool(t)
+	base := time.Now().Add(-time.Hour)
+	var dirs []*ImageDirectory
+	var albumIDs []string
+	var files []string
+	for _, name := range []string{"Camera", "Phone"} {
+		albumID := fake.AddAlbum(name)
+		albumUUID := uuid.MustParse(albumID)
+		path := t.TempDir()
+		for _, file := range []string{"a.jpg", "copy.jpg"} {
+			files = append(files, filepath.Join(path, file))
+			writeFile(t, files[len(files)-1], "same", base)
+		}
+		dir := NewImageDirectory(path, false)
+		dir.SetAlbum(&albumUUID)
+		server.AddDirectory(&dir)
+		dirs = append(dirs, &dir)
+		albumIDs = append(albumIDs, albumID)
+	}
+
+	for _, dir := range dirs {
+		if _, err := dir.Read(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		dir.Upload(context.Background(), server, pool, false)
+	}
+	for _, dir := range dirs {
+		if !dir.WaitForUploads(10 * time.Second) {
+			t.Fatal("uploads did not finish")
+		}
+	}
+
+	if fake.Uploads() != 1 || len(fake.Assets()) != 1 {
+		t.Fatalf("Expected identical files to be uploaded once, got %d uploads of %d assets", fake.Uploads(), len(fake.Assets()))
+	}
+	asset := fake.Assets()[0]
+	for n, file := range files {
+		status, ok := dirs[n/2].FileStatus(file)
+		if !ok || status.State != FileUploaded || status.AssetID != asset.ID {
+			t.Errorf("Expected %s to be linked to %s, got %+v", file, asset.ID, status)
+		}
+	}
+	for _, albumID := range albumIDs {
+		if album, _ := fake.Album(albumID); len(album.AssetIDs) != 1 || album.AssetIDs[0] != asset.ID {
+			t.Errorf("Expected the asset in album %s, got %v", albumID, album.AssetIDs)
+		}
+	}
+
+	// Replacing one copy keeps the asset of the others
+	writeFile(t, files[0], "changed", base.Add(time.Minute))
+	uploadDirectory(t, server, dirs[0], false)
+	if a, _ := fake.Asset(asset.ID); a.IsTrashed {
+		t.Errorf("Expected the asset still linked to other files not to be trashed")
+	}
+	if status, _ := dirs[0].FileStatus(files[0]); status.AssetID == asset.ID {
+		t.Errorf("Expected the changed file to be linked to a new asset")
+	}
+}
+
+func TestLinkedAssetKeepsOptions(t *testing.T) {
+	fake, server := newTestServer(t)
+	base := time.Now().Add(-time.Hour)
+	first, second := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(first, "a.jpg"), "same", base)
+	writeFile(t, filepath.Join(second, "a.jpg"), "same", base)
+	own := writeFile(t, filepath.Join(second, "b.jpg"), "own", base)
+	camera, phone := NewImageDirectory(first, false), NewImageDirectory(second, false)
+	phone.SetUploadOptions(UploadOptions{Favorite: true})
+	server.AddDirectory(&camera)
+	server.AddDirectory(&phone)
+	uploadDirectory(t, server, &camera, false)
+	uploadDirectory(t, server, &phone, false)
+
+	linked, _ := phone.FileStatus(filepath.Join(second, "a.jpg"))
+	if asset, _ := fake.Asset(linked.AssetID); asset.IsFavorite {
+		t.Errorf("Expected the linked asset to keep the options of the directory that uploaded it")
+	}
+	if asset, _ := fake.AssetByChecksum(own); !asset.IsFavorite {
+		t.Errorf("Expected the options to apply to the files uploaded from the directory")
+	}
+
+	if err := phone.UpdateUploadOptions(context.Background(), server, UploadOptions{Visibility: oapi.AssetVisibilityArchive}); err != nil {
+		t.Fatal(err)
+	}
+	if asset, _ := fake.Asset(linked.AssetID); asset.Visibility != oapi.AssetVisibilityTimeline {
+		t.Errorf("Expected changed options not to apply to the linked asset, got %s", asset.Visibility)
+	}
+	if asset, _ := fake.AssetByChecksum(own); asset.Visibility != oapi.AssetVisibilityArchive || asset.IsFavorite {
+		t.Errorf("Expected changed options to apply to the own asset, got %+v", asset)
+	}
+}
+
+func TestIndexDirectoryAddedLater(t *testing.T) {
+	_, server := newTestServer(t)
+	base := time.Now().Add(-time.Hour)
+	first := NewImageDirectory(t.TempDir(), false)
+	writeFile(t, filepath.Join(first.Path(), "a.jpg"), "a", base)
+	server.AddDirectory(&first)
+	uploadDirectory(t, server, &first, false)
+
+	// A directory with files uploaded before, e.g. restored from the state file
+	later := NewImageDirectory(t.TempDir(), false)
+	checksum := writeFile(t, filepath.Join(later.Path(), "b.jpg"), "b", base)
+	asset := uuid.New()
+	later.restoreFiles(map[string]savedFile{
+		filepath.Join(later.Path(), "b.jpg"): {Sha1: checksum, Size: 1, ModTime: base, Uploaded: true, AssetID: asset.String(), State: FileUploaded},
+	}, nil)
+	server.AddDirectory(&later)
+
+	server.assets.mu.Lock()
+	indexed := server.assets.assets[checksum]
+	server.assets.mu.Unlock()
+	if indexed != asset {
+		t.Errorf("Expected the uploaded files of a directory added later to be indexed, got %s", indexed)
+	}
+}
+
+func TestLinkedAssetKeepsDatesAndPosition(t *testing.T) {
+	fake, server := newTestServer(t)
+	base := time.Now().Add(-time.Hour)
+	tracks := t.TempDir()
+	gpx := `<gpx><trk><trkseg>
+		<trkpt lat="47.0" lon="11.0"><time>2024-07-14T10:58:00Z</time></trkpt>
+		<trkpt lat="47.2" lon="11.2"><time>2024-07-14T11:02:00Z</time></trkpt>
+	</trkseg></trk></gpx>`
+	os.WriteFile(filepath.Join(tracks, "hike.gpx"), []byte(gpx), 0o600)
+	first, second := t.TempDir(), t.TempDir()
+	photo := immichtest.JPEG("2024:07:14 13:00:00", "+02:00", false)
+	for _, path := range []string{first, second} {
+		writeFile(t, filepath.Join(path, "a.jpg"), string(photo), base)
+	}
+	camera, phone := NewImageDirectory(first, false), NewImageDirectory(second, false)
+	phone.SetDateCorrection(DateCorrection{Offset: time.Hour})
+	phone.SetGeotag(geotag.Config{Tracks: []string{tracks}})
+	server.AddDirectory(&camera)
+	server.AddDirectory(&phone)
+	uploadDirectory(t, server, &camera, false)
+	uploadDirectory(t, server, &phone, false)
+
+	linked, _ := phone.FileStatus(filepath.Join(second, "a.jpg"))
+	asset, ok := fake.Asset(linked.AssetID)
+	if !ok || len(fake.Assets()) != 1 {
+		t.Fatalf("Expected the file of the phone to be linked to the asset of the camera, got %d assets", len(fake.Assets()))
+	}
+	if asset.DateTimeOriginal != "" || asset.Latitude != nil {
+		t.Errorf("Expected the linked asset to keep its capture time and position, got %s at %v", asset.DateTimeOriginal, asset.Latitude)
+	}
+}
@@ -0,0 +1,205 @@
+package immichserver
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/JonaEnz/immich-sync/oapi"
+)
+
+type PlannedAction string
+
+const (
+	ActionUpload        PlannedAction = "upload"
+	ActionReplace       PlannedAction = "replace"
+	ActionSkipDuplicate PlannedAction = "skip-duplicate"
+	ActionSkipFile      PlannedAction = "skip-unsupported"
+	ActionDelete        PlannedAction = "delete"
+)
+
+// bulkCheckSize is the number of checksums sent to the server in one duplicate check.
+const bulkCheckSize = 1000
+
+// PlannedFile is an action an upload would take, as reported by a dry run.
+type PlannedFile struct {
+	Path    string        `json:"path"`
+	Action  PlannedAction `json:"action"`
+	Size    int64         `json:"size"`
+	Sha1    string        `json:"sha1,omitempty"`
+	AssetID string        `json:"assetId,omitempty"`
+	Album   string        `json:"album,omitempty"`
+	Reason  string        `json:"reason,omitempty"`
+}
+
+// CheckDuplicates asks the server which of the checksums (by path) already exist as assets.
+// The result maps the path of every duplicate to the id of the existing asset.
+func (i *ImmichServer) CheckDuplicates(ctx context.Context, checksums map[string]string) (map[string]string, error) {
+	paths := slices.Sorted(maps.Keys(checksums))
+	duplicates := make(map[string]string)
+	for start := 0; start < len(paths); start += bulkCheckSize {
+		batch := paths[start:min(start+bulkCheckSize, len(paths))]
+		request := &oapi.AssetBulkUploadCheckDto{Assets: make([]oapi.AssetBulkUploadCheckItem, len(batch))}
+		for n, p := range batch {
+			request.Assets[n] = oapi.AssetBulkUploadCheckItem{ID: p, Checksum: checksums[p]}
+		}
+		batchCtx, cancel := withTimeout(ctx, i.timeouts.API)
+		response, err := i.oapiClient.CheckBulkUpload(batchCtx, request)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range response.Results {
+			if result.Action == oapi.AssetBulkUploadCheckResultActionReject {
+				duplicates[result.ID] = result.AssetId.Or("")
+			}
+		}
+	}
+	return duplicates, nil
+}
+
+// PlanUpload reports what uploading the files would do, without uploading or changing anything.
+func (i *ImmichServer) PlanUpload(ctx context.Context, paths []string, album string) ([]PlannedFile, error) {
+	planned := make([]PlannedFile, 0, len(paths))
+	checksums := make(map[string]string)
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		h := sha1.New()
+		size, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		file := PlannedFile{Path: p, Action: ActionUpload, Size: size, Sha1: fmt.Sprintf("%x", h.Sum(nil)), Album: album}
+		if _, err = mediaType(p); err != nil {
+			file.Action, file.Reason = ActionSkipFile, err.Error()
+		} else {
+			checksums[p] = file.Sha1
+		}
+		planned = append(planned, file)
+	}
+	return i.markDuplicates(ctx, planned, checksums)
+}
+
+func (i *ImmichServer) markDuplicates(ctx context.Context, planned []PlannedFile, checksums map[string]string) ([]PlannedFile, error) {
+	duplicates, err := i.CheckDuplicates(ctx, checksums)
+	if err != nil {
+		return nil, fmt.Errorf("duplicate check failed: %w", err)
+	}
+	for n := range planned {
+		if assetID, ok := duplicates[planned[n].Path]; ok && planned[n].Action == ActionUpload {
+			planned[n].Action, planned[n].AssetID = ActionSkipDuplicate, assetID
+		}
+	}
+	return planned, nil
+}
+
+// planCopy returns a copy of the directory with its own file cache, reading it leaves the directory unchanged.
+func (i *ImageDirectory) planCopy() *ImageDirectory {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	dir := NewImageDirectory(i.path, i.subdir)
+	dir.album = i.album
+	dir.contentCache = maps.Clone(i.contentCache)
+	dir.tempPatterns = i.tempPatterns
+	dir.hashWorkers = i.hashWorkers
+	dir.hashLimiter = i.hashLimiter
+	return &dir
+}
+
+// PlanUpload reads the directory and reports what its next upload would do.
+// The file cache of the directory is not changed, so a watched directory can be planned.
+func (i *ImageDirectory) PlanUpload(ctx context.Context, server *ImmichServer, keepChangedFiles bool) ([]PlannedFile, error) {
+	dir := i.planCopy()
+	if _, err := dir.Read(ctx); err != nil {
+		return nil, err
+	}
+	albumUUID := dir.album
+	album := ""
+	if albumUUID != nil {
+		album = albumUUID.String()
+		if a, err := server.Album(ctx, *albumUUID); err == nil {
+			album = a.AlbumName
+		}
+	}
+	planned := make([]PlannedFile, 0)
+	checksums := make(map[string]string)
+	files := dir.files()
+	for _, imagePath := range slices.Sorted(maps.Keys(files)) {
+		entry := files[imagePath]
+		if !entry.needsUpload() {
+			continue
+		}
+		file := PlannedFile{Path: imagePath, Action: ActionUpload, Size: entry.size, Sha1: entry.HashHexString(), Album: album}
+		if _, err := mediaType(imagePath); err != nil {
+			file.Action, file.Reason = ActionSkipFile, err.Error()
+			planned = append(planned, file)
+			continue
+		}
+		checksums[imagePath] = file.Sha1
+		if entry.uploaded && entry.updated {
+			file.Action, file.AssetID = ActionReplace, entry.uuid.String()
+			planned = append(planned, file)
+			if !keepChangedFiles {
+				planned = append(planned, PlannedFile{Path: imagePath, Action: ActionDelete, AssetID: entry.uuid.String(), Reason: "old version of replaced file"})
+			}
+			continue
+		}
+		planned = append(planned, file)
+	}
+	return server.markDuplicates(ctx, planned, checksums)
+}
+
+// PlanDirectories reports what the next upload of all directories of the server would do.
+// Identical files are uploaded once per server, so a new file whose content was uploaded from any
+// directory, or is planned for upload from an earlier file, would be linked instead of uploaded.
+func (i *ImmichServer) PlanDirectories(ctx context.Context, keepChangedFiles bool) ([]PlannedFile, error) {
+	x := &i.assets
+	x.mu.Lock()
+	if !x.seeded {
+		x.seed(i.Directories())
+	}
+	uploaded := maps.Clone(x.assets)
+	x.mu.Unlock()
+
+	planned := make([]PlannedFile, 0)
+	uploading := make(map[string]string)
+	for _, dir := range i.Directories() {
+		dirPlan, err := dir.PlanUpload(ctx, i, keepChangedFiles)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir.Path(), err)
+		}
+		for n, file := range dirPlan {
+			if file.Action != ActionUpload {
+				continue
+			}
+			if asset, ok := uploaded[file.Sha1]; ok {
+				dirPlan[n].Action, dirPlan[n].AssetID, dirPlan[n].Reason = ActionSkipDuplicate, asset.String(), "identical to an uploaded file"
+			} else if first, ok := uploading[file.Sha1]; ok {
+				dirPlan[n].Action, dirPlan[n].Reason = ActionSkipDuplicate, fmt.Sprintf("identical to %s", first)
+			} else {
+				uploading[file.Sha1] = file.Path
+			}
+		}
+		planned = append(planned, dirPlan...)
+	}
+	return planned, nil
+}
+
+// PlannedBytes returns the number of bytes the planned actions would upload.
+func PlannedBytes(planned []PlannedFile) int64 {
+	var total int64
+	for _, file := range planned {
+		if file.Action == ActionUpload || file.Action == ActionReplace {
+			total += file.Size
+		}
+	}
+	return total
+}
@@ -0,0 +1,108 @@
+package immichserver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestPlanUpload(t *testing.T) {
+	fake, server := newTestServer(t)
+	albumUUID := uuid.MustParse(fake.AddAlbum("Camera"))
+	existing := fake.AddAsset("existing.jpg", []byte("existing"))
+
+	path := t.TempDir()
+	base := time.Now().Add(-time.Hour)
+	changed := filepath.Join(path, "changed.jpg")
+	writeFile(t, changed, "first", base)
+	dir := NewImageDirectory(path, false)
+	dir.SetAlbum(&albumUUID)
+	uploadDirectory(t, server, &dir, false)
+	uploaded, _ := dir.FileStatus(changed)
+
+	changedSha1 := writeFile(t, changed, "second", base.Add(time.Minute))
+	newSha1 := writeFile(t, filepath.Join(path, "new.jpg"), "new", base)
+	writeFile(t, filepath.Join(path, "dup.jpg"), "existing", base)
+	writeFile(t, filepath.Join(path, "README"), "notes", base)
+
+	planned, err := dir.PlanUpload(context.Background(), server, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PlannedFile{
+		{Path: filepath.Join(path, "README"), Action: ActionSkipFile, Size: 5, Album: "Camera"},
+		{Path: changed, Action: ActionReplace, Size: 6, Sha1: changedSha1, AssetID: uploaded.AssetID, Album: "Camera"},
+		{Path: changed, Action: ActionDelete, AssetID: uploaded.AssetID, Reason: "old version of replaced file"},
+		{Path: filepath.Join(path, "dup.jpg"), Action: ActionSkipDuplicate, Size: 8, AssetID: existing, Album: "Camera"},
+		{Path: filepath.Join(path, "new.jpg"), Action: ActionUpload, Size: 3, Sha1: newSha1, Album: "Camera"},
+	}
+	if len(planned) != len(expected) {
+		t.Fatalf("Expected %d planned actions, got %+v", len(expected), planned)
+	}
+	for n, want := range expected {
+		got := planned[n]
+		if got.Path != want.Path || got.Action != want.Action || got.AssetID != want.AssetID || got.Album != want.Album ||
+			(want.Size != 0 && got.Size != want.Size) || (want.Sha1 != "" && got.Sha1 != want.Sha1) ||
+			(want.Reason != "" && got.Reason != want.Reason) {
+			t.Errorf("Expected planned action %d to be %+v, got %+v", n, want, got)
+		}
+	}
+	if bytes := PlannedBytes(planned); bytes != 9 {
+		t.Errorf("Expected 9 bytes to be uploaded, got %d", bytes)
+	}
+
+	if kept, _ := dir.PlanUpload(context.Background(), server, true); len(kept) != len(expected)-1 {
+		t.Errorf("Expected no delete when changed files are kept, got %+v", kept)
+	}
+	if _, ok := dir.FileStatus(filepath.Join(path, "new.jpg")); ok {
+		t.Errorf("Expected planning not to add new files to the directory")
+	}
+	if status, _ := dir.FileStatus(changed); status.State != FileUploaded {
+		t.Errorf("Expected planning not to mark the changed file pending, got %+v", status)
+	}
+	if len(fake.Assets()) != 2 {
+		t.Errorf("Expected planning not to upload, got %d assets", len(fake.Assets()))
+	}
+}
+
+func TestPlanDirectoriesDedupe(t *testing.T) {
+	_, server := newTestServer(t)
+	base := time.Now().Add(-time.Hour)
+	first, second := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(first, "a.jpg"), "uploaded", base)
+	dir := NewImageDirectory(first, false)
+	server.AddDirectory(&dir)
+	uploadDirectory(t, server, &dir, false)
+	uploaded, _ := dir.FileStatus(filepath.Join(first, "a.jpg"))
+
+	writeFile(t, filepath.Join(first, "b.jpg"), "new", base)
+	writeFile(t, filepath.Join(second, "a.jpg"), "uploaded", base)
+	writeFile(t, filepath.Join(second, "b.jpg"), "new", base)
+	other := NewImageDirectory(second, false)
+	server.AddDirectory(&other)
+
+	planned, err := server.PlanDirectories(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PlannedFile{
+		{Path: filepath.Join(first, "b.jpg"), Action: ActionUpload},
+		{Path: filepath.Join(second, "a.jpg"), Action: ActionSkipDuplicate, AssetID: uploaded.AssetID},
+		{Path: filepath.Join(second, "b.jpg"), Action: ActionSkipDuplicate, Reason: "identical to " + filepath.Join(first, "b.jpg")},
+	}
+	if len(planned) != len(expected) {
+		t.Fatalf("Expected %d planned actions, got %+v", len(expected), planned)
+	}
+	for n, want := range expected {
+		got := planned[n]
+		if got.Path != want.Path || got.Action != want.Action || got.AssetID != want.AssetID || (want.Reason != "" && got.Reason != want.Reason) {
+			t.Errorf("Expected planned action %d to be %+v, got %+v", n, want, got)
+		}
+	}
+	if bytes := PlannedBytes(planned); bytes != 3 {
+		t.Errorf("Expected identical files to be counted once, got %d bytes", bytes)
+	}
+}
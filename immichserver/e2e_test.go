@@ -0,0 +1,430 @@
+package immichserver
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/fswatch"
+	"github.com/JonaEnz/immich-sync/immichtest"
+	"github.com/JonaEnz/immich-sync/oapi"
+	"github.com/google/uuid"
+)
+
+func newTestServer(t *testing.T) (*immichtest.Server, *ImmichServer) {
+	fake := immichtest.NewServer(t)
+	server, err := NewImmichServer(NewSecuritySource(ServerConfig{APIKey: fake.APIKey}), fake.URL, "test-device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, server
+}
+
+func writeFile(t *testing.T, path string, data string, modTime time.Time) string {
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	h := sha1.Sum([]byte(data))
+	return hex.EncodeToString(h[:])
+}
+
+func newTestPool(t *testing.T) *WorkerPool {
+	pool := NewWorkerPool(2)
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func uploadDirectory(t *testing.T, server *ImmichServer, dir *ImageDirectory, keepChangedFiles bool) {
+	if _, err := dir.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	dir.Upload(context.Background(), server, newTestPool(t), keepChangedFiles)
+	if !dir.WaitForUploads(10 * time.Second) {
+		t.Fatal("uploads did not finish")
+	}
+}
+
+func TestUploadDirectory(t *testing.T) {
+	fake, server := newTestServer(t)
+	albumID := fake.AddAlbum("Camera")
+	albumUUID := uuid.MustParse(albumID)
+	existing := fake.AddAsset("existing.jpg", []byte("existing"))
+
+	path := t.TempDir()
+	base := time.Now().Add(-time.Hour)
+	checksumA := writeFile(t, filepath.Join(path, "a.jpg"), "a", base)
+	writeFile(t, filepath.Join(path, "dup.jpg"), "existing", base)
+	dir := NewImageDirectory(path, false)
+	dir.SetAlbum(&albumUUID)
+	dir.SetUploadOptions(UploadOptions{Favorite: true})
+	uploadDirectory(t, server, &dir, false)
+
+	asset, ok := fake.AssetByChecksum(checksumA)
+	if !ok {
+		t.Fatalf("Expected a.jpg to be uploaded")
+	}
+	if !asset.IsFavorite || asset.DeviceID != "test-device" {
+		t.Errorf("Expected favorite asset from test-device, got favorite=%v device=%s", asset.IsFavorite, asset.DeviceID)
+	}
+	if len(fake.Assets()) != 2 {
+		t.Errorf("Expected the duplicate not to be uploaded again, got %d assets", len(fake.Assets()))
+	}
+	if dup, _ := fake.Asset(existing); !dup.IsFavorite {
+		t.Errorf("Expected upload options to be applied to the duplicate")
+	}
+	album, _ := fake.Album(albumID)
+	if len(album.AssetIDs) != 2 {
+		t.Errorf("Expected both files in the album, got %v", album.AssetIDs)
+	}
+	for _, name := range []string{"a.jpg", "dup.jpg"} {
+		status, ok := dir.FileStatus(filepath.Join(path, name))
+		if !ok || status.State != FileUploaded {
+			t.Errorf("Expected %s to be uploaded, got %+v", name, status)
+		}
+	}
+	if status, _ := dir.FileStatus(filepath.Join(path, "dup.jpg")); status.AssetID != existing {
+		t.Errorf("Expected dup.jpg to be linked to the existing asset %s, got %s", existing, status.AssetID)
+	}
+}
+
+func TestReplaceChangedFile(t *testing.T) {
+	fake, server := newTestServer(t)
+	albumID := fake.AddAlbum("Camera")
+	albumUUID := uuid.MustParse(albumID)
+
+	path := t.TempDir()
+	file := filepath.Join(path, "a.jpg")
+	base := time.Now().Add(-time.Hour)
+	writeFile(t, file, "first", base)
+	dir := NewImageDirectory(path, false)
+	dir.SetAlbum(&albumUUID)
+	uploadDirectory(t, server, &dir, false)
+	first, _ := dir.FileStatus(file)
+
+	checksum := writeFile(t, file, "second", base.Add(time.Minute))
+	uploadDirectory(t, server, &dir, false)
+
+	second, ok := fake.AssetByChecksum(checksum)
+	if !ok {
+		t.Fatalf("Expected the changed file to be uploaded")
+	}
+	if old, _ := fake.Asset(first.AssetID); !old.IsTrashed {
+		t.Errorf("Expected the old version to be trashed")
+	}
+	album, _ := fake.Album(albumID)
+	if len(album.AssetIDs) != 2 || album.AssetIDs[1] != second.ID {
+		t.Errorf("Expected the new version in the album, got %v", album.AssetIDs)
+	}
+	if status, _ := dir.FileStatus(file); status.AssetID != second.ID {
+		t.Errorf("Expected the file to be linked to %s, got %s", second.ID, status.AssetID)
+	}
+}
+
+func TestStackUploadedFiles(t *testing.T) {
+	fake, server := newTestServer(t)
+	path := t.TempDir()
+	base := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(path, "DSC_0001.JPG"), "jpeg", base)
+	writeFile(t, filepath.Join(path, "DSC_0001.NEF"), "raw", base)
+	dir := NewImageDirectory(path, false)
+	dir.SetStackConfig(StackConfig{Raw: true})
+	uploadDirectory(t, server, &dir, false)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		assets := fake.Assets()
+		if len(assets) == 2 && assets[0].StackID != "" && assets[0].StackID == assets[1].StackID {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected the RAW+JPEG pair to be stacked")
+}
+
+func TestSyncAndDownload(t *testing.T) {
+	fake, server := newTestServer(t)
+	before := time.Now().Add(-time.Second)
+	ids := make([]string, 150)
+	for n := range ids {
+		ids[n] = fake.AddAsset(fmt.Sprintf("%d.jpg", n), []byte(fmt.Sprintf("data %d", n)))
+	}
+
+	assets, err := server.DoFullSync(context.Background(), time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*assets) != len(ids) {
+		t.Errorf("Expected full sync to return %d assets, got %d", len(ids), len(*assets))
+	}
+
+	trashed := uuid.MustParse(ids[0])
+	if err = server.Delete(context.Background(), trashed); err != nil {
+		t.Fatal(err)
+	}
+	if asset, _ := fake.Asset(ids[0]); !asset.IsTrashed {
+		t.Errorf("Expected deleted asset to be in the trash")
+	}
+	delta, err := server.GetSyncAfter(context.Background(), before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Upserted) != len(ids) {
+		t.Errorf("Expected %d changed assets, got %d", len(ids), len(delta.Upserted))
+	}
+
+	target := t.TempDir()
+	if err = server.Download(context.Background(), target, uuid.MustParse(ids[1])); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(target, ids[1]))
+	if err != nil || string(data) != "data 1" {
+		t.Errorf("Expected downloaded asset to contain 'data 1', got '%s' (%v)", data, err)
+	}
+}
+
+func TestCancelledTransfers(t *testing.T) {
+	fake, server := newTestServer(t)
+	id := fake.AddAsset("a.jpg", []byte("a"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	target := t.TempDir()
+	if err := server.Download(ctx, target, uuid.MustParse(id)); err == nil {
+		t.Errorf("Expected a cancelled download to fail")
+	}
+	if _, err := os.Stat(filepath.Join(target, id)); !os.IsNotExist(err) {
+		t.Errorf("Expected no file after a cancelled download, got %v", err)
+	}
+
+	path := t.TempDir()
+	writeFile(t, filepath.Join(path, "b.jpg"), "b", time.Now().Add(-time.Hour))
+	dir := NewImageDirectory(path, false)
+	if _, err := dir.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	dir.Upload(ctx, server, newTestPool(t), false)
+	dir.WaitForUploads(10 * time.Second)
+	if status, _ := dir.FileStatus(filepath.Join(path, "b.jpg")); status.State != FilePending {
+		t.Errorf("Expected the file to stay pending after a cancelled upload, got %+v", status)
+	}
+	if len(fake.Assets()) != 1 {
+		t.Errorf("Expected nothing to be uploaded, got %d assets", len(fake.Assets()))
+	}
+}
+
+func TestWatchUploadsNewFiles(t *testing.T) {
+	fake, server := newTestServer(t)
+	pool := newTestPool(t)
+	for name, interval := range map[string]time.Duration{"inotify": 0, "poll": 20 * time.Millisecond} {
+		path := t.TempDir()
+		dir := NewImageDirectory(path, false)
+		dir.SetPollInterval(interval)
+		dir.SetWriteDetection(50*time.Millisecond, fswatch.DefaultTempPatterns)
+		dir.StartScan(context.Background(), server, pool, false)
+		if mode := dir.WatchMode(); mode != name && !(name == "inotify" && mode == "poll") {
+			t.Errorf("Expected the directory to be watched with %s, got '%s'", name, mode)
+		}
+		checksum := writeFile(t, filepath.Join(path, name+".jpg"), name, time.Now())
+
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, ok := fake.AssetByChecksum(checksum); ok {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		dir.Stop()
+		if _, ok := fake.AssetByChecksum(checksum); !ok {
+			t.Errorf("%s: expected the new file to be uploaded", name)
+		}
+	}
+}
+
+func TestConcurrentEventsScansAndQueries(t *testing.T) {
+	fake, server := newTestServer(t)
+	pool := newTestPool(t)
+	path := t.TempDir()
+	dir := NewImageDirectory(path, false)
+	dir.SetPollInterval(10 * time.Millisecond)
+	dir.SetWriteDetection(0, fswatch.DefaultTempPatterns)
+	dir.SetStackConfig(StackConfig{Raw: true})
+	server.AddDirectory(&dir)
+	ctx := context.Background()
+	dir.StartScan(ctx, server, pool, false)
+
+	checksums := make(map[string]string)
+	stop := make(chan any)
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() { // Scans
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := dir.Read(context.Background()); err != nil {
+				t.Error(err)
+				return
+			}
+			dir.Upload(ctx, server, pool, false)
+		}
+	}()
+	go func() { // Queries
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			server.Status(ctx)
+			dir.FileStatus(filepath.Join(path, "0.jpg"))
+			dir.Pending()
+			_ = dir.String()
+			server.GetImageUUIDByPath(filepath.Join(path, "1.jpg"))
+		}
+	}()
+	go func() { // Saving the state
+		defer wg.Done()
+		statePath := filepath.Join(t.TempDir(), "state.json")
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := SaveState(statePath, []*ImmichServer{server}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for n := range 20 {
+		name := filepath.Join(path, fmt.Sprintf("%d.jpg", n))
+		checksums[name] = writeFile(t, name, fmt.Sprintf("image %d", n), time.Now().Add(-time.Hour))
+		time.Sleep(2 * time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+
+	// Pick up files a scan hashed while they were written
+	if _, err := dir.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	dir.Upload(ctx, server, pool, false)
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := dir.Wait(waitCtx); err != nil {
+		t.Fatalf("uploads did not finish: %v", err)
+	}
+	dir.Stop()
+	for name, checksum := range checksums {
+		status, _ := dir.FileStatus(name)
+		asset, ok := fake.AssetByChecksum(checksum)
+		if status.State != FileUploaded || !ok || status.AssetID != asset.ID {
+			t.Errorf("Expected %s to be uploaded once, got %+v", name, status)
+		}
+	}
+	if dir.Pending() != 0 {
+		t.Errorf("Expected no pending files, got %d", dir.Pending())
+	}
+}
+
+func TestWaitForUploads(t *testing.T) {
+	_, server := newTestServer(t)
+	path := t.TempDir()
+	for n := range 5 {
+		writeFile(t, filepath.Join(path, fmt.Sprintf("%d.jpg", n)), fmt.Sprint(n), time.Now().Add(-time.Hour))
+	}
+	dir := NewImageDirectory(path, false)
+	if err := dir.Wait(context.Background()); err != nil {
+		t.Errorf("Expected Wait to return at once without uploads, got %v", err)
+	}
+	if _, err := dir.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pool := NewWorkerPool(1)
+	block := make(chan any)
+	pool.Submit(JobBackfill, 0, func() { <-block })
+	dir.Upload(context.Background(), server, pool, false)
+	if dir.WaitForUploads(50 * time.Millisecond) {
+		t.Errorf("Expected WaitForUploads to time out while uploads are queued")
+	}
+	close(block)
+	if !dir.WaitForUploads(10 * time.Second) {
+		t.Fatal("uploads did not finish")
+	}
+	if dir.Pending() != 0 {
+		t.Errorf("Expected all files to be uploaded after Wait, got %d pending", dir.Pending())
+	}
+	pool.Close()
+}
+
+func TestUploadOptions(t *testing.T) {
+	fake, server := newTestServer(t)
+	server.Profile = "default"
+	path := t.TempDir()
+	name := filepath.Join(path, "a.jpg")
+	writeFile(t, name, "aaaa", time.Now().Add(-time.Hour))
+	dir := NewImageDirectory(path, false)
+	server.AddDirectory(&dir)
+	dir.SetUploadOptions(UploadOptions{Favorite: true, Visibility: oapi.AssetVisibilityArchive})
+	uploadDirectory(t, server, &dir, false)
+	asset := func(dir *ImageDirectory) immichtest.Asset {
+		status, _ := dir.FileStatus(name)
+		a, ok := fake.Asset(status.AssetID)
+		if !ok {
+			t.Fatalf("Expected a.jpg to be uploaded")
+		}
+		return a
+	}
+	if a := asset(&dir); !a.IsFavorite || a.Visibility != oapi.AssetVisibilityArchive {
+		t.Errorf("Expected the options to be applied on upload, got favorite %v and %s", a.IsFavorite, a.Visibility)
+	}
+
+	if err := dir.UpdateUploadOptions(context.Background(), server, UploadOptions{Favorite: true}); err != nil {
+		t.Fatal(err)
+	}
+	if a := asset(&dir); !a.IsFavorite || a.Visibility != oapi.AssetVisibilityTimeline {
+		t.Errorf("Expected the changed visibility to be applied to the uploaded asset, got favorite %v and %s", a.IsFavorite, a.Visibility)
+	}
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	if err := SaveState(statePath, []*ImmichServer{server}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarted with favorite removed from the config
+	restarted := NewImageDirectory(path, false)
+	restarted.SetUploadOptions(UploadOptions{})
+	server.SetDirectories([]*ImageDirectory{&restarted})
+	if err := LoadState(statePath, []*ImmichServer{server}); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.UpdateUploadOptions(context.Background(), server, restarted.UploadOptions()); err != nil {
+		t.Fatal(err)
+	}
+	if a := asset(&restarted); a.IsFavorite {
+		t.Errorf("Expected the option changed while stopped to be applied on start")
+	}
+
+	// A failed update is retried with the next call
+	restarted.SetUploadOptions(UploadOptions{})
+	fake.Close()
+	if err := restarted.UpdateUploadOptions(context.Background(), server, UploadOptions{Favorite: true}); err == nil {
+		t.Fatal("Expected the update to fail with the server stopped")
+	}
+	if applied := restarted.appliedOptions(); applied.Favorite {
+		t.Errorf("Expected the failed change not to count as applied, got %+v", applied)
+	}
+}
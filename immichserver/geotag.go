@@ -0,0 +1,28 @@
+package immichserver
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/JonaEnz/immich-sync/geotag"
+	"github.com/google/uuid"
+)
+
+// Geotag sets the position of an asset to the position of the GPX tracks of tagger at the capture time of its file.
+// It returns false and leaves the asset untouched if the file has no capture time, already has a position
+// or was taken outside of the tracks.
+func (i *ImmichServer) Geotag(ctx context.Context, asset uuid.UUID, path string, tagger *geotag.Tagger) (bool, error) {
+	p, ok, err := tagger.Locate(path)
+	if err != nil {
+		slog.Warn("failed to read GPX tracks", "op", "geotag", "path", path, "err", err)
+	}
+	if !ok {
+		slog.Debug("no position for file in GPX tracks", "op", "geotag", "path", path)
+		return false, nil
+	}
+	if err = i.UpdateAsset(ctx, asset, AssetMetadata{Latitude: &p.Latitude, Longitude: &p.Longitude}); err != nil {
+		return false, err
+	}
+	slog.Info("geotagged file", "op", "geotag", "path", path, "asset_id", asset.String(), "latitude", p.Latitude, "longitude", p.Longitude)
+	return true, nil
+}
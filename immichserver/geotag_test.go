@@ -0,0 +1,57 @@
+package immichserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/geotag"
+	"github.com/JonaEnz/immich-sync/immichtest"
+)
+
+func TestGeotagUploads(t *testing.T) {
+	fake, server := newTestServer(t)
+	path := t.TempDir()
+	tracks := t.TempDir()
+	gpx := `<gpx><trk><trkseg>
+		<trkpt lat="47.0" lon="11.0"><time>2024-07-14T11:00:00Z</time></trkpt>
+		<trkpt lat="47.2" lon="11.2"><time>2024-07-14T11:02:00Z</time></trkpt>
+	</trkseg></trk></gpx>`
+	if err := os.WriteFile(filepath.Join(tracks, "hike.gpx"), []byte(gpx), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		// The camera runs 1 minute behind
+		"hike.jpg":   immichtest.JPEG("2024:07:14 13:00:00", "+02:00", false),
+		"home.jpg":   immichtest.JPEG("2024:07:20 18:00:00", "+02:00", false),
+		"tagged.jpg": immichtest.JPEG("2024:07:14 13:00:00", "+02:00", true),
+		"noexif.jpg": []byte("no exif"),
+	}
+	base := time.Now().Add(-time.Hour)
+	for name, data := range files {
+		file := filepath.Join(path, name)
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(file, base, base)
+	}
+	dir := NewImageDirectory(path, false)
+	dir.SetGeotag(geotag.Config{Tracks: []string{tracks}, Offset: -time.Minute})
+	uploadDirectory(t, server, &dir, false)
+
+	for name := range files {
+		status, _ := dir.FileStatus(filepath.Join(path, name))
+		asset, ok := fake.Asset(status.AssetID)
+		if !ok {
+			t.Fatalf("Expected %s to be uploaded", name)
+		}
+		if name == "hike.jpg" {
+			if asset.Latitude == nil || *asset.Latitude != 47.1 || *asset.Longitude != 11.1 {
+				t.Errorf("Expected hike.jpg at the interpolated position, got %v, %v", asset.Latitude, asset.Longitude)
+			}
+		} else if asset.Latitude != nil {
+			t.Errorf("Expected %s not to be geotagged, got %v", name, *asset.Latitude)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package immichserver
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultHashWorkers is the number of files hashed at once by Read.
+const DefaultHashWorkers = 4
+
+// RateLimiter caps the throughput of all readers sharing it, it is safe for concurrent use.
+type RateLimiter struct {
+	mu   sync.Mutex
+	rate int64 // bytes per second, 0 is unlimited
+	next time.Time
+}
+
+// NewRateLimiter limits to bytesPerSecond, 0 is unlimited.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSecond}
+}
+
+// SetRate changes the limit for all following reads, 0 is unlimited.
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = bytesPerSecond
+}
+
+// wait blocks until n more bytes may be read.
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// maxThrottledRead keeps single reads small, so the limiter spreads them evenly.
+const maxThrottledRead = 256 << 10
+
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+// newThrottledReader returns r itself if limiter is nil.
+func newThrottledReader(ctx context.Context, r io.Reader, limiter *RateLimiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limiter: limiter}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > maxThrottledRead {
+		p = p[:maxThrottledRead]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.limiter.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,127 @@
+package immichserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(1 << 20)
+	data := make([]byte, 300<<10)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, newThrottledReader(context.Background(), bytes.NewReader(data), limiter))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("Expected to read all data, got %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected reading 300KiB at 1MiB/s to take about 300ms, took %s", elapsed)
+	}
+
+	limiter.SetRate(0)
+	start = time.Now()
+	io.Copy(io.Discard, newThrottledReader(context.Background(), bytes.NewReader(data), limiter))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected an unlimited read to be fast, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter.SetRate(1)
+	if _, err := io.Copy(io.Discard, newThrottledReader(ctx, bytes.NewReader(data), limiter)); err == nil {
+		t.Errorf("Expected a cancelled read to fail")
+	}
+}
+
+func TestParallelRead(t *testing.T) {
+	path := t.TempDir()
+	checksums := make(map[string]string)
+	for n := range 50 {
+		name := filepath.Join(path, fmt.Sprintf("sub%d", n%3), fmt.Sprintf("%d.jpg", n))
+		os.MkdirAll(filepath.Dir(name), 0o700)
+		checksums[name] = writeFile(t, name, fmt.Sprint("image ", n), time.Now().Add(-time.Hour))
+	}
+	dir := NewImageDirectory(path, false)
+	dir.SetHashing(8, NewRateLimiter(0))
+	if read, err := dir.Read(context.Background()); err != nil || read != 50 {
+		t.Fatalf("Expected 50 hashed files, got %d, %v", read, err)
+	}
+	for name, checksum := range checksums {
+		if status, _ := dir.FileStatus(name); status.Sha1 != checksum {
+			t.Errorf("Expected %s to have checksum %s, got %s", name, checksum, status.Sha1)
+		}
+	}
+	if read, _ := dir.Read(context.Background()); read != 0 {
+		t.Errorf("Expected unchanged files not to be hashed again, got %d", read)
+	}
+	missing := NewImageDirectory(filepath.Join(path, "missing"), false)
+	if _, err := missing.Read(context.Background()); err == nil {
+		t.Errorf("Expected reading a missing directory to fail")
+	}
+}
+
+func TestFingerprintSurvivesRestart(t *testing.T) {
+	path := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	name := filepath.Join(path, "a.jpg")
+	writeFile(t, name, "aaaa", modTime)
+	dir := NewImageDirectory(path, false)
+	if _, err := dir.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	if err := SaveState(statePath, []*ImmichServer{serverWith("default", &dir)}); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewImageDirectory(path, false)
+	if err := LoadState(statePath, []*ImmichServer{serverWith("default", &restarted)}); err != nil {
+		t.Fatal(err)
+	}
+	if read, _ := restarted.Read(context.Background()); read != 0 {
+		t.Errorf("Expected the saved fingerprint to skip hashing, %d files were hashed", read)
+	}
+
+	// Replaced with a new file of the same size and modification time, e.g. by rsync
+	tmp := filepath.Join(t.TempDir(), "a.jpg")
+	checksum := writeFile(t, tmp, "bbbb", modTime)
+	if err := os.Rename(tmp, name); err != nil {
+		t.Skip("temp dir on another file system:", err)
+	}
+	if read, _ := restarted.Read(context.Background()); read != 1 {
+		t.Errorf("Expected a file with a new inode to be hashed again, %d files were hashed", read)
+	}
+	if status, _ := restarted.FileStatus(name); status.Sha1 != checksum {
+		t.Errorf("Expected the checksum of the new file %s, got %s", checksum, status.Sha1)
+	}
+}
+
+func TestStopAbortsThrottledHash(t *testing.T) {
+	path := t.TempDir()
+	writeFile(t, filepath.Join(path, "video.mp4"), string(make([]byte, 1<<20)), time.Now().Add(-time.Hour))
+	dir := NewImageDirectory(path, false)
+	dir.SetHashing(1, NewRateLimiter(1<<10))
+	read := make(chan error, 1)
+	go func() {
+		_, err := dir.Read(context.Background())
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	dir.Stop()
+	select {
+	case err := <-read:
+		if err == nil {
+			t.Errorf("Expected the aborted scan to fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected stopping the directory to abort hashing")
+	}
+	if status, ok := dir.FileStatus(filepath.Join(path, "video.mp4")); ok {
+		t.Errorf("Expected the aborted file not to be cached, got %+v", status)
+	}
+}
@@ -1,90 +1,322 @@
 package immichserver
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/JonaEnz/immich-sync/fswatch"
+	"github.com/JonaEnz/immich-sync/geotag"
+	"github.com/JonaEnz/immich-sync/schedule"
 	"github.com/google/uuid"
-	"github.com/radovskyb/watcher"
 )
 
+// ImageDirectory tracks the files of a directory and uploads new and changed ones.
+// All methods are safe for concurrent use, mu guards all fields that are changed after creation.
 type ImageDirectory struct {
-	path         string
-	subdir       bool
-	album        *uuid.UUID
+	path    string
+	subdir  bool
+	mu      *sync.RWMutex
+	album   *uuid.UUID
+	stack   StackConfig
+	options UploadOptions
+	// applied are the options of the uploaded assets while a change of options was not applied to them, nil if options
+	applied      *UploadOptions
+	priority     int
+	geotagger    *geotag.Tagger
+	dates        DateCorrection
 	contentCache map[string]FileStat
+	stacks       *stackIndex
 	lastScan     time.Time
+	watching     bool
+	lastErr      string
+	watcher      fswatch.Watcher
+	pollInterval time.Duration
+	quietPeriod  time.Duration
+	tempPatterns []string
+	hashWorkers  int
+	hashLimiter  *RateLimiter
+	keepChanged  *atomic.Bool
+	closing      *atomic.Bool
+	stopped      chan any
+	schedule     schedule.Schedule
+	jitter       time.Duration
+	nextScan     time.Time
+	rescanning   *atomic.Bool
+	rescheduled  chan any
+	liveness     *liveness
+	// checkpoint is set when a scan or a batch of uploads finished, see StateChanged
+	checkpoint *atomic.Bool
+	// inflight counts queued and running uploads, idle is closed when it drops to zero
+	inflight int
+	idle     chan any
 }
 
+// ImageDirectoryConfig is a watch entry of the config file, the tags name its keys.
 type ImageDirectoryConfig struct {
-	Path  string `json:"path"`
-	Album string `json:"album"`
+	Path       string      `json:"path" mapstructure:"path"`
+	Server     string      `json:"server" mapstructure:"server"`
+	Album      string      `json:"album" mapstructure:"album"`
+	Stack      StackConfig `json:"stack" mapstructure:"stack"`
+	Favorite   bool        `json:"favorite" mapstructure:"favorite"`
+	Visibility string      `json:"visibility" mapstructure:"visibility"`
+	// Poll is the interval to poll the directory in instead of using inotify, e.g. for NFS or SMB mounts
+	Poll string `json:"poll" mapstructure:"poll"`
+	// Priority orders the uploads of directories, uploads of directories with a higher priority start first
+	Priority int `json:"priority" mapstructure:"priority"`
+	// Schedule overrides when the directory is rescanned, an interval or a cron expression
+	Schedule string `json:"schedule" mapstructure:"schedule"`
+	// GPX geotags uploaded files without a position from GPS tracks
+	GPX geotag.Config `json:"gpx" mapstructure:"gpx"`
+	// TimeOffset is how far the camera clock is ahead of the real time (5m, -1h), capture times are corrected by it
+	TimeOffset string `json:"time_offset" mapstructure:"time_offset"`
+	// Timezone is the time zone of the camera clock for files that do not store one, a name or an offset (+01:00)
+	Timezone string `json:"timezone" mapstructure:"timezone"`
 }
 
+type FileState string
+
+const (
+	FilePending   FileState = "pending"
+	FileUploading FileState = "uploading"
+	FileUploaded  FileState = "uploaded"
+	FileFailed    FileState = "failed"
+	FileSkipped   FileState = "skipped"
+)
+
 type FileStat struct {
-	info     os.FileInfo
+	modTime  time.Time
+	size     int64
+	inode    uint64
 	hashSha1 []byte
 	uploaded bool
 	updated  bool
 	uuid     uuid.UUID
+	state    FileState
+	lastErr  string
+	// queued is set while an upload of the file is queued or running
+	queued bool
 }
 
 func (f *FileStat) HashHexString() string {
 	return fmt.Sprintf("%x", f.hashSha1)
 }
 
+// DefaultQuietPeriod is how long a file must not change before it is uploaded, unless its writer closed it.
+const DefaultQuietPeriod = 5 * time.Second
+
 func NewImageDirectory(path string, subdir bool) ImageDirectory {
 	return ImageDirectory{
 		path:         path,
 		album:        nil,
 		subdir:       subdir,
+		mu:           &sync.RWMutex{},
 		contentCache: make(map[string]FileStat),
 		lastScan:     time.Time{},
+		keepChanged:  &atomic.Bool{},
+		closing:      &atomic.Bool{},
+		stopped:      make(chan any),
+		rescanning:   &atomic.Bool{},
+		rescheduled:  make(chan any, 1),
+		liveness:     &liveness{},
+		checkpoint:   &atomic.Bool{},
+		stacks:       &stackIndex{},
+		quietPeriod:  DefaultQuietPeriod,
+		tempPatterns: fswatch.DefaultTempPatterns,
+		hashWorkers:  DefaultHashWorkers,
+		idle:         make(chan any),
 	}
 }
 
-func (i *ImageDirectory) StartScan(server *ImmichServer, keepChangedFiles bool) {
-	w := watcher.New()
-	w.FilterOps(watcher.Create, watcher.Write)
-	if err := w.AddRecursive(i.path); err != nil {
-		log.Printf("Failed to start directory watcher for '%s': %s\n", i.path, err)
+// StartScan watches the directory and uploads new or changed files with the pool.
+// Uploads started by the watcher are cancelled when ctx is done.
+func (i *ImageDirectory) StartScan(ctx context.Context, server *ImmichServer, pool *WorkerPool, keepChangedFiles bool) {
+	i.mu.RLock()
+	pollInterval, quietPeriod, tempPatterns := i.pollInterval, i.quietPeriod, i.tempPatterns
+	i.mu.RUnlock()
+	source, err := fswatch.New(i.path, pollInterval)
+	var fallback *fswatch.FallbackError
+	if errors.As(err, &fallback) {
+		slog.Warn("inotify not available, polling directory", "dir", i.path, "interval", fswatch.DefaultPollInterval, "err", fallback.Err)
+	} else if err != nil {
+		slog.Error("failed to start directory watcher", "dir", i.path, "err", err)
+		i.setLastErr(fmt.Sprintf("failed to start directory watcher: %s", err))
 		return
 	}
+	w := fswatch.NewDebouncer(source, quietPeriod, tempPatterns)
+	i.keepChanged.Store(keepChangedFiles)
+	i.mu.Lock()
+	i.watcher = w
+	i.watching = true
+	i.mu.Unlock()
 	go func() {
 		for {
 			select {
-			case event := <-w.Event:
-				switch event.Op {
-				case watcher.Write:
-					fallthrough
-				case watcher.Create:
-					if event.IsDir() {
-						break
-					}
-					if ok, err := i.addOrUpdateCache(event.Path); !ok {
-						log.Printf("Handling file event for '%s' failed: %s\n", event.Path, err)
-						break
-					}
-					i.Upload(server, 1, keepChangedFiles)
-				default:
-					log.Printf("Unknown watcher event: %d\n", event.Op)
+			case event, ok := <-w.Events():
+				if !ok {
+					slog.Info("watcher closed", "dir", i.path)
+					i.mu.Lock()
+					i.watching = false
+					i.mu.Unlock()
+					return
 				}
-			case err := <-w.Error:
-				log.Println(fmt.Errorf("watcher error: %w", err))
-			case <-w.Closed:
-				log.Fatalln("Watcher closed")
+				i.liveness.watcherBusy.Store(stamp())
+				i.handleEvent(ctx, server, pool, event)
+				i.liveness.watcherBusy.Store(0)
+			case err := <-w.Errors():
+				slog.Error("watcher error", "dir", i.path, "err", err)
+				i.setLastErr(fmt.Sprintf("watcher error: %s", err))
 			}
 		}
 	}()
-	w.Start(1 * time.Second)
+}
+
+// handleEvent hashes and uploads the file of a watcher event, or rescans the directory if events were lost.
+func (i *ImageDirectory) handleEvent(ctx context.Context, server *ImmichServer, pool *WorkerPool, event fswatch.Event) {
+	switch event.Op {
+	case fswatch.Create, fswatch.Write, fswatch.Closed:
+		if ok, err := i.addOrUpdateCache(ctx, event.Path); !ok {
+			if err != nil {
+				slog.Warn("handling file event failed", "dir", i.path, "path", event.Path, "op", event.Op.String(), "err", err)
+			}
+			return
+		}
+		i.uploadFiles(ctx, server, pool, JobEvent, []string{event.Path}, i.keepChanged.Load())
+	case fswatch.Overflow:
+		slog.Warn("watcher lost events, rescanning directory", "dir", i.path)
+		if _, err := i.Read(ctx); err != nil {
+			slog.Error("failed to scan directory", "op", "scan", "dir", i.path, "err", err)
+			return
+		}
+		i.Upload(ctx, server, pool, i.keepChanged.Load())
+	}
+}
+
+// SetWriteDetection sets how StartScan detects completely written files: files are uploaded once their
+// size and modification time did not change for the quiet period or a writer closed them.
+// Files matching the temp patterns are skipped until they are renamed.
+func (i *ImageDirectory) SetWriteDetection(quietPeriod time.Duration, tempPatterns []string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.quietPeriod = quietPeriod
+	i.tempPatterns = tempPatterns
+}
+
+// SetHashing sets how many files Read hashes at once and limits the read throughput while hashing,
+// a nil limiter reads as fast as possible. Directories can share a limiter to cap the total throughput.
+func (i *ImageDirectory) SetHashing(workers int, limiter *RateLimiter) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.hashWorkers = max(workers, 1)
+	i.hashLimiter = limiter
+}
+
+// SetPollInterval makes StartScan poll the directory in the interval instead of using inotify,
+// 0 uses inotify if the file system supports it.
+func (i *ImageDirectory) SetPollInterval(interval time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.pollInterval = interval
+}
+
+func (i *ImageDirectory) PollInterval() time.Duration {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.pollInterval
+}
+
+// WatchMode returns "inotify" or "poll" while the directory is watched.
+func (i *ImageDirectory) WatchMode() string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.watchMode()
+}
+
+func (i *ImageDirectory) watchMode() string {
+	if i.watcher == nil || !i.watching {
+		return ""
+	}
+	return i.watcher.Mode()
+}
+
+// SetKeepChangedFiles changes whether uploads started by the watcher keep the old version of changed files.
+func (i *ImageDirectory) SetKeepChangedFiles(keepChangedFiles bool) {
+	i.keepChanged.Store(keepChangedFiles)
+}
+
+// Stop stops the directory watcher and scheduled rescans and prevents new uploads from being started.
+func (i *ImageDirectory) Stop() {
+	if i.closing.CompareAndSwap(false, true) {
+		close(i.stopped)
+	}
+	i.mu.RLock()
+	w := i.watcher
+	i.mu.RUnlock()
+	if w != nil {
+		w.Close()
+	}
+}
+
+// Wait waits until all queued and running uploads of the directory are finished, including stacking.
+func (i *ImageDirectory) Wait(ctx context.Context) error {
+	i.mu.RLock()
+	if i.inflight == 0 {
+		i.mu.RUnlock()
+		return nil
+	}
+	idle := i.idle
+	i.mu.RUnlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitForUploads waits until all queued and running uploads are finished or the timeout expires.
+// It returns false if uploads were still running after the timeout.
+func (i *ImageDirectory) WaitForUploads(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return i.Wait(ctx) == nil
+}
+
+// StateChanged reports whether a scan or a batch of uploads finished since the last call,
+// the state should be saved then.
+func (i *ImageDirectory) StateChanged() bool {
+	return i.checkpoint.Swap(false)
+}
+
+// begin and done count queued and running work for Wait, done must be called once for every begin.
+func (i *ImageDirectory) begin() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.inflight += 1
+}
+
+func (i *ImageDirectory) done() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.inflight -= 1
+	if i.inflight == 0 {
+		i.checkpoint.Store(true)
+		close(i.idle)
+		i.idle = make(chan any)
+	}
 }
 
 func (i *ImageDirectory) Path() string {
@@ -92,6 +324,12 @@ func (i *ImageDirectory) Path() string {
 }
 
 func (i *ImageDirectory) AlbumUUID() string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.albumUUID()
+}
+
+func (i *ImageDirectory) albumUUID() string {
 	if i.album == nil {
 		return ""
 	}
@@ -99,119 +337,630 @@ func (i *ImageDirectory) AlbumUUID() string {
 }
 
 func (i *ImageDirectory) SetAlbum(albumUUID *uuid.UUID) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	i.album = albumUUID
 }
 
+// Priority orders the uploads of the directory in the pool relative to other directories.
+func (i *ImageDirectory) Priority() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.priority
+}
+
+func (i *ImageDirectory) SetPriority(priority int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.priority = priority
+}
+
+func (i *ImageDirectory) DateCorrection() DateCorrection {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.dates
+}
+
+// SetDateCorrection corrects the capture times of files uploaded from now on, see FixDates for uploaded ones.
+func (i *ImageDirectory) SetDateCorrection(dates DateCorrection) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.dates = dates
+}
+
+// GeotagConfig returns the GPX tracks uploaded files are geotagged from.
+func (i *ImageDirectory) GeotagConfig() geotag.Config {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if i.geotagger == nil {
+		return geotag.Config{}
+	}
+	return i.geotagger.Config()
+}
+
+// SetGeotag geotags uploaded files from the GPX tracks of cfg, a config without tracks disables it.
+func (i *ImageDirectory) SetGeotag(cfg geotag.Config) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	switch {
+	case !cfg.Enabled():
+		i.geotagger = nil
+	case i.geotagger == nil || !i.geotagger.Config().Equal(cfg):
+		i.geotagger = geotag.NewTagger(cfg)
+	}
+}
+
+func (i *ImageDirectory) StackConfig() StackConfig {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.stack
+}
+
+func (i *ImageDirectory) SetStackConfig(cfg StackConfig) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.stack = cfg
+}
+
+func (i *ImageDirectory) UploadOptions() UploadOptions {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.options
+}
+
+func (i *ImageDirectory) SetUploadOptions(options UploadOptions) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.options = options
+}
+
+// appliedOptions returns the upload options of the assets already uploaded from this directory.
+func (i *ImageDirectory) appliedOptions() UploadOptions {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if i.applied != nil {
+		return *i.applied
+	}
+	return i.options
+}
+
+// UpdateUploadOptions changes the upload options and applies the change to all assets
+// that were already uploaded from this directory. If that fails, the next call applies it again.
+// Assets linked to identical files in other directories keep their options.
+func (i *ImageDirectory) UpdateUploadOptions(ctx context.Context, server *ImmichServer, options UploadOptions) error {
+	shared := server.assetsOutside(i)
+	i.mu.Lock()
+	old := i.options
+	if i.applied != nil {
+		old = *i.applied
+	}
+	i.options = options
+	assetUUIDs := make([]uuid.UUID, 0)
+	for _, entry := range i.contentCache {
+		if entry.uploaded && !shared[entry.uuid] {
+			assetUUIDs = append(assetUUIDs, entry.uuid)
+		}
+	}
+	i.mu.Unlock()
+	err := server.UpdateAssets(ctx, assetUUIDs, old, options)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err != nil {
+		i.applied = &old
+	} else if i.options == options {
+		i.applied = nil
+	}
+	return err
+}
+
 func (i *ImageDirectory) Count() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	return len(i.contentCache)
 }
 
+// Pending returns the number of files that still need to be uploaded.
+func (i *ImageDirectory) Pending() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	pending := 0
+	for _, entry := range i.contentCache {
+		if entry.needsUpload() {
+			pending += 1
+		}
+	}
+	return pending
+}
+
+func (f *FileStat) needsUpload() bool {
+	return (!f.uploaded || f.updated) && f.state != FileSkipped
+}
+
+// LastScan returns the time the directory was last read completely.
+func (i *ImageDirectory) LastScan() time.Time {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.lastScan
+}
+
+func (i *ImageDirectory) setLastErr(err string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.lastErr = err
+}
+
+// files returns a copy of the file cache.
+func (i *ImageDirectory) files() map[string]FileStat {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return maps.Clone(i.contentCache)
+}
+
+func (i *ImageDirectory) file(filePath string) (FileStat, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	entry, ok := i.contentCache[filePath]
+	return entry, ok
+}
+
+type DirectoryStatus struct {
+	Path      string            `json:"path"`
+	Album     string            `json:"album"`
+	Files     map[FileState]int `json:"files"`
+	Watching  bool              `json:"watching"`
+	WatchMode string            `json:"watchMode,omitempty"`
+	LastScan  time.Time         `json:"lastScan"`
+	Schedule  string            `json:"schedule,omitempty"`
+	NextScan  time.Time         `json:"nextScan"`
+	LastError string            `json:"lastError"`
+}
+
+type FileStatus struct {
+	Path      string    `json:"path"`
+	Directory string    `json:"directory"`
+	State     FileState `json:"state"`
+	AssetID   string    `json:"assetId"`
+	Sha1      string    `json:"sha1"`
+	Size      int64     `json:"size"`
+	Modified  time.Time `json:"modified"`
+	LastError string    `json:"lastError"`
+}
+
+func (i *ImageDirectory) Status() DirectoryStatus {
+	files := map[FileState]int{
+		FilePending:   0,
+		FileUploading: 0,
+		FileUploaded:  0,
+		FileFailed:    0,
+		FileSkipped:   0,
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, entry := range i.contentCache {
+		files[entry.state] += 1
+	}
+	return DirectoryStatus{
+		Path:      i.path,
+		Album:     i.albumUUID(),
+		Files:     files,
+		Watching:  i.watching,
+		WatchMode: i.watchMode(),
+		LastScan:  i.lastScan,
+		Schedule:  scheduleString(i.schedule),
+		NextScan:  i.nextScan,
+		LastError: i.lastErr,
+	}
+}
+
+// FileStatus returns the status of a single tracked file, ok is false if the file is not tracked.
+func (i *ImageDirectory) FileStatus(filePath string) (status FileStatus, ok bool) {
+	i.mu.RLock()
+	entry, ok := i.contentCache[filePath]
+	i.mu.RUnlock()
+	if !ok {
+		return FileStatus{}, false
+	}
+	status = FileStatus{
+		Path:      filePath,
+		Directory: i.path,
+		State:     entry.state,
+		Sha1:      entry.HashHexString(),
+		Size:      entry.size,
+		Modified:  entry.modTime,
+		LastError: entry.lastErr,
+	}
+	if entry.uploaded {
+		status.AssetID = entry.uuid.String()
+	}
+	return status, true
+}
+
+// assetUUID returns the asset of a tracked file, ok is false if the file is not tracked.
+func (i *ImageDirectory) assetUUID(filePath string) (assetUUID uuid.UUID, ok bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	entry, ok := i.contentCache[filePath]
+	return entry.uuid, ok
+}
+
 func (i *ImageDirectory) String() string {
-	return fmt.Sprintf("%s: %d images, last scanned %s", i.path, i.Count(), i.lastScan.Format("Mon Jan 2 15:04:05 MST 2006"))
+	return fmt.Sprintf("%s: %d images, last scanned %s", i.path, i.Count(), i.LastScan().Format("Mon Jan 2 15:04:05 MST 2006"))
 }
 
-func (i *ImageDirectory) Read() (int, error) {
-	updated := 0
+// Read walks the directory and hashes new and changed files with the hash workers.
+// Files whose size, modification time and inode did not change are not hashed again.
+// Reading stops when ctx is done or the directory is stopped.
+func (i *ImageDirectory) Read(ctx context.Context) (int, error) {
+	ctx, cancel := i.hashContext(ctx)
+	defer cancel()
+	i.mu.RLock()
+	tempPatterns, workers := i.tempPatterns, i.hashWorkers
+	i.mu.RUnlock()
+	files := make(chan walkedFile, workers*4)
+	var updated atomic.Int64
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				ok, err := i.updateCache(ctx, f.path, f.info)
+				if ok {
+					updated.Add(1)
+				} else if err != nil && ctx.Err() == nil {
+					slog.Warn("failed to hash file", "op", "scan", "dir", i.path, "path", f.path, "err", err)
+				}
+			}
+		}()
+	}
 	err := filepath.WalkDir(i.path, func(path string, d fs.DirEntry, err error) error {
-		if d.Type().IsRegular() {
-			if ok, _ := i.addOrUpdateCache(path); ok {
-				updated += 1
+		if err != nil {
+			if path == i.path {
+				return err
+			}
+			return nil // Unreadable subdirectory
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.Type().IsRegular() && !fswatch.IsTemporary(path, tempPatterns) {
+			if info, err := d.Info(); err == nil {
+				files <- walkedFile{path: path, info: info}
 			}
 		}
 		return nil
 	})
+	close(files)
+	wg.Wait()
+	if err == nil {
+		// An aborted scan is not complete
+		err = ctx.Err()
+	}
 	if err != nil {
 		return 0, err
 	}
+	i.mu.Lock()
 	i.lastScan = time.Now()
-	return updated, nil
+	i.mu.Unlock()
+	i.checkpoint.Store(true)
+	return int(updated.Load()), nil
 }
 
-func (i *ImageDirectory) addOrUpdateCache(filePath string) (bool, error) {
-	cacheEntry, alreadyExists := i.contentCache[filePath]
-	f, err := os.Open(filePath)
-	if err != nil {
-		return false, err
-	}
-	defer f.Close()
-	fileInfo, err := f.Stat()
+type walkedFile struct {
+	path string
+	info fs.FileInfo
+}
+
+// addOrUpdateCache hashes the file if it is new or changed.
+func (i *ImageDirectory) addOrUpdateCache(ctx context.Context, filePath string) (bool, error) {
+	info, err := os.Stat(filePath)
 	if err != nil {
 		return false, err
 	}
+	ctx, cancel := i.hashContext(ctx)
+	defer cancel()
+	return i.updateCache(ctx, filePath, info)
+}
 
-	if alreadyExists && fileInfo.ModTime().Unix() <= cacheEntry.info.ModTime().Unix() {
+// hashContext returns a context that is also cancelled when the directory is stopped,
+// so a throttled hash of a large file does not delay a shutdown or reload.
+func (i *ImageDirectory) hashContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-i.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// updateCache hashes the file unless its fingerprint (size, modification time and inode) matches
+// the cached one. The lock is not held while hashing, hashing is aborted when ctx is done.
+func (i *ImageDirectory) updateCache(ctx context.Context, filePath string, info fs.FileInfo) (bool, error) {
+	fileInode := inode(info)
+	i.mu.RLock()
+	cacheEntry, alreadyExists := i.contentCache[filePath]
+	limiter := i.hashLimiter
+	i.mu.RUnlock()
+	if alreadyExists && !info.ModTime().After(cacheEntry.modTime) && info.Size() == cacheEntry.size &&
+		(cacheEntry.inode == 0 || cacheEntry.inode == fileInode) {
 		return false, nil // Cache still current
 	}
 
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
 	h := sha1.New()
-	if _, err = io.Copy(h, f); err != nil {
+	if _, err = io.Copy(h, newThrottledReader(ctx, progressReader{f, i.liveness}, limiter)); err != nil {
 		return false, err
 	}
 
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	// Take upload results from the current entry, an upload may have finished while hashing
+	cacheEntry, alreadyExists = i.contentCache[filePath]
 	i.contentCache[filePath] = FileStat{
-		info:     fileInfo,
+		modTime:  info.ModTime(),
+		size:     info.Size(),
+		inode:    fileInode,
 		hashSha1: h.Sum(nil),
 		uploaded: cacheEntry.uploaded,
 		uuid:     cacheEntry.uuid,
 		updated:  alreadyExists,
+		state:    FilePending,
+		queued:   cacheEntry.queued,
 	}
-	log.Printf("%s %x\n", fileInfo.Name(), i.contentCache[filePath].hashSha1)
+	slog.Debug("hashed file", "dir", i.path, "path", filePath, "sha1", fmt.Sprintf("%x", i.contentCache[filePath].hashSha1))
 	return true, nil
 }
 
-func (i *ImageDirectory) Upload(server *ImmichServer, concurrentUploads int, keepChangedFiles bool) {
-	sem := make(chan int, concurrentUploads)
-	mu := sync.Mutex{}
-	copiedCache := i.contentCache
-	for imagePath, entry := range copiedCache {
-		if entry.uploaded && !entry.updated {
+// Upload queues all new and changed files in the pool as backfill and returns, use Wait to wait for the uploads.
+// When ctx is cancelled no further uploads are started, running uploads are aborted
+// and their files stay pending for the next scan.
+func (i *ImageDirectory) Upload(ctx context.Context, server *ImmichServer, pool *WorkerPool, keepChangedFiles bool) {
+	i.mu.RLock()
+	paths := make([]string, 0)
+	for imagePath, entry := range i.contentCache {
+		if entry.needsUpload() && !entry.queued {
+			paths = append(paths, imagePath)
+		}
+	}
+	i.mu.RUnlock()
+	slices.Sort(paths)
+	i.uploadFiles(ctx, server, pool, JobBackfill, paths, keepChangedFiles)
+}
+
+// uploadFiles queues the files in the pool and stacks them once all are uploaded.
+func (i *ImageDirectory) uploadFiles(ctx context.Context, server *ImmichServer, pool *WorkerPool, class JobClass, paths []string, keepChangedFiles bool) {
+	if len(paths) == 0 {
+		return
+	}
+	// The batch holds one count for Wait until the uploads are stacked
+	i.begin()
+	var mu sync.Mutex
+	remaining := len(paths)
+	uploaded := make(map[string]bool)
+	finish := func(imagePath string, ok bool) {
+		i.liveness.progressed()
+		mu.Lock()
+		remaining -= 1
+		if ok {
+			uploaded[imagePath] = true
+		}
+		last := remaining == 0
+		mu.Unlock()
+		if !last {
+			return
+		}
+		if i.StackConfig().Enabled() && len(uploaded) > 0 && ctx.Err() == nil {
+			i.updateStacks(ctx, server, uploaded)
+		}
+		i.done()
+	}
+	for _, imagePath := range paths {
+		if !i.markQueued(imagePath) {
+			finish(imagePath, false)
 			continue
 		}
-		h := entry.HashHexString()
-		sem <- 1
-		go func(imagePath, h string, mu *sync.Mutex) {
-			rawUUID, err := server.Upload(imagePath, &h)
-			if err != nil {
-				log.Printf("Failed to upload image at '%s' to server: %s\n", imagePath, err.Error())
-				<-sem
-				return
+		i.begin()
+		submitted := pool.Submit(class, i.Priority(), func() {
+			defer i.done()
+			finish(imagePath, i.uploadFile(ctx, server, pool, imagePath, keepChangedFiles))
+		})
+		if !submitted {
+			i.unmarkQueued(imagePath)
+			finish(imagePath, false)
+			i.done()
+		}
+	}
+}
+
+// markQueued marks a file as queued, it returns false if it needs no upload or is already queued.
+func (i *ImageDirectory) markQueued(imagePath string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	entry, ok := i.contentCache[imagePath]
+	if !ok || entry.queued || !entry.needsUpload() {
+		return false
+	}
+	entry.queued = true
+	i.contentCache[imagePath] = entry
+	return true
+}
+
+func (i *ImageDirectory) unmarkQueued(imagePath string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if entry, ok := i.contentCache[imagePath]; ok {
+		entry.queued = false
+		i.contentCache[imagePath] = entry
+	}
+}
+
+// setState changes the state of a file and records the error.
+func (i *ImageDirectory) setState(imagePath string, state FileState, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	entry, ok := i.contentCache[imagePath]
+	if !ok {
+		return
+	}
+	entry.state = state
+	entry.queued = state == FileUploading
+	if err != nil {
+		entry.lastErr = err.Error()
+		i.lastErr = fmt.Sprintf("%s: %s", imagePath, err)
+	}
+	i.contentCache[imagePath] = entry
+}
+
+// uploadFile uploads the current version of a file, replacing the asset of its previous version.
+// It returns true if the file was uploaded.
+func (i *ImageDirectory) uploadFile(ctx context.Context, server *ImmichServer, pool *WorkerPool, imagePath string, keepChangedFiles bool) bool {
+	i.mu.Lock()
+	entry, ok := i.contentCache[imagePath]
+	if !ok || !entry.needsUpload() || i.closing.Load() || ctx.Err() != nil {
+		if ok {
+			entry.queued = false
+			i.contentCache[imagePath] = entry
+		}
+		i.mu.Unlock()
+		return false
+	}
+	entry.state = FileUploading
+	i.contentCache[imagePath] = entry
+	options, album, tagger, dates := i.options, i.album, i.geotagger, i.dates
+	i.mu.Unlock()
+
+	var createdAt time.Time
+	if dates.Enabled() {
+		var err error
+		if createdAt, _, err = dates.CaptureTime(imagePath); err != nil {
+			slog.Warn("failed to read capture time", "op", "dates", "dir", i.path, "path", imagePath, "err", err)
+		}
+	}
+
+	h := entry.HashHexString()
+	u, deduped, err := server.uploadOnce(ctx, h, func() (uuid.UUID, error) {
+		rawUUID, err := server.Upload(ctx, imagePath, &h, createdAt, options)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+		u, err := uuid.Parse(rawUUID)
+		if err != nil {
+			return uuid.UUID{}, fmt.Errorf("server returned invalid asset id '%s': %w", rawUUID, err)
+		}
+		return u, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		slog.Info("upload cancelled", "op", "upload", "dir", i.path, "path", imagePath)
+		i.setState(imagePath, FilePending, nil)
+		return false
+	}
+	if errors.Is(err, ErrUnsupportedFile) {
+		slog.Warn("skipping unsupported file", "op", "upload", "dir", i.path, "path", imagePath, "err", err)
+		i.setState(imagePath, FileSkipped, err)
+		return false
+	}
+	if err != nil {
+		slog.Error("failed to upload file", "op", "upload", "dir", i.path, "path", imagePath, "err", err)
+		i.setState(imagePath, FileFailed, err)
+		return false
+	}
+	if deduped {
+		// The asset keeps the options, capture time and position of the directory that uploaded it
+		slog.Info("linked file to the asset of an identical file", "op", "upload", "dir", i.path, "path", imagePath, "asset_id", u.String())
+	} else {
+		slog.Info("uploaded file", "op", "upload", "dir", i.path, "path", imagePath, "asset_id", u.String())
+	}
+	if entry.uploaded && entry.updated && entry.uuid != u {
+		if err = server.CopyMetadata(ctx, entry.uuid, u); err != nil {
+			slog.Warn("failed to copy metadata to new asset", "op", "copy", "dir", i.path, "path", imagePath, "asset_id", u.String(), "err", err)
+			if !strings.Contains(err.Error(), "version error:") {
+				i.setState(imagePath, FileFailed, err)
+				return false
 			}
-			u, err := uuid.Parse(rawUUID)
+		}
+		// Identical files elsewhere may still be linked to the old asset
+		if !keepChangedFiles && !server.assetInUse(entry.uuid, imagePath) {
+			err = server.Delete(ctx, entry.uuid)
 			if err != nil {
-				<-sem
-				return
+				slog.Error("failed to delete old version of file", "op", "delete", "dir", i.path, "path", imagePath, "asset_id", entry.uuid.String(), "err", err)
+			} else {
+				server.forgetAsset(entry.uuid)
 			}
-			if entry.uploaded && entry.updated {
-				if err = server.CopyMetadata(entry.uuid, u); err != nil {
-					log.Printf("Failed to copy metadata to new asset: %v", err)
-					if !strings.Contains(err.Error(), "version error:") {
-						<-sem
-						return
-					}
-				}
-				if !keepChangedFiles {
-					err = server.Delete(entry.uuid)
-					if err != nil {
-						log.Printf("Error deleting old version of image: %s\n", err)
-					}
+		}
+	}
 
-				}
+	i.mu.Lock()
+	current := i.contentCache[imagePath]
+	current.uuid = u
+	current.uploaded = true
+	current.queued = false
+	current.lastErr = ""
+	changed := !bytes.Equal(current.hashSha1, entry.hashSha1)
+	if changed {
+		// The file changed during the upload, the new version replaces the asset just uploaded
+		current.updated = true
+		current.state = FilePending
+	} else {
+		current.updated = false
+		current.state = FileUploaded
+	}
+	i.contentCache[imagePath] = current
+	i.mu.Unlock()
+	if changed {
+		i.uploadFiles(ctx, server, pool, JobEvent, []string{imagePath}, keepChangedFiles)
+	} else if !deduped {
+		// A linked asset keeps the capture time and position set by the directory that uploaded it
+		if !createdAt.IsZero() {
+			if err = server.SetCaptureTime(ctx, []uuid.UUID{u}, createdAt, dates); err != nil {
+				slog.Error("uploaded file, but could not correct its capture time", "op", "dates", "dir", i.path, "path", imagePath, "asset_id", u.String(), "err", err)
+				i.setLastErr(fmt.Sprintf("%s: could not correct capture time: %s", imagePath, err))
 			}
-			entry.uuid = u
-			entry.uploaded = true
-			entry.updated = false
-			mu.Lock()
-			i.contentCache[imagePath] = entry
-			mu.Unlock()
-			if i.album != nil {
-				err = server.AddToAlbum([]uuid.UUID{entry.uuid}, *i.album)
+		}
+		if tagger != nil {
+			if _, err = server.Geotag(ctx, u, imagePath, tagger); err != nil {
+				slog.Error("uploaded file, but could not geotag it", "op", "geotag", "dir", i.path, "path", imagePath, "asset_id", u.String(), "err", err)
+				i.setLastErr(fmt.Sprintf("%s: could not geotag: %s", imagePath, err))
 			}
-			if err != nil {
-				log.Printf("Uploaded image at '%s' to server, but could not add to album '%s': %s\n", imagePath, (*i.album).String(), err.Error())
-				<-sem
-				return
+		}
+	}
+
+	if album != nil {
+		if err = server.AddToAlbum(ctx, []uuid.UUID{u}, *album); err != nil {
+			slog.Error("uploaded file, but could not add it to album", "op", "album", "dir", i.path, "path", imagePath, "asset_id", u.String(), "album_id", album.String(), "err", err)
+			i.setLastErr(fmt.Sprintf("%s: could not add to album: %s", imagePath, err))
+		}
+	}
+	return true
+}
+
+// updateStacks (re)creates the stacks of all groups with at least one member in changed.
+// Only the changed files and their neighbours in the stack index are grouped, not the whole directory.
+// Stacking a re-uploaded asset together with its siblings keeps an existing stack intact,
+// Immich merges the assets into a single stack.
+func (i *ImageDirectory) updateStacks(ctx context.Context, server *ImmichServer, changed map[string]bool) {
+	cfg := i.StackConfig()
+	for _, group := range stackGroups(i.stackCandidates(changed, cfg), cfg) {
+		if !slices.ContainsFunc(group, func(p string) bool { return changed[p] }) {
+			continue
+		}
+		assetUUIDs := make([]uuid.UUID, 0, len(group))
+		for _, p := range group {
+			if entry, _ := i.file(p); entry.uploaded {
+				assetUUIDs = append(assetUUIDs, entry.uuid)
 			}
-			<-sem
-		}(imagePath, h, &mu)
+		}
+		if len(assetUUIDs) < 2 {
+			continue
+		}
+		if _, err := server.CreateStack(ctx, assetUUIDs); err != nil {
+			slog.Error("failed to stack files", "op", "stack", "dir", i.path, "path", group[0], "err", err)
+		}
 	}
 }
@@ -7,27 +7,79 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime"
 	"net/textproto"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/JonaEnz/immich-sync/oapi"
 	"github.com/google/uuid"
 	"github.com/ogen-go/ogen/http"
 	"github.com/ogen-go/ogen/ogenerrors"
+	"go.opentelemetry.io/otel"
 )
 
 type ImmichServer struct {
-	apiURL       string
-	apiKey       string
-	deviceID     string
-	oapiClient   *oapi.Client
-	ImageDirs    []*ImageDirectory
-	albumCache   ImmichAlbumCache
-	versionCache ImmichServerVersion
+	Profile    string
+	apiURL     string
+	deviceID   string
+	oapiClient *oapi.Client
+	dirsMu     sync.RWMutex
+	imageDirs  []*ImageDirectory
+	assets     assetIndex
+	albumCache ImmichAlbumCache
+	metrics    immichMetrics
+	timeouts   Timeouts
+}
+
+// Timeouts limits how long a single request to the server may take. A zero duration means no limit.
+type Timeouts struct {
+	API      time.Duration `json:"api" mapstructure:"api"`
+	Upload   time.Duration `json:"upload" mapstructure:"upload"`
+	Download time.Duration `json:"download" mapstructure:"download"`
+}
+
+// withTimeout derives a context that is cancelled after d, or never if d is zero.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+type ServerConfig struct {
+	Server     string          `json:"server" mapstructure:"server"`
+	APIKey     string          `json:"apikey" mapstructure:"apikey"`
+	APIKeyFile string          `json:"apikey_file" mapstructure:"apikey_file"`
+	DeviceID   string          `json:"deviceid" mapstructure:"deviceid"`
+	Transport  TransportConfig `json:"transport" mapstructure:"transport"`
+}
+
+var ErrUnsupportedFile = errors.New("unsupported file type")
+
+// ErrAlbumNotFound is returned when the server has no album of a name, as opposed to failing to list the albums.
+var ErrAlbumNotFound = errors.New("album does not exist")
+
+type UploadOptions struct {
+	Favorite   bool
+	Visibility oapi.AssetVisibility
+}
+
+func ParseVisibility(visibility string) (oapi.AssetVisibility, error) {
+	if visibility == "" {
+		return "", nil
+	}
+	v := oapi.AssetVisibility(visibility)
+	if err := v.Validate(); err != nil {
+		return "", fmt.Errorf("invalid visibility '%s', expected one of %v", visibility, v.AllValues())
+	}
+	return v, nil
 }
 
 type ImmichServerVersion struct {
@@ -36,35 +88,84 @@ type ImmichServerVersion struct {
 	patch int
 }
 
-func NewImmichServer(apiKey, serverURL, deviceID string) *ImmichServer {
-	client, _ := oapi.NewClient(serverURL, &ImmichServerSecuritySource{
-		key: oapi.APIKey{
-			APIKey: apiKey,
-		},
-	})
+// NewImmichServer creates the client for a server, options like oapi.WithClient are passed to the generated client.
+// It fails if the metrics of the server cannot be registered on the global meter provider.
+func NewImmichServer(security *ImmichServerSecuritySource, serverURL, deviceID string, opts ...oapi.ClientOption) (*ImmichServer, error) {
+	opts = append([]oapi.ClientOption{
+		oapi.WithMeterProvider(otel.GetMeterProvider()),
+		oapi.WithTracerProvider(otel.GetTracerProvider()),
+	}, opts...)
+	client, err := oapi.NewClient(serverURL, security, opts...)
+	if err != nil {
+		return nil, err
+	}
 
 	server := ImmichServer{
-		apiURL:       serverURL,
-		apiKey:       apiKey,
-		deviceID:     deviceID,
-		oapiClient:   client,
-		albumCache:   NewImmichAlbumCache(),
-		versionCache: ImmichServerVersion{},
+		apiURL:     serverURL,
+		deviceID:   deviceID,
+		oapiClient: client,
+		albumCache: NewImmichAlbumCache(),
+	}
+	if server.metrics, err = newImmichMetrics(&server); err != nil {
+		return nil, fmt.Errorf("failed to register metrics: %w", err)
+	}
+	return &server, nil
+}
+
+// Directories returns the watched directories of the server.
+func (i *ImmichServer) Directories() []*ImageDirectory {
+	i.dirsMu.RLock()
+	defer i.dirsMu.RUnlock()
+	return slices.Clone(i.imageDirs)
+}
+
+// AddDirectory watches another directory, its uploaded files are linked to identical files uploaded later.
+func (i *ImmichServer) AddDirectory(dir *ImageDirectory) {
+	i.dirsMu.Lock()
+	i.imageDirs = append(i.imageDirs, dir)
+	i.dirsMu.Unlock()
+	// Not under dirsMu, uploadOnce locks the index before the directories
+	i.assets.index(dir)
+}
+
+// RemoveDirectory removes the directory with the path and returns it, nil if it is not watched.
+func (i *ImmichServer) RemoveDirectory(path string) *ImageDirectory {
+	i.dirsMu.Lock()
+	defer i.dirsMu.Unlock()
+	j := slices.IndexFunc(i.imageDirs, func(d *ImageDirectory) bool { return d.Path() == path })
+	if j < 0 {
+		return nil
 	}
-	return &server
+	dir := i.imageDirs[j]
+	i.imageDirs = slices.Delete(i.imageDirs, j, j+1)
+	return dir
+}
+
+// SetDirectories replaces the watched directories of the server.
+func (i *ImmichServer) SetDirectories(dirs []*ImageDirectory) {
+	i.dirsMu.Lock()
+	i.imageDirs = dirs
+	i.dirsMu.Unlock()
+	i.assets.index(dirs...)
+}
+
+// SetTimeouts changes the per request timeouts used for all following requests.
+func (i *ImmichServer) SetTimeouts(timeouts Timeouts) {
+	i.timeouts = timeouts
 }
 
-func (i *ImmichServer) Version() (ImmichServerVersion, error) {
-	response, err := i.oapiClient.GetServerVersion(context.Background())
+func (i *ImmichServer) Version(ctx context.Context) (ImmichServerVersion, error) {
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	response, err := i.oapiClient.GetServerVersion(ctx)
 	if err != nil {
 		return ImmichServerVersion{}, err
 	}
-	i.versionCache = ImmichServerVersion{response.Major, response.Minor, response.Patch}
-	return i.versionCache, nil
+	return ImmichServerVersion{response.Major, response.Minor, response.Patch}, nil
 }
 
-func (i *ImmichServer) MinVersionCheck(min ImmichServerVersion) error {
-	version, err := i.Version()
+func (i *ImmichServer) MinVersionCheck(ctx context.Context, min ImmichServerVersion) error {
+	version, err := i.Version(ctx)
 	if err != nil {
 		return fmt.Errorf("version error: server version could not be determined: %w", err)
 	}
@@ -74,38 +175,125 @@ func (i *ImmichServer) MinVersionCheck(min ImmichServerVersion) error {
 	return nil
 }
 
+// IsMinimumVersion reports whether v is min or a later version.
 func (v *ImmichServerVersion) IsMinimumVersion(min ImmichServerVersion) bool {
-	if v.major > min.major {
-		return true
+	if v.major != min.major {
+		return v.major > min.major
 	}
-	if v.minor > min.minor {
-		return true
+	if v.minor != min.minor {
+		return v.minor > min.minor
 	}
-	return v.minor == min.minor && v.patch >= min.patch
+	return v.patch >= min.patch
+}
+
+type ServerStatus struct {
+	Profile     string            `json:"profile"`
+	Server      string            `json:"server"`
+	Version     string            `json:"version"`
+	Online      bool              `json:"online"`
+	Directories []DirectoryStatus `json:"directories"`
+}
+
+func (v ImmichServerVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
 }
 
-func (i *ImmichServer) GetAlbumByUUIDOrName(uuidOrName string) (uuid.UUID, error) {
+// RequiredPermissions are the API key permissions used for syncing.
+var RequiredPermissions = []oapi.Permission{
+	oapi.PermissionAssetRead,
+	oapi.PermissionAssetUpload,
+	oapi.PermissionAssetUpdate,
+	oapi.PermissionAssetDelete,
+	oapi.PermissionAssetCopy,
+	oapi.PermissionAssetDownload,
+	oapi.PermissionAlbumRead,
+	oapi.PermissionAlbumCreate,
+	oapi.PermissionAlbumAssetCreate,
+	oapi.PermissionStackCreate,
+	oapi.PermissionUserRead,
+}
+
+// URL returns the API url of the server.
+func (i *ImmichServer) URL() string {
+	return i.apiURL
+}
+
+func (i *ImmichServer) Ping(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	_, err := i.oapiClient.PingServer(ctx)
+	return err
+}
+
+// MissingPermissions returns the RequiredPermissions the API key does not have.
+func (i *ImmichServer) MissingPermissions(ctx context.Context) ([]oapi.Permission, error) {
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	key, err := i.oapiClient.GetMyApiKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(key.Permissions, oapi.PermissionAll) {
+		return nil, nil
+	}
+	missing := []oapi.Permission{}
+	for _, permission := range RequiredPermissions {
+		if !slices.Contains(key.Permissions, permission) {
+			missing = append(missing, permission)
+		}
+	}
+	return missing, nil
+}
+
+// StatusTimeout bounds the online check of Status, so a slow or unreachable server is reported
+// as offline before clients of the status stop waiting.
+var StatusTimeout = 5 * time.Second
+
+// Status returns the state of the server and its directories. The server is offline if it does not
+// answer within StatusTimeout.
+func (i *ImmichServer) Status(ctx context.Context) ServerStatus {
+	status := ServerStatus{
+		Profile: i.Profile,
+		Server:  i.apiURL,
+	}
+	dirs := i.Directories()
+	status.Directories = make([]DirectoryStatus, 0, len(dirs))
+	ctx, cancel := context.WithTimeout(ctx, StatusTimeout)
+	defer cancel()
+	if version, err := i.Version(ctx); err == nil {
+		status.Online = true
+		status.Version = version.String()
+	}
+	for _, dir := range dirs {
+		status.Directories = append(status.Directories, dir.Status())
+	}
+	return status
+}
+
+func (i *ImmichServer) GetAlbumByUUIDOrName(ctx context.Context, uuidOrName string) (uuid.UUID, error) {
 	u, err := uuid.Parse(uuidOrName)
 	if err == nil {
 		return u, nil
 	}
-	return i.albumCache.GetAlbumUUIDByName(i, uuidOrName)
+	return i.albumCache.GetAlbumUUIDByName(ctx, i, uuidOrName)
 }
 
-func (i *ImmichServer) Album(albumUUID uuid.UUID) (*oapi.AlbumResponseDto, error) {
-	return i.albumCache.Album(i, albumUUID)
+func (i *ImmichServer) Album(ctx context.Context, albumUUID uuid.UUID) (*oapi.AlbumResponseDto, error) {
+	return i.albumCache.Album(ctx, i, albumUUID)
 }
 
-func (i *ImmichServer) CreateNewAlbum(name string) (uuid.UUID, error) {
-	i.albumCache.FillCache(i)
-
-	for _, album := range i.albumCache.cache {
-		if album.AlbumName == name {
-			return uuid.UUID{}, errors.New("an album with this name already exists")
-		}
+func (i *ImmichServer) CreateNewAlbum(ctx context.Context, name string) (uuid.UUID, error) {
+	// Without the current albums an existing album could be created again
+	if err := i.albumCache.FillCache(ctx, i); err != nil {
+		return uuid.UUID{}, fmt.Errorf("failed to list albums: %w", err)
+	}
+	if _, ok := i.albumCache.byName(name); ok {
+		return uuid.UUID{}, errors.New("an album with this name already exists")
 	}
 
-	response, err := i.oapiClient.CreateAlbum(context.Background(), &oapi.CreateAlbumDto{
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	response, err := i.oapiClient.CreateAlbum(ctx, &oapi.CreateAlbumDto{
 		AlbumName:   name,
 		AlbumUsers:  make([]oapi.AlbumUserCreateDto, 0),
 		AssetIds:    make([]uuid.UUID, 0),
@@ -118,46 +306,55 @@ func (i *ImmichServer) CreateNewAlbum(name string) (uuid.UUID, error) {
 	if err != nil {
 		return uuid.UUID{}, err
 	}
-	i.albumCache.cache[name] = response
+	i.albumCache.set(response)
 	return albumUUID, nil
 }
 
-func (i *ImmichServer) AddToAlbum(imageUUIDs []uuid.UUID, albumUUID uuid.UUID) error {
-	response, err := i.oapiClient.AddAssetsToAlbum(context.Background(), &oapi.BulkIdsDto{Ids: imageUUIDs}, oapi.AddAssetsToAlbumParams{
+func (i *ImmichServer) AddToAlbum(ctx context.Context, imageUUIDs []uuid.UUID, albumUUID uuid.UUID) error {
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	response, err := i.oapiClient.AddAssetsToAlbum(ctx, &oapi.BulkIdsDto{Ids: imageUUIDs}, oapi.AddAssetsToAlbumParams{
 		ID: albumUUID,
 	})
 	if err != nil {
 		return err
 	}
+	// The cached album no longer lists all of its assets
+	i.albumCache.remove(albumUUID.String())
 	for _, r := range response {
-		if !r.Success {
+		// Adding an asset that is already in the album changes nothing
+		if !r.Success && r.Error.Value != oapi.BulkIdResponseDtoErrorDuplicate {
 			return fmt.Errorf("Image '%s' failed with error '%s'", r.ID, r.Error.Value)
 		}
 	}
 	return nil
 }
 
-func (i *ImmichServer) GetUserUUID() (uuid.UUID, error) {
-	resp, err := i.oapiClient.GetMyUser(context.Background())
+func (i *ImmichServer) GetUserUUID(ctx context.Context) (uuid.UUID, error) {
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	resp, err := i.oapiClient.GetMyUser(ctx)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
 	return uuid.Parse(resp.ID)
 }
 
-func (i *ImmichServer) GetSyncAfter(t time.Time) (*oapi.AssetDeltaSyncResponseDto, error) {
-	userUUID, err := i.GetUserUUID()
+func (i *ImmichServer) GetSyncAfter(ctx context.Context, t time.Time) (*oapi.AssetDeltaSyncResponseDto, error) {
+	userUUID, err := i.GetUserUUID(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return i.oapiClient.GetDeltaSync(context.Background(), &oapi.AssetDeltaSyncDto{
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	return i.oapiClient.GetDeltaSync(ctx, &oapi.AssetDeltaSyncDto{
 		UpdatedAfter: t,
 		UserIds:      []uuid.UUID{userUUID},
 	})
 }
 
-func (i *ImmichServer) DoFullSync(t time.Time) (*[]oapi.AssetResponseDto, error) {
-	userUUID, err := i.GetUserUUID()
+func (i *ImmichServer) DoFullSync(ctx context.Context, t time.Time) (*[]oapi.AssetResponseDto, error) {
+	userUUID, err := i.GetUserUUID(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -168,17 +365,19 @@ func (i *ImmichServer) DoFullSync(t time.Time) (*[]oapi.AssetResponseDto, error)
 
 	getMore := true
 	for getMore {
-		newAssets, err := i.oapiClient.GetFullSyncForUser(context.Background(), &oapi.AssetFullSyncDto{
+		pageCtx, cancel := withTimeout(ctx, i.timeouts.API)
+		newAssets, err := i.oapiClient.GetFullSyncForUser(pageCtx, &oapi.AssetFullSyncDto{
 			LastId:       ouuid,
 			Limit:        100,
 			UpdatedUntil: t,
 			UserId:       oapi.NewOptUUID(userUUID),
 		})
+		cancel()
 		if err != nil {
 			return nil, err
 		}
 		assets = append(assets, newAssets...)
-		getMore = len(newAssets) < 100
+		getMore = len(newAssets) == 100
 		if len(newAssets) == 0 {
 			break
 		}
@@ -189,21 +388,37 @@ func (i *ImmichServer) DoFullSync(t time.Time) (*[]oapi.AssetResponseDto, error)
 }
 
 func (i *ImmichServer) GetImageUUIDByPath(path string) (uuid.UUID, error) {
-	for j := range i.ImageDirs {
-		if cache, ok := i.ImageDirs[j].contentCache[path]; ok {
-			return cache.uuid, nil
+	for _, dir := range i.Directories() {
+		if assetUUID, ok := dir.assetUUID(path); ok {
+			return assetUUID, nil
 		}
 	}
 
 	return uuid.UUID{}, errors.New("path is not in the watched directories")
 }
 
-func (i *ImmichServer) Upload(path string, assetSha1 *string) (string, error) {
+// mediaType returns the media type sent for the file, unsupported files return ErrUnsupportedFile.
+func mediaType(path string) (string, error) {
+	mimename, _, err := mime.ParseMediaType(filepath.Ext(path))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnsupportedFile, err)
+	}
+	return mimename, nil
+}
+
+// Upload uploads a file, createdAt is its creation time sent to the server, the current time if zero.
+func (i *ImmichServer) Upload(ctx context.Context, path string, assetSha1 *string, createdAt time.Time, options UploadOptions) (id string, err error) {
+	var size int64
+	defer func(start time.Time) {
+		i.metrics.recordUpload(start, size, err)
+	}(time.Now())
+
 	var r io.Reader
 	file, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
+	defer file.Close()
 	r = file
 
 	if assetSha1 == nil {
@@ -222,26 +437,55 @@ func (i *ImmichServer) Upload(path string, assetSha1 *string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	mimename, _, err := mime.ParseMediaType(filepath.Ext(path))
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	id, err = i.upload(ctx, file.Name(), r, fileInfo.Size(), *assetSha1, createdAt, options)
+	if err == nil {
+		size = fileInfo.Size()
+	}
+	return id, err
+}
+
+// UploadReader uploads size bytes of r as a file with the name and the sha1 checksum (hex) of the content.
+// createdAt is the creation time of the file sent to the server, used for the timeline if the file has no EXIF date.
+func (i *ImmichServer) UploadReader(ctx context.Context, name string, r io.Reader, size int64, assetSha1 string, createdAt time.Time, options UploadOptions) (id string, err error) {
+	defer func(start time.Time) {
+		i.metrics.recordUpload(start, size, err)
+	}(time.Now())
+	return i.upload(ctx, name, r, size, assetSha1, createdAt, options)
+}
+
+func (i *ImmichServer) upload(ctx context.Context, name string, r io.Reader, size int64, assetSha1 string, createdAt time.Time, options UploadOptions) (string, error) {
+	mimename, err := mediaType(name)
 	if err != nil {
 		return "", err
 	}
 	mimetype := textproto.MIMEHeader{}
 	mimetype.Set("Content-Type", mimename)
-	response, err := i.oapiClient.UploadAsset(context.Background(), &oapi.AssetMediaCreateDtoMultipart{
+	request := &oapi.AssetMediaCreateDtoMultipart{
 		AssetData: http.MultipartFile{
-			Name:   file.Name(),
+			Name:   name,
 			File:   r,
-			Size:   fileInfo.Size(),
+			Size:   size,
 			Header: mimetype,
 		},
-		DeviceAssetId:  i.deviceID + *assetSha1,
+		DeviceAssetId:  i.deviceID + assetSha1,
 		DeviceId:       i.deviceID,
-		FileCreatedAt:  time.Now(),
-		FileModifiedAt: time.Now(),
-	},
+		FileCreatedAt:  createdAt,
+		FileModifiedAt: createdAt,
+	}
+	if options.Favorite {
+		request.IsFavorite = oapi.NewOptBool(true)
+	}
+	if options.Visibility != "" {
+		request.Visibility = oapi.NewOptAssetVisibility(options.Visibility)
+	}
+	uploadCtx, cancel := withTimeout(ctx, i.timeouts.Upload)
+	defer cancel()
+	response, err := i.oapiClient.UploadAsset(uploadCtx, request,
 		oapi.UploadAssetParams{
-			XImmichChecksum: oapi.NewOptString(*assetSha1),
+			XImmichChecksum: oapi.NewOptString(assetSha1),
 		})
 	if err != nil {
 		return "", err
@@ -250,23 +494,84 @@ func (i *ImmichServer) Upload(path string, assetSha1 *string) (string, error) {
 		return r.ID, nil
 	}
 	if r, ok := response.(*oapi.UploadAssetOK); ok {
+		// Duplicate, the asset already exists and did not get the options on creation
+		if options != (UploadOptions{}) {
+			if u, err := uuid.Parse(r.ID); err == nil {
+				err = i.UpdateAssets(ctx, []uuid.UUID{u}, UploadOptions{}, options)
+				if err != nil {
+					return r.ID, fmt.Errorf("could not apply upload options to existing asset: %w", err)
+				}
+			}
+		}
 		return r.ID, nil
 	}
 	return "", err
 }
 
-func (i *ImmichServer) Delete(assetUUID uuid.UUID) error {
-	return i.oapiClient.DeleteAssets(context.Background(), &oapi.AssetBulkDeleteDto{
+// UpdateAssets applies the difference between the previous and new upload options to existing assets.
+// Options that were removed are reset (not favorite, visible in timeline).
+func (i *ImmichServer) UpdateAssets(ctx context.Context, assetUUIDs []uuid.UUID, previous, options UploadOptions) error {
+	if len(assetUUIDs) == 0 || previous == options {
+		return nil
+	}
+	request := &oapi.AssetBulkUpdateDto{Ids: assetUUIDs}
+	if previous.Favorite != options.Favorite {
+		request.IsFavorite = oapi.NewOptBool(options.Favorite)
+	}
+	if previous.Visibility != options.Visibility {
+		visibility := options.Visibility
+		if visibility == "" {
+			visibility = oapi.AssetVisibilityTimeline
+		}
+		request.Visibility = oapi.NewOptAssetVisibility(visibility)
+	}
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	return i.oapiClient.UpdateAssets(ctx, request)
+}
+
+// AssetMetadata are changes to the metadata of an asset, nil fields are not changed.
+type AssetMetadata struct {
+	Description *string
+	Latitude    *float64
+	Longitude   *float64
+}
+
+// UpdateAsset changes the metadata of a single asset.
+func (i *ImmichServer) UpdateAsset(ctx context.Context, assetUUID uuid.UUID, metadata AssetMetadata) error {
+	request := &oapi.UpdateAssetDto{}
+	if metadata.Description != nil {
+		request.Description = oapi.NewOptString(*metadata.Description)
+	}
+	if metadata.Latitude != nil && metadata.Longitude != nil {
+		request.Latitude = oapi.NewOptFloat64(*metadata.Latitude)
+		request.Longitude = oapi.NewOptFloat64(*metadata.Longitude)
+	}
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	_, err := i.oapiClient.UpdateAsset(ctx, request, oapi.UpdateAssetParams{ID: assetUUID})
+	return err
+}
+
+func (i *ImmichServer) Delete(ctx context.Context, assetUUID uuid.UUID) error {
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	return i.oapiClient.DeleteAssets(ctx, &oapi.AssetBulkDeleteDto{
 		Force: oapi.NewOptBool(false),
 		Ids:   []uuid.UUID{assetUUID},
 	})
 }
 
-func (i *ImmichServer) CopyMetadata(oldAsset uuid.UUID, newAsset uuid.UUID) error {
-	if err := i.MinVersionCheck(ImmichServerVersion{2, 2, 0}); err != nil {
+// MinCopyMetadataVersion is the first Immich version that can copy metadata to a replaced asset.
+var MinCopyMetadataVersion = ImmichServerVersion{2, 2, 0}
+
+func (i *ImmichServer) CopyMetadata(ctx context.Context, oldAsset uuid.UUID, newAsset uuid.UUID) error {
+	if err := i.MinVersionCheck(ctx, MinCopyMetadataVersion); err != nil {
 		return err
 	}
-	return i.oapiClient.CopyAsset(context.Background(), &oapi.AssetCopyDto{
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	return i.oapiClient.CopyAsset(ctx, &oapi.AssetCopyDto{
 		Albums:      oapi.NewOptBool(true),
 		Favorite:    oapi.NewOptBool(true),
 		SharedLinks: oapi.NewOptBool(true),
@@ -277,13 +582,32 @@ func (i *ImmichServer) CopyMetadata(oldAsset uuid.UUID, newAsset uuid.UUID) erro
 	})
 }
 
-func (i *ImmichServer) Download(filePath string, imageUUID uuid.UUID) error {
+func (i *ImmichServer) CreateStack(ctx context.Context, assetUUIDs []uuid.UUID) (uuid.UUID, error) {
+	if len(assetUUIDs) < 2 {
+		return uuid.UUID{}, errors.New("a stack needs at least two assets")
+	}
+	ctx, cancel := withTimeout(ctx, i.timeouts.API)
+	defer cancel()
+	response, err := i.oapiClient.CreateStack(ctx, &oapi.StackCreateDto{
+		AssetIds: assetUUIDs,
+	})
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return uuid.Parse(response.ID)
+}
+
+// Download stores the original of an asset at filePath, or in it if filePath is a directory.
+// A partially written file is removed if the download fails or ctx is cancelled.
+func (i *ImmichServer) Download(ctx context.Context, filePath string, imageUUID uuid.UUID) error {
 	stat, err := os.Stat(filePath)
 	if err != nil {
 		return err
 	}
 
-	response, err := i.oapiClient.DownloadAsset(context.Background(), oapi.DownloadAssetParams{ID: imageUUID})
+	ctx, cancel := withTimeout(ctx, i.timeouts.Download)
+	defer cancel()
+	response, err := i.oapiClient.DownloadAsset(ctx, oapi.DownloadAssetParams{ID: imageUUID})
 	if err != nil {
 		return err
 	}
@@ -294,20 +618,70 @@ func (i *ImmichServer) Download(filePath string, imageUUID uuid.UUID) error {
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 	_, err = io.Copy(file, response.Data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		os.Remove(filePath)
 		return err
 	}
 	return nil
 }
 
+// ImmichServerSecuritySource provides the API key of a server. A key file is read when the key
+// is first needed and read again whenever it changes, so a rotated key is used without a restart.
 type ImmichServerSecuritySource struct {
-	key oapi.APIKey
+	key     string
+	keyFile string
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// NewSecuritySource returns the security source for the key or key file of a server config.
+func NewSecuritySource(cfg ServerConfig) *ImmichServerSecuritySource {
+	return &ImmichServerSecuritySource{key: cfg.APIKey, keyFile: cfg.APIKeyFile}
 }
 
 func (s *ImmichServerSecuritySource) APIKey(ctx context.Context, operationName string) (oapi.APIKey, error) {
-	return s.key, nil
+	key, err := s.resolve()
+	if err != nil {
+		return oapi.APIKey{}, err
+	}
+	return oapi.APIKey{APIKey: key}, nil
+}
+
+func (s *ImmichServerSecuritySource) resolve() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.keyFile) == 0 {
+		return s.key, nil
+	}
+	stat, err := os.Stat(s.keyFile)
+	if err == nil && stat.ModTime().Equal(s.modTime) && len(s.key) > 0 {
+		return s.key, nil
+	}
+	var data []byte
+	if err == nil {
+		data, err = os.ReadFile(s.keyFile)
+	}
+	if err != nil {
+		if len(s.key) > 0 {
+			// Keep using the last key while the file is being replaced
+			slog.Warn("failed to read api key file, using the previous key", "path", s.keyFile, "err", err)
+			return s.key, nil
+		}
+		return "", fmt.Errorf("failed to read api key file: %w", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if len(key) == 0 {
+		return "", fmt.Errorf("api key file '%s' is empty", s.keyFile)
+	}
+	if len(s.key) > 0 && key != s.key {
+		slog.Info("api key changed", "path", s.keyFile)
+	}
+	s.key, s.modTime = key, stat.ModTime()
+	return key, nil
 }
 
 // Bearer provides bearer security value.
@@ -1,6 +1,17 @@
 package immichserver
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 func TestMinimumVersion(t *testing.T) {
 	table := []struct {
@@ -16,7 +27,10 @@ func TestMinimumVersion(t *testing.T) {
 		{ImmichServerVersion{1, 1, 0}, ImmichServerVersion{1, 1, 1}, false},
 		{ImmichServerVersion{2, 0, 0}, ImmichServerVersion{1, 1, 1}, true},
 		{ImmichServerVersion{1, 1, 0}, ImmichServerVersion{1, 0, 2}, true},
-		{ImmichServerVersion{1, 2, 0}, ImmichServerVersion{2, 0, 0}, true},
+		{ImmichServerVersion{1, 2, 0}, ImmichServerVersion{2, 0, 0}, false},
+		{ImmichServerVersion{1, 135, 0}, ImmichServerVersion{2, 2, 0}, false},
+		{ImmichServerVersion{2, 1, 9}, ImmichServerVersion{2, 2, 0}, false},
+		{ImmichServerVersion{3, 0, 0}, ImmichServerVersion{2, 2, 1}, true},
 	}
 	for _, scenario := range table {
 		if scenario.l.IsMinimumVersion(scenario.r) != scenario.expected {
@@ -24,3 +38,107 @@ func TestMinimumVersion(t *testing.T) {
 		}
 	}
 }
+
+func TestSecuritySourceKeyFile(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "apikey")
+	security := NewSecuritySource(ServerConfig{APIKeyFile: keyFile})
+	if _, err := security.APIKey(context.Background(), ""); err == nil {
+		t.Errorf("Expected an error for a missing key file")
+	}
+
+	os.WriteFile(keyFile, []byte("first\n"), 0o600)
+	key, err := security.APIKey(context.Background(), "")
+	if err != nil || key.APIKey != "first" {
+		t.Errorf("Expected key 'first', got '%s' (%v)", key.APIKey, err)
+	}
+
+	os.WriteFile(keyFile, []byte("second"), 0o600)
+	os.Chtimes(keyFile, time.Now(), time.Now().Add(time.Second))
+	key, err = security.APIKey(context.Background(), "")
+	if err != nil || key.APIKey != "second" {
+		t.Errorf("Expected rotated key 'second', got '%s' (%v)", key.APIKey, err)
+	}
+
+	os.Remove(keyFile)
+	key, err = security.APIKey(context.Background(), "")
+	if err != nil || key.APIKey != "second" {
+		t.Errorf("Expected previous key 'second' while the file is missing, got '%s' (%v)", key.APIKey, err)
+	}
+}
+
+func TestFullSyncPagination(t *testing.T) {
+	// Pages hold 100 assets, the sync continues while a page is full
+	for _, count := range []int{0, 99, 100, 250} {
+		fake, server := newTestServer(t)
+		for n := range count {
+			fake.AddAsset(fmt.Sprintf("%d.jpg", n), []byte(fmt.Sprintf("data %d", n)))
+		}
+		assets, err := server.DoFullSync(context.Background(), time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*assets) != count {
+			t.Errorf("Expected full sync to return all %d assets, got %d", count, len(*assets))
+		}
+	}
+}
+
+func TestCreatedAlbumIsCached(t *testing.T) {
+	fake, server := newTestServer(t)
+	albumUUID, err := server.CreateNewAlbum(context.Background(), "Trip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The new album is cached by its ID, looking it up needs no request
+	fake.Close()
+	album, err := server.Album(context.Background(), albumUUID)
+	if err != nil || album.AlbumName != "Trip" {
+		t.Errorf("Expected the created album from the cache, got %v (%v)", album, err)
+	}
+}
+
+func TestAddToAlbumRefreshesCache(t *testing.T) {
+	fake, server := newTestServer(t)
+	albumUUID, err := server.CreateNewAlbum(context.Background(), "Trip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if album, err := server.Album(context.Background(), albumUUID); err != nil || len(album.Assets) != 0 {
+		t.Fatalf("Expected the empty album, got %v (%v)", album, err)
+	}
+	assetID := fake.AddAsset("a.jpg", []byte("a"))
+	if err = server.AddToAlbum(context.Background(), []uuid.UUID{uuid.MustParse(assetID)}, albumUUID); err != nil {
+		t.Fatal(err)
+	}
+	album, err := server.Album(context.Background(), albumUUID)
+	if err != nil || len(album.Assets) != 1 || album.Assets[0].ID != assetID {
+		t.Errorf("Expected the album to list the added asset, got %v (%v)", album, err)
+	}
+}
+
+func TestStatusSlowServer(t *testing.T) {
+	previous := StatusTimeout
+	StatusTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { StatusTimeout = previous })
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+	server, err := NewImmichServer(NewSecuritySource(ServerConfig{APIKey: "key"}), slow.URL, "test-device")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if status := server.Status(context.Background()); status.Online {
+		t.Errorf("Expected a server that does not answer to be offline")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the status within the status timeout, took %s", elapsed)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package immichserver
+
+import "io/fs"
+
+// inode returns 0, inode numbers are only used on Unix.
+func inode(info fs.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package immichserver
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// inode returns the inode number of a file, 0 if it is not known.
+func inode(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
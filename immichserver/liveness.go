@@ -0,0 +1,71 @@
+package immichserver
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStallTimeout is how long the watcher or scheduler of a directory may work on one step
+// without progress, or a scheduled scan may be overdue, before the directory is reported as stuck.
+const DefaultStallTimeout = 10 * time.Minute
+
+// processStart is the origin of the liveness stamps. They use the monotonic clock like the timers
+// of the loops, so a suspended system does not look stuck after resuming.
+var processStart = time.Now()
+
+func stamp() int64 {
+	return max(int64(time.Since(processStart)), 1)
+}
+
+// liveness records the progress of the watcher and scheduler loops of a directory.
+// The busy stamps are 0 while a loop waits for its next event or scheduled scan.
+type liveness struct {
+	watcherBusy   atomic.Int64
+	schedulerBusy atomic.Int64
+	// scheduledAt is when the next scheduled scan is due, 0 if none is planned
+	scheduledAt atomic.Int64
+	// progress is the last time a file was read while hashing or an upload finished
+	progress atomic.Int64
+}
+
+func (l *liveness) progressed() {
+	l.progress.Store(stamp())
+}
+
+// stalled returns for how long a loop that is busy since the stamp made no progress, 0 if it is waiting.
+func (l *liveness) stalled(busy, now int64) time.Duration {
+	if busy == 0 {
+		return 0
+	}
+	return time.Duration(now - max(busy, l.progress.Load()))
+}
+
+// progressReader marks progress on every read, so hashing a large file is not taken for a stall.
+type progressReader struct {
+	r io.Reader
+	l *liveness
+}
+
+func (p progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.l.progressed()
+	return n, err
+}
+
+// CheckLiveness returns an error if the watcher or scheduler of the directory worked on one step
+// for longer than stall without progress, or if a scheduled scan is overdue by more than stall.
+func (i *ImageDirectory) CheckLiveness(stall time.Duration) error {
+	l, now := i.liveness, stamp()
+	if d := l.stalled(l.watcherBusy.Load(), now); d > stall {
+		return fmt.Errorf("watcher of %s made no progress for %s", i.path, d.Round(time.Second))
+	}
+	if d := l.stalled(l.schedulerBusy.Load(), now); d > stall {
+		return fmt.Errorf("scheduled scan of %s made no progress for %s", i.path, d.Round(time.Second))
+	}
+	if due := l.scheduledAt.Load(); due != 0 && l.schedulerBusy.Load() == 0 && time.Duration(now-due) > stall {
+		return fmt.Errorf("scheduled scan of %s did not start, it is overdue by %s", i.path, time.Duration(now-due).Round(time.Second))
+	}
+	return nil
+}
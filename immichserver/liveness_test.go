@@ -0,0 +1,59 @@
+package immichserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/schedule"
+)
+
+func TestCheckLiveness(t *testing.T) {
+	const stall = 20 * time.Millisecond
+	dir := NewImageDirectory(t.TempDir(), false)
+	if err := dir.CheckLiveness(stall); err != nil {
+		t.Errorf("Expected a new directory to be alive, got %s", err)
+	}
+
+	dir.liveness.watcherBusy.Store(stamp())
+	time.Sleep(2 * stall)
+	if err := dir.CheckLiveness(stall); err == nil {
+		t.Errorf("Expected a watcher without progress to be stuck")
+	}
+	dir.liveness.progressed()
+	if err := dir.CheckLiveness(stall); err != nil {
+		t.Errorf("Expected a watcher that made progress to be alive, got %s", err)
+	}
+	dir.liveness.watcherBusy.Store(0)
+
+	dir.liveness.scheduledAt.Store(stamp())
+	time.Sleep(2 * stall)
+	if err := dir.CheckLiveness(stall); err == nil {
+		t.Errorf("Expected an overdue scheduled scan to be reported")
+	}
+	dir.liveness.schedulerBusy.Store(stamp())
+	if err := dir.CheckLiveness(stall); err != nil {
+		t.Errorf("Expected a scheduled scan that just started to be alive, got %s", err)
+	}
+}
+
+func TestScheduledScanLiveness(t *testing.T) {
+	_, server := newTestServer(t)
+	dir := NewImageDirectory(t.TempDir(), false)
+	dir.SetSchedule(schedule.Interval(10*time.Millisecond), 0)
+	dir.StartSchedule(context.Background(), server, newTestPool(t))
+	defer dir.Stop()
+
+	// The scheduler keeps running scans, so none is overdue for long
+	for range 10 {
+		time.Sleep(10 * time.Millisecond)
+		if err := dir.CheckLiveness(time.Second); err != nil {
+			t.Fatalf("Expected a running scheduler to be alive, got %s", err)
+		}
+	}
+	dir.Stop()
+	time.Sleep(20 * time.Millisecond)
+	if due := dir.liveness.scheduledAt.Load(); due != 0 {
+		t.Errorf("Expected no scheduled scan after stopping, got %d", due)
+	}
+}
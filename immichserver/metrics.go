@@ -0,0 +1,102 @@
+package immichserver
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"time"
+
+	"github.com/ogen-go/ogen/validate"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const meterName = "github.com/JonaEnz/immich-sync/immichserver"
+
+type immichMetrics struct {
+	uploadedBytes  metric.Int64Counter
+	uploadDuration metric.Float64Histogram
+	uploadFailures metric.Int64Counter
+	server         *ImmichServer
+}
+
+// newImmichMetrics registers the sync metrics on the global meter provider.
+// The gauges are read from the image directories of the server on collection.
+func newImmichMetrics(server *ImmichServer) (immichMetrics, error) {
+	meter := otel.GetMeterProvider().Meter(meterName)
+	m := immichMetrics{server: server}
+	var err error
+	if m.uploadedBytes, err = meter.Int64Counter("immich_sync.uploaded",
+		metric.WithUnit("By"),
+		metric.WithDescription("Bytes uploaded to the Immich server")); err != nil {
+		return m, err
+	}
+	if m.uploadDuration, err = meter.Float64Histogram("immich_sync.upload.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of asset uploads")); err != nil {
+		return m, err
+	}
+	if m.uploadFailures, err = meter.Int64Counter("immich_sync.upload.failures",
+		metric.WithDescription("Failed uploads by error class")); err != nil {
+		return m, err
+	}
+
+	filesTracked, err := meter.Int64ObservableGauge("immich_sync.files.tracked",
+		metric.WithDescription("Files tracked per watched directory"))
+	if err != nil {
+		return m, err
+	}
+	queueDepth, err := meter.Int64ObservableGauge("immich_sync.upload.queue",
+		metric.WithDescription("Files waiting to be uploaded per watched directory"))
+	if err != nil {
+		return m, err
+	}
+	lastScan, err := meter.Float64ObservableGauge("immich_sync.scan.last_success",
+		metric.WithUnit("s"),
+		metric.WithDescription("Unix time of the last successful scan per watched directory"))
+	if err != nil {
+		return m, err
+	}
+	// Only locked accessors of the server and directories are used, the callback runs concurrently to scans
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		for _, dir := range server.Directories() {
+			attrs := metric.WithAttributes(attribute.String("server", server.Profile), attribute.String("dir", dir.Path()))
+			o.ObserveInt64(filesTracked, int64(dir.Count()), attrs)
+			o.ObserveInt64(queueDepth, int64(dir.Pending()), attrs)
+			if scanned := dir.LastScan(); !scanned.IsZero() {
+				o.ObserveFloat64(lastScan, float64(scanned.UnixNano())/float64(time.Second), attrs)
+			}
+		}
+		return nil
+	}, filesTracked, queueDepth, lastScan)
+	return m, err
+}
+
+func (m *immichMetrics) recordUpload(start time.Time, size int64, err error) {
+	ctx := context.Background()
+	serverAttr := attribute.String("server", m.server.Profile)
+	m.uploadDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(serverAttr))
+	if err != nil {
+		m.uploadFailures.Add(ctx, 1, metric.WithAttributes(serverAttr, attribute.String("class", errorClass(err))))
+		return
+	}
+	m.uploadedBytes.Add(ctx, size, metric.WithAttributes(serverAttr))
+}
+
+// errorClass sorts an error into a coarse class usable as a metric label.
+func errorClass(err error) string {
+	var statusErr *validate.UnexpectedStatusCodeError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &statusErr):
+		return "server"
+	case errors.As(err, &netErr):
+		return "network"
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrPermission):
+		return "file"
+	default:
+		return "other"
+	}
+}
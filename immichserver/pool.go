@@ -0,0 +1,128 @@
+package immichserver
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// JobClass orders the jobs of a WorkerPool, jobs of a lower class run first.
+type JobClass uint8
+
+const (
+	// JobRequest are uploads requested explicitly, e.g. with `immich-sync upload`.
+	JobRequest JobClass = iota
+	// JobEvent are uploads of files reported by a directory watcher.
+	JobEvent
+	// JobBackfill are uploads of files found by scanning a directory.
+	JobBackfill
+)
+
+// WorkerPool runs the uploads of all directories with a bounded number of workers.
+// Queued jobs run ordered by class, then by priority (higher first), then in the order they were submitted.
+type WorkerPool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   jobQueue
+	seq     uint64
+	workers int
+	running int
+	closed  bool
+}
+
+type job struct {
+	class    JobClass
+	priority int
+	seq      uint64
+	run      func()
+}
+
+// jobQueue implements heap.Interface, the next job to run is at index 0.
+type jobQueue []*job
+
+func (q jobQueue) Len() int { return len(q) }
+
+func (q jobQueue) Less(a, b int) bool {
+	if q[a].class != q[b].class {
+		return q[a].class < q[b].class
+	}
+	if q[a].priority != q[b].priority {
+		return q[a].priority > q[b].priority
+	}
+	return q[a].seq < q[b].seq
+}
+
+func (q jobQueue) Swap(a, b int) { q[a], q[b] = q[b], q[a] }
+
+func (q *jobQueue) Push(x any) { *q = append(*q, x.(*job)) }
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	j := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return j
+}
+
+func NewWorkerPool(workers int) *WorkerPool {
+	p := &WorkerPool{}
+	p.cond = sync.NewCond(&p.mu)
+	p.Resize(workers)
+	return p
+}
+
+// Submit queues a job. It returns false if the pool is closed, the job is not run then.
+func (p *WorkerPool) Submit(class JobClass, priority int, run func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.seq += 1
+	heap.Push(&p.queue, &job{class: class, priority: priority, seq: p.seq, run: run})
+	p.cond.Signal()
+	return true
+}
+
+// Resize changes the number of workers, surplus workers stop after their current job.
+func (p *WorkerPool) Resize(workers int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.workers = max(workers, 1)
+	for p.running < p.workers {
+		p.running += 1
+		go p.work()
+	}
+	p.cond.Broadcast()
+}
+
+// Queued returns the number of jobs waiting for a worker.
+func (p *WorkerPool) Queued() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+// Close stops the workers once the queued jobs are done.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+}
+
+func (p *WorkerPool) work() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed && p.running <= p.workers {
+			p.cond.Wait()
+		}
+		if p.running > p.workers || len(p.queue) == 0 {
+			p.running -= 1
+			p.mu.Unlock()
+			return
+		}
+		j := heap.Pop(&p.queue).(*job)
+		p.mu.Unlock()
+		j.run()
+	}
+}
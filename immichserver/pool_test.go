@@ -0,0 +1,104 @@
+package immichserver
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolLimitsConcurrency(t *testing.T) {
+	pool := NewWorkerPool(2)
+	defer pool.Close()
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	job := func() {
+		defer wg.Done()
+		n := running.Add(1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+	}
+	for range 10 {
+		wg.Add(1)
+		pool.Submit(JobBackfill, 0, job)
+	}
+	wg.Wait()
+	if maxRunning.Load() != 2 {
+		t.Errorf("Expected 2 jobs to run at once, got %d", maxRunning.Load())
+	}
+
+	pool.Resize(4)
+	maxRunning.Store(0)
+	for range 10 {
+		wg.Add(1)
+		pool.Submit(JobBackfill, 0, job)
+	}
+	wg.Wait()
+	if maxRunning.Load() != 4 {
+		t.Errorf("Expected 4 jobs to run at once after resizing, got %d", maxRunning.Load())
+	}
+}
+
+func TestWorkerPoolClose(t *testing.T) {
+	pool := NewWorkerPool(1)
+	done := make(chan any)
+	block := make(chan any)
+	pool.Submit(JobBackfill, 0, func() { <-block })
+	pool.Submit(JobBackfill, 0, func() { close(done) })
+	pool.Close()
+	if pool.Submit(JobBackfill, 0, func() {}) {
+		t.Errorf("Expected a closed pool to reject jobs")
+	}
+	close(block)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected jobs queued before closing to run")
+	}
+}
+
+func TestWorkerPoolOrder(t *testing.T) {
+	pool := NewWorkerPool(1)
+	defer pool.Close()
+	started, block := make(chan any), make(chan any)
+	pool.Submit(JobBackfill, 0, func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	var mu sync.Mutex
+	order := make([]string, 0)
+	var wg sync.WaitGroup
+	submit := func(name string, class JobClass, priority int) {
+		wg.Add(1)
+		pool.Submit(class, priority, func() {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		})
+	}
+	submit("backfill", JobBackfill, 0)
+	submit("backfill high", JobBackfill, 10)
+	submit("event", JobEvent, 0)
+	submit("event second", JobEvent, 0)
+	submit("request", JobRequest, 0)
+	if pool.Queued() != 5 {
+		t.Errorf("Expected 5 queued jobs, got %d", pool.Queued())
+	}
+	close(block)
+	wg.Wait()
+
+	expected := []string{"request", "event", "event second", "backfill high", "backfill"}
+	if !slices.Equal(order, expected) {
+		t.Errorf("Expected jobs to run in order %v, got %v", expected, order)
+	}
+}
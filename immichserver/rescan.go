@@ -0,0 +1,121 @@
+package immichserver
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/schedule"
+)
+
+// SetSchedule sets when StartSchedule rescans the directory, nil disables scheduled rescans.
+// Every run is delayed by a random duration of up to jitter, so directories and hosts do not scan at once.
+// An unchanged schedule keeps the planned run.
+func (i *ImageDirectory) SetSchedule(s schedule.Schedule, jitter time.Duration) {
+	i.mu.Lock()
+	unchanged := scheduleString(i.schedule) == scheduleString(s) && i.jitter == jitter
+	i.schedule = s
+	i.jitter = jitter
+	i.mu.Unlock()
+	if unchanged {
+		return
+	}
+	select {
+	case i.rescheduled <- nil:
+	default: // The loop has not picked up the previous change yet
+	}
+}
+
+func scheduleString(s schedule.Schedule) string {
+	if s == nil {
+		return ""
+	}
+	return s.String()
+}
+
+func (i *ImageDirectory) Schedule() schedule.Schedule {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.schedule
+}
+
+// NextScan returns when the next scheduled rescan runs, the zero time if none is scheduled.
+func (i *ImageDirectory) NextScan() time.Time {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.nextScan
+}
+
+// Rescan reads the whole directory and queues new and changed files as backfill, this catches changes
+// the watcher missed. It returns false without reading if the previous rescan or its uploads are still running.
+func (i *ImageDirectory) Rescan(ctx context.Context, server *ImmichServer, pool *WorkerPool, keepChangedFiles bool) (bool, error) {
+	if !i.rescanning.CompareAndSwap(false, true) {
+		return false, nil
+	}
+	read, err := i.Read(ctx)
+	if err != nil {
+		i.rescanning.Store(false)
+		return true, err
+	}
+	slog.Info("found new/updated files", "op", "scan", "dir", i.path, "count", read)
+	i.Upload(ctx, server, pool, keepChangedFiles)
+	go func() {
+		i.Wait(ctx)
+		i.rescanning.Store(false)
+	}()
+	return true, nil
+}
+
+// StartSchedule rescans the directory on its schedule until ctx is done or the directory is stopped.
+// Runs are skipped while the previous rescan is still running.
+func (i *ImageDirectory) StartSchedule(ctx context.Context, server *ImmichServer, pool *WorkerPool) {
+	next := i.planNextScan(time.Now())
+	go func() {
+		for ; ; next = i.planNextScan(time.Now()) {
+			var fire <-chan time.Time
+			i.liveness.scheduledAt.Store(0)
+			if !next.IsZero() {
+				wait := time.Until(next)
+				i.liveness.scheduledAt.Store(stamp() + int64(max(wait, 0)))
+				fire = time.After(wait)
+			}
+			select {
+			case <-ctx.Done():
+				i.liveness.scheduledAt.Store(0)
+				return
+			case <-i.stopped:
+				i.liveness.scheduledAt.Store(0)
+				return
+			case <-i.rescheduled:
+			case <-fire:
+				i.liveness.schedulerBusy.Store(stamp())
+				slog.Info("running scheduled scan", "op", "scan", "dir", i.path)
+				started, err := i.Rescan(ctx, server, pool, i.keepChanged.Load())
+				if !started {
+					slog.Warn("skipping scheduled scan, the previous scan is still running", "op", "scan", "dir", i.path)
+				} else if err != nil {
+					slog.Error("failed to scan directory", "op", "scan", "dir", i.path, "err", err)
+					i.setLastErr(err.Error())
+				}
+				i.liveness.schedulerBusy.Store(0)
+			}
+		}
+	}()
+}
+
+// planNextScan computes and records the time of the next scheduled rescan.
+func (i *ImageDirectory) planNextScan(now time.Time) time.Time {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.nextScan = time.Time{}
+	if i.schedule == nil {
+		return i.nextScan
+	}
+	next := i.schedule.Next(now)
+	if !next.IsZero() && i.jitter > 0 {
+		next = next.Add(rand.N(i.jitter))
+	}
+	i.nextScan = next
+	return next
+}
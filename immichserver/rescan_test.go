@@ -0,0 +1,78 @@
+package immichserver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/schedule"
+)
+
+func TestScheduledRescan(t *testing.T) {
+	fake, server := newTestServer(t)
+	path := t.TempDir()
+	dir := NewImageDirectory(path, false)
+	dir.SetSchedule(schedule.Interval(20*time.Millisecond), 0)
+	dir.StartSchedule(context.Background(), server, newTestPool(t))
+	defer dir.Stop()
+
+	if status := dir.Status(); status.Schedule != "20ms" || status.NextScan.IsZero() {
+		t.Errorf("Expected the schedule and next scan in the status, got %+v", status)
+	}
+	// Not watched, only a rescan finds the file
+	checksum := writeFile(t, filepath.Join(path, "a.jpg"), "a", time.Now().Add(-time.Hour))
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := fake.AssetByChecksum(checksum); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := fake.AssetByChecksum(checksum); !ok {
+		t.Fatal("Expected the scheduled rescan to upload the file")
+	}
+	if dir.LastScan().IsZero() {
+		t.Errorf("Expected the last scan time to be set")
+	}
+
+	dir.SetSchedule(nil, 0)
+	time.Sleep(20 * time.Millisecond)
+	if status := dir.Status(); status.Schedule != "" || !status.NextScan.IsZero() {
+		t.Errorf("Expected no next scan without a schedule, got %+v", status)
+	}
+}
+
+func TestRescanSkipsWhileRunning(t *testing.T) {
+	_, server := newTestServer(t)
+	path := t.TempDir()
+	writeFile(t, filepath.Join(path, "a.jpg"), "a", time.Now().Add(-time.Hour))
+	dir := NewImageDirectory(path, false)
+	pool := newTestPool(t)
+	started, block := make(chan any), make(chan any)
+	pool.Submit(JobRequest, 0, func() {
+		close(started)
+		<-block
+	})
+	pool.Submit(JobRequest, 0, func() { <-block })
+	<-started
+
+	if ok, err := dir.Rescan(context.Background(), server, pool, false); !ok || err != nil {
+		t.Fatalf("Expected the first rescan to start, got %t, %v", ok, err)
+	}
+	if ok, _ := dir.Rescan(context.Background(), server, pool, false); ok {
+		t.Errorf("Expected a rescan to be skipped while the uploads of the previous one are queued")
+	}
+	close(block)
+	if !dir.WaitForUploads(10 * time.Second) {
+		t.Fatal("uploads did not finish")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if ok, _ := dir.Rescan(context.Background(), server, pool, false); ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Expected a rescan to start once the previous one finished")
+}
@@ -0,0 +1,263 @@
+package immichserver
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StackPrimaryJpeg  = "jpeg"
+	StackPrimaryRaw   = "raw"
+	StackPrimaryFirst = "first"
+)
+
+var rawExtensions = []string{
+	".3fr", ".ari", ".arw", ".cr2", ".cr3", ".crw", ".dcr", ".dng", ".erf", ".fff", ".iiq",
+	".k25", ".kdc", ".mef", ".mos", ".mrw", ".nef", ".nrw", ".orf", ".ori", ".pef", ".raf",
+	".raw", ".rw2", ".rwl", ".sr2", ".srf", ".srw", ".x3f",
+}
+
+// StackConfig controls which files of a directory are grouped into an Immich stack.
+// Raw stacks files sharing a base name when one of them is a RAW file (DSC_0001.NEF + DSC_0001.JPG).
+// Burst stacks files of the same folder whose capture times are at most Burst apart, 0 disables it.
+// Primary selects the asset shown for the stack: "jpeg" (default), "raw" or "first".
+type StackConfig struct {
+	Raw     bool          `json:"raw" mapstructure:"raw"`
+	Burst   time.Duration `json:"burst" mapstructure:"burst"`
+	Primary string        `json:"primary" mapstructure:"primary"`
+}
+
+func (s StackConfig) Enabled() bool {
+	return s.Raw || s.Burst > 0
+}
+
+func (s StackConfig) Validate() error {
+	switch s.Primary {
+	case "", StackPrimaryJpeg, StackPrimaryRaw, StackPrimaryFirst:
+	default:
+		return fmt.Errorf("invalid stack primary '%s', expected one of %s, %s or %s", s.Primary, StackPrimaryJpeg, StackPrimaryRaw, StackPrimaryFirst)
+	}
+	if s.Burst < 0 {
+		return errors.New("stack burst needs to be positive")
+	}
+	return nil
+}
+
+// capturedFile is a cached capture time, valid while the file keeps its size and modification time.
+type capturedFile struct {
+	modTime time.Time
+	size    int64
+	at      time.Time
+}
+
+// stackIndex orders the files of each folder by capture time and groups them by stem, so the stacks of
+// changed files are found from their neighbours instead of regrouping all files of the directory.
+type stackIndex struct {
+	mu sync.Mutex
+	// burst and dates are the settings the capture times were read with, the index is rebuilt when they change
+	built bool
+	burst bool
+	dates DateCorrection
+	times map[string]capturedFile
+	// folders holds the paths of each folder ordered by capture time and path
+	folders map[string][]string
+	stems   map[string][]string
+}
+
+func stem(p string) string {
+	return strings.ToLower(strings.TrimSuffix(p, filepath.Ext(p)))
+}
+
+func (x *stackIndex) compare(a, b string) int {
+	if c := x.times[a].at.Compare(x.times[b].at); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// update sets the capture time of the file, which is only read again if the file changed.
+// EXIF data is only read for burst stacking, otherwise the modification time is used.
+func (x *stackIndex) update(p string, entry FileStat) {
+	old, ok := x.times[p]
+	if ok && old.modTime.Equal(entry.modTime) && old.size == entry.size {
+		return
+	}
+	c := capturedFile{modTime: entry.modTime, size: entry.size, at: entry.modTime}
+	if x.burst {
+		if at, found, err := x.dates.CaptureTime(p); found && err == nil {
+			c.at = at
+		}
+	}
+	if ok {
+		x.remove(p)
+	}
+	x.times[p] = c
+	folder := filepath.Dir(p)
+	n, _ := slices.BinarySearchFunc(x.folders[folder], p, x.compare)
+	x.folders[folder] = slices.Insert(x.folders[folder], n, p)
+	x.stems[stem(p)] = append(x.stems[stem(p)], p)
+}
+
+func (x *stackIndex) remove(p string) {
+	folder := filepath.Dir(p)
+	if n, found := slices.BinarySearchFunc(x.folders[folder], p, x.compare); found {
+		x.folders[folder] = slices.Delete(x.folders[folder], n, n+1)
+	}
+	x.stems[stem(p)] = slices.DeleteFunc(x.stems[stem(p)], func(s string) bool { return s == p })
+	delete(x.times, p)
+}
+
+// neighbours returns the capture times of the changed files and of all files that may be stacked with them:
+// files of the same stem, and files of the same folder captured at most cfg.Burst after each other.
+func (x *stackIndex) neighbours(changed []string, cfg StackConfig) map[string]time.Time {
+	result := make(map[string]time.Time)
+	queue := slices.Clone(changed)
+	for len(queue) > 0 {
+		p := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		c, ok := x.times[p]
+		if _, seen := result[p]; seen || !ok {
+			continue
+		}
+		result[p] = c.at
+		if cfg.Raw {
+			queue = append(queue, x.stems[stem(p)]...)
+		}
+		if cfg.Burst > 0 {
+			paths := x.folders[filepath.Dir(p)]
+			n, _ := slices.BinarySearchFunc(paths, p, x.compare)
+			if n > 0 && c.at.Sub(x.times[paths[n-1]].at) <= cfg.Burst {
+				queue = append(queue, paths[n-1])
+			}
+			if n+1 < len(paths) && x.times[paths[n+1]].at.Sub(c.at) <= cfg.Burst {
+				queue = append(queue, paths[n+1])
+			}
+		}
+	}
+	return result
+}
+
+// stackCandidates returns the capture times of the changed files and the files they may be stacked with.
+// The index is built from all files on first use, later only the changed files are read again.
+func (i *ImageDirectory) stackCandidates(changed map[string]bool, cfg StackConfig) map[string]time.Time {
+	i.mu.RLock()
+	dates := i.dates
+	i.mu.RUnlock()
+	x := i.stacks
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !x.built || x.burst != (cfg.Burst > 0) || x.dates != dates {
+		x.built, x.burst, x.dates = true, cfg.Burst > 0, dates
+		x.times = make(map[string]capturedFile)
+		x.folders = make(map[string][]string)
+		x.stems = make(map[string][]string)
+		for p, entry := range i.files() {
+			x.update(p, entry)
+		}
+	} else {
+		for p := range changed {
+			if entry, ok := i.file(p); ok {
+				x.update(p, entry)
+			}
+		}
+	}
+	return x.neighbours(slices.Collect(maps.Keys(changed)), cfg)
+}
+
+func isRawFile(filePath string) bool {
+	return slices.Contains(rawExtensions, strings.ToLower(filepath.Ext(filePath)))
+}
+
+// stackGroups returns all groups of at least two paths that should be stacked, each ordered
+// so that the primary asset comes first. files maps the paths to their capture times.
+func stackGroups(files map[string]time.Time, cfg StackConfig) [][]string {
+	if !cfg.Enabled() {
+		return nil
+	}
+	captureTime := func(p string) time.Time {
+		return files[p]
+	}
+
+	groups := make([][]string, 0)
+	if cfg.Raw {
+		byStem := make(map[string][]string)
+		for p := range files {
+			byStem[stem(p)] = append(byStem[stem(p)], p)
+		}
+		for _, g := range byStem {
+			if len(g) > 1 && slices.ContainsFunc(g, isRawFile) {
+				groups = append(groups, g)
+				continue
+			}
+			for _, p := range g {
+				groups = append(groups, []string{p})
+			}
+		}
+	} else {
+		for p := range files {
+			groups = append(groups, []string{p})
+		}
+	}
+
+	if cfg.Burst > 0 {
+		start := func(g []string) time.Time {
+			return captureTime(slices.MinFunc(g, func(a, b string) int {
+				return captureTime(a).Compare(captureTime(b))
+			}))
+		}
+		slices.SortFunc(groups, func(a, b []string) int {
+			if c := strings.Compare(filepath.Dir(a[0]), filepath.Dir(b[0])); c != 0 {
+				return c
+			}
+			if c := start(a).Compare(start(b)); c != 0 {
+				return c
+			}
+			return strings.Compare(a[0], b[0])
+		})
+		merged := make([][]string, 0, len(groups))
+		for _, g := range groups {
+			if len(merged) > 0 {
+				last := merged[len(merged)-1]
+				lastTime := captureTime(slices.MaxFunc(last, func(a, b string) int {
+					return captureTime(a).Compare(captureTime(b))
+				}))
+				if filepath.Dir(last[0]) == filepath.Dir(g[0]) && start(g).Sub(lastTime) <= cfg.Burst {
+					merged[len(merged)-1] = append(last, g...)
+					continue
+				}
+			}
+			merged = append(merged, g)
+		}
+		groups = merged
+	}
+
+	result := make([][]string, 0)
+	for _, g := range groups {
+		if len(g) < 2 {
+			continue
+		}
+		slices.SortFunc(g, func(a, b string) int {
+			if cfg.Primary != StackPrimaryFirst && isRawFile(a) != isRawFile(b) {
+				if isRawFile(a) == (cfg.Primary == StackPrimaryRaw) {
+					return -1
+				}
+				return 1
+			}
+			if c := captureTime(a).Compare(captureTime(b)); c != 0 {
+				return c
+			}
+			return strings.Compare(a, b)
+		})
+		result = append(result, g)
+	}
+	slices.SortFunc(result, func(a, b []string) int {
+		return strings.Compare(a[0], b[0])
+	})
+	return result
+}
@@ -0,0 +1,128 @@
+package immichserver
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/immichtest"
+)
+
+func TestStackGroups(t *testing.T) {
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	files := map[string]time.Time{}
+	add := func(p string, offset time.Duration) {
+		files[p] = base.Add(offset)
+	}
+	add("/a/DSC_0001.NEF", 0)
+	add("/a/DSC_0001.JPG", 0)
+	add("/a/DSC_0002.JPG", 500*time.Millisecond)
+	add("/a/DSC_0003.JPG", 1*time.Second)
+	add("/a/DSC_0004.JPG", 1*time.Minute)
+	add("/b/DSC_0005.JPG", 1*time.Second)
+
+	table := []struct {
+		cfg      StackConfig
+		expected [][]string
+	}{
+		{StackConfig{}, [][]string{}},
+		{StackConfig{Raw: true}, [][]string{{"/a/DSC_0001.JPG", "/a/DSC_0001.NEF"}}},
+		{StackConfig{Raw: true, Primary: StackPrimaryRaw}, [][]string{{"/a/DSC_0001.NEF", "/a/DSC_0001.JPG"}}},
+		{
+			StackConfig{Raw: true, Burst: time.Second},
+			[][]string{{"/a/DSC_0001.JPG", "/a/DSC_0002.JPG", "/a/DSC_0003.JPG", "/a/DSC_0001.NEF"}},
+		},
+		{
+			StackConfig{Burst: 600 * time.Millisecond, Primary: StackPrimaryFirst},
+			[][]string{{"/a/DSC_0001.JPG", "/a/DSC_0001.NEF", "/a/DSC_0002.JPG", "/a/DSC_0003.JPG"}},
+		},
+	}
+	for _, scenario := range table {
+		groups := stackGroups(files, scenario.cfg)
+		if !slices.EqualFunc(groups, scenario.expected, slices.Equal) {
+			t.Errorf("Expected groups %v for %+v, got %v", scenario.expected, scenario.cfg, groups)
+		}
+	}
+}
+
+func TestStackNeighbours(t *testing.T) {
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	x := &stackIndex{times: map[string]capturedFile{}, folders: map[string][]string{}, stems: map[string][]string{}}
+	add := func(p string, offset time.Duration) {
+		x.update(p, FileStat{modTime: base.Add(offset), size: 1})
+	}
+	add("/a/DSC_0001.NEF", 0)
+	add("/a/DSC_0001.JPG", 0)
+	add("/a/DSC_0002.JPG", 500*time.Millisecond)
+	add("/a/DSC_0003.JPG", 1*time.Second)
+	add("/a/DSC_0004.JPG", 1*time.Minute)
+	add("/b/DSC_0005.JPG", 1*time.Second)
+	// Moved next to DSC_0004.JPG by a changed modification time
+	add("/a/DSC_0003.JPG", 1*time.Minute+time.Second)
+
+	table := []struct {
+		changed  string
+		cfg      StackConfig
+		expected []string
+	}{
+		{"/a/DSC_0001.JPG", StackConfig{Raw: true}, []string{"/a/DSC_0001.JPG", "/a/DSC_0001.NEF"}},
+		{"/a/DSC_0002.JPG", StackConfig{Raw: true}, []string{"/a/DSC_0002.JPG"}},
+		{"/a/DSC_0002.JPG", StackConfig{Burst: 600 * time.Millisecond}, []string{"/a/DSC_0001.JPG", "/a/DSC_0001.NEF", "/a/DSC_0002.JPG"}},
+		{"/a/DSC_0004.JPG", StackConfig{Burst: time.Second}, []string{"/a/DSC_0003.JPG", "/a/DSC_0004.JPG"}},
+		{"/b/DSC_0005.JPG", StackConfig{Raw: true, Burst: time.Hour}, []string{"/b/DSC_0005.JPG"}},
+	}
+	for _, scenario := range table {
+		found := slices.Sorted(maps.Keys(x.neighbours([]string{scenario.changed}, scenario.cfg)))
+		if !slices.Equal(found, scenario.expected) {
+			t.Errorf("Expected neighbours %v of %s for %+v, got %v", scenario.expected, scenario.changed, scenario.cfg, found)
+		}
+	}
+}
+
+func TestBurstStacksByCaptureTime(t *testing.T) {
+	fake, server := newTestServer(t)
+	path := t.TempDir()
+	// Copied from the card at once, the modification times say nothing about when the photos were taken
+	copied := time.Now().Add(-time.Hour)
+	files := map[string][]byte{
+		"DSC_0001.JPG": immichtest.JPEG("2024:07:14 13:00:00", "+02:00", false),
+		"DSC_0002.JPG": immichtest.JPEG("2024:07:14 13:00:01", "+02:00", false),
+		"DSC_0003.JPG": immichtest.JPEG("2024:07:14 15:30:00", "+02:00", false),
+		"noexif.jpg":   []byte("no exif"),
+	}
+	for name, data := range files {
+		file := filepath.Join(path, name)
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(file, copied, copied)
+	}
+	dir := NewImageDirectory(path, false)
+	dir.SetStackConfig(StackConfig{Burst: 2 * time.Second})
+	uploadDirectory(t, server, &dir, false)
+
+	stackOf := func(name string) string {
+		status, _ := dir.FileStatus(filepath.Join(path, name))
+		asset, _ := fake.Asset(status.AssetID)
+		return asset.StackID
+	}
+	if stackOf("DSC_0001.JPG") == "" || stackOf("DSC_0001.JPG") != stackOf("DSC_0002.JPG") {
+		t.Errorf("Expected the photos taken a second apart to be stacked")
+	}
+	if stackOf("DSC_0003.JPG") != "" || stackOf("noexif.jpg") != "" {
+		t.Errorf("Expected the photos taken later and without capture time not to be stacked, got %q and %q", stackOf("DSC_0003.JPG"), stackOf("noexif.jpg"))
+	}
+
+	// A photo added later is stacked with the indexed photo taken just before it
+	added := filepath.Join(path, "DSC_0004.JPG")
+	if err := os.WriteFile(added, immichtest.JPEG("2024:07:14 15:30:01", "+02:00", false), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	uploadDirectory(t, server, &dir, false)
+	if stackOf("DSC_0004.JPG") == "" || stackOf("DSC_0004.JPG") != stackOf("DSC_0003.JPG") {
+		t.Errorf("Expected the added photo to be stacked with the photo taken a second before")
+	}
+}
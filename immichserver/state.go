@@ -0,0 +1,192 @@
+package immichserver
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/oapi"
+	"github.com/google/uuid"
+)
+
+// stateVersion is the format of the state file, files of version 0 map the directory paths to their files.
+const stateVersion = 1
+
+type savedFile struct {
+	Sha1     string    `json:"sha1"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Inode    uint64    `json:"inode,omitempty"`
+	Uploaded bool      `json:"uploaded"`
+	Updated  bool      `json:"updated"`
+	AssetID  string    `json:"assetId"`
+	State    FileState `json:"state"`
+}
+
+type savedDirectory struct {
+	Path string `json:"path"`
+	// Profile is the server profile the asset IDs belong to
+	Profile string `json:"profile"`
+	// Options are the upload options of the uploaded assets, changes are applied to them on the next start
+	Options *savedOptions        `json:"options,omitempty"`
+	Files   map[string]savedFile `json:"files"`
+}
+
+type savedOptions struct {
+	Favorite   bool                 `json:"favorite"`
+	Visibility oapi.AssetVisibility `json:"visibility"`
+}
+
+type savedState struct {
+	Version     int              `json:"version"`
+	Directories []savedDirectory `json:"directories"`
+}
+
+// saveMu serializes SaveState, so an older state cannot replace a newer one.
+var saveMu sync.Mutex
+
+// SaveState writes the file caches of the directories of all servers to path, so a restarted daemon
+// does not upload unchanged files again and replaces changed files instead of duplicating them.
+// The file is replaced atomically, a crash while saving leaves the previous state.
+func SaveState(path string, servers []*ImmichServer) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	state := savedState{Version: stateVersion, Directories: make([]savedDirectory, 0)}
+	for _, server := range servers {
+		for _, dir := range server.Directories() {
+			options := dir.appliedOptions()
+			state.Directories = append(state.Directories, savedDirectory{
+				Path:    dir.path,
+				Profile: server.Profile,
+				Options: &savedOptions{Favorite: options.Favorite, Visibility: options.Visibility},
+				Files:   saveFiles(dir.files()),
+			})
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		// The data must be on disk before the rename, or a crash can leave an empty state file
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func saveFiles(cache map[string]FileStat) map[string]savedFile {
+	files := make(map[string]savedFile, len(cache))
+	for filePath, entry := range cache {
+		// Interrupted uploads are checkpointed as pending and retried on the next start
+		if entry.state == FileUploading {
+			entry.state = FilePending
+		}
+		files[filePath] = savedFile{
+			Sha1:     entry.HashHexString(),
+			Size:     entry.size,
+			ModTime:  entry.modTime,
+			Inode:    entry.inode,
+			Uploaded: entry.uploaded,
+			Updated:  entry.updated,
+			AssetID:  entry.uuid.String(),
+			State:    entry.state,
+		}
+	}
+	return files
+}
+
+// LoadState restores the file caches saved by SaveState for the directories of all servers.
+// Directories that moved to another server profile start over, their asset IDs belong to the old server.
+// A missing state file is not an error.
+func LoadState(path string, servers []*ImmichServer) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state, err := parseState(data)
+	if err != nil {
+		return err
+	}
+	for _, server := range servers {
+		for _, dir := range server.Directories() {
+			for _, saved := range state.Directories {
+				if saved.Path != dir.path {
+					continue
+				}
+				// Version 0 did not record the profile, its directories are assumed unchanged
+				if state.Version > 0 && saved.Profile != server.Profile {
+					slog.Warn("directory moved to another server, all files will be uploaded again", "dir", dir.path, "server", server.Profile, "old_server", saved.Profile)
+					continue
+				}
+				dir.restoreFiles(saved.Files, saved.Options)
+			}
+		}
+	}
+	return nil
+}
+
+func parseState(data []byte) (savedState, error) {
+	var state savedState
+	if err := json.Unmarshal(data, &state); err == nil && state.Version > 0 {
+		return state, nil
+	}
+	legacy := make(map[string]map[string]savedFile)
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return state, err
+	}
+	state = savedState{}
+	for dirPath, files := range legacy {
+		state.Directories = append(state.Directories, savedDirectory{Path: dirPath, Files: files})
+	}
+	return state, nil
+}
+
+func (i *ImageDirectory) restoreFiles(files map[string]savedFile, options *savedOptions) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if options != nil {
+		applied := UploadOptions{Favorite: options.Favorite, Visibility: options.Visibility}
+		if applied != i.options {
+			i.applied = &applied
+		}
+	}
+	for filePath, saved := range files {
+		hashSha1, err := hex.DecodeString(saved.Sha1)
+		if err != nil {
+			continue
+		}
+		assetUUID, _ := uuid.Parse(saved.AssetID)
+		i.contentCache[filePath] = FileStat{
+			modTime:  saved.ModTime,
+			size:     saved.Size,
+			inode:    saved.Inode,
+			hashSha1: hashSha1,
+			uploaded: saved.Uploaded,
+			updated:  saved.Updated,
+			uuid:     assetUUID,
+			state:    saved.State,
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package immichserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serverWith returns a server of the profile watching dirs, for tests that do not send requests.
+func serverWith(profile string, dirs ...*ImageDirectory) *ImmichServer {
+	server, err := NewImmichServer(NewSecuritySource(ServerConfig{}), "http://127.0.0.1:0", "test-device")
+	if err != nil {
+		panic(err)
+	}
+	server.Profile = profile
+	server.SetDirectories(dirs)
+	return server
+}
+
+func TestStateProfileChange(t *testing.T) {
+	fake, server := newTestServer(t)
+	server.Profile = "home"
+	path := t.TempDir()
+	writeFile(t, filepath.Join(path, "a.jpg"), "aaaa", time.Now().Add(-time.Hour))
+	dir := NewImageDirectory(path, false)
+	server.AddDirectory(&dir)
+	uploadDirectory(t, server, &dir, false)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	if err := SaveState(statePath, []*ImmichServer{server}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarted with the same profile, the file is known
+	restarted := NewImageDirectory(path, false)
+	if err := LoadState(statePath, []*ImmichServer{serverWith("home", &restarted)}); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := restarted.FileStatus(filepath.Join(path, "a.jpg")); status.State != FileUploaded {
+		t.Errorf("Expected a.jpg to be restored as uploaded, got %s", status.State)
+	}
+
+	// Restarted with the directory moved to another profile, the file is uploaded to the new server
+	otherFake, other := newTestServer(t)
+	other.Profile = "work"
+	moved := NewImageDirectory(path, false)
+	other.AddDirectory(&moved)
+	if err := LoadState(statePath, []*ImmichServer{other}); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := moved.FileStatus(filepath.Join(path, "a.jpg")); status.State != "" {
+		t.Errorf("Expected no state of the old profile, got %s", status.State)
+	}
+	uploadDirectory(t, other, &moved, false)
+	if len(otherFake.Assets()) != 1 || len(fake.Assets()) != 1 {
+		t.Errorf("Expected a.jpg on both servers, got %d and %d assets", len(fake.Assets()), len(otherFake.Assets()))
+	}
+	status, _ := moved.FileStatus(filepath.Join(path, "a.jpg"))
+	if _, ok := otherFake.Asset(status.AssetID); !ok {
+		t.Errorf("Expected the asset ID of the new server, got %s", status.AssetID)
+	}
+}
+
+func TestLoadLegacyState(t *testing.T) {
+	path := t.TempDir()
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	legacy := `{"` + path + `": {"` + filepath.Join(path, "a.jpg") + `": {"sha1": "70c881d4a26984ddce795f6f71817c9cf4480e79",
+		"size": 4, "uploaded": true, "assetId": "7b0a7e6c-3bd5-4b1d-9f3e-0e1f6f2e4a11", "state": "uploaded"}}}`
+	if err := os.WriteFile(statePath, []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dir := NewImageDirectory(path, false)
+	if err := LoadState(statePath, []*ImmichServer{serverWith("default", &dir)}); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := dir.FileStatus(filepath.Join(path, "a.jpg")); status.State != FileUploaded {
+		t.Errorf("Expected the file of the old state format to be restored, got %+v", status)
+	}
+}
+
+func TestStateCheckpoint(t *testing.T) {
+	_, server := newTestServer(t)
+	path := t.TempDir()
+	writeFile(t, filepath.Join(path, "a.jpg"), "aaaa", time.Now().Add(-time.Hour))
+	dir := NewImageDirectory(path, false)
+	server.AddDirectory(&dir)
+	if dir.StateChanged() {
+		t.Errorf("Expected no change of a new directory")
+	}
+	if _, err := dir.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !dir.StateChanged() || dir.StateChanged() {
+		t.Errorf("Expected a finished scan to be reported once")
+	}
+	dir.Upload(context.Background(), server, newTestPool(t), false)
+	if !dir.WaitForUploads(10 * time.Second) {
+		t.Fatal("uploads did not finish")
+	}
+	if !dir.StateChanged() {
+		t.Errorf("Expected a finished batch of uploads to be reported")
+	}
+
+	// Concurrent saves replace the file atomically and leave no temporary files behind
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			if err := SaveState(statePath, []*ImmichServer{server}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+	entries, _ := os.ReadDir(filepath.Dir(statePath))
+	if len(entries) != 1 || entries[0].Name() != "state.json" {
+		t.Errorf("Expected only the state file, got %v", entries)
+	}
+	restarted := NewImageDirectory(path, false)
+	if err := LoadState(statePath, []*ImmichServer{serverWith("", &restarted)}); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := restarted.FileStatus(filepath.Join(path, "a.jpg")); status.State != FileUploaded {
+		t.Errorf("Expected a.jpg to be restored as uploaded, got %s", status.State)
+	}
+}
@@ -0,0 +1,202 @@
+package immichserver
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/JonaEnz/immich-sync/takeout"
+	"github.com/google/uuid"
+)
+
+type ImportResult string
+
+const (
+	ImportUploaded ImportResult = "uploaded"
+	// ImportLinked files are identical to a file uploaded before, e.g. a photo in a year folder and an album
+	ImportLinked      ImportResult = "linked"
+	ImportUnsupported ImportResult = "skip-unsupported"
+	ImportFailed      ImportResult = "failed"
+)
+
+// ImportedFile is the outcome of importing a file of a Takeout export.
+type ImportedFile struct {
+	Name    string       `json:"name"`
+	Result  ImportResult `json:"result"`
+	Matched bool         `json:"matched"`
+	AssetID string       `json:"assetId,omitempty"`
+	Album   string       `json:"album,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// ImportReport lists the imported files of a Takeout export and the sidecars that matched no file.
+type ImportReport struct {
+	Files          []ImportedFile `json:"files"`
+	UnusedSidecars []string       `json:"unusedSidecars"`
+}
+
+// Unmatched returns the files that were imported without the metadata of a sidecar.
+func (r *ImportReport) Unmatched() []string {
+	var unmatched []string
+	for _, f := range r.Files {
+		if !f.Matched && f.Result != ImportUnsupported {
+			unmatched = append(unmatched, f.Name)
+		}
+	}
+	return unmatched
+}
+
+// ImportTakeout uploads the files of a Google Takeout export with the capture time, description, location
+// and favorite of their sidecars and adds them to the albums of their folders, creating missing albums.
+// Files without a sidecar are uploaded with their modification time.
+func (i *ImmichServer) ImportTakeout(ctx context.Context, export *takeout.Export, pool *WorkerPool) *ImportReport {
+	report := &ImportReport{Files: make([]ImportedFile, len(export.Files)), UnusedSidecars: export.UnusedSidecars}
+	albums := make(map[string]uuid.UUID)
+	albumErrs := make(map[string]error)
+	for _, f := range export.Files {
+		if f.Album == "" || albums[f.Album] != (uuid.UUID{}) || albumErrs[f.Album] != nil {
+			continue
+		}
+		albumUUID, err := i.albumCache.GetAlbumUUIDByName(ctx, i, f.Album)
+		if errors.Is(err, ErrAlbumNotFound) {
+			slog.Info("creating album", "op", "import", "album", f.Album)
+			albumUUID, err = i.CreateNewAlbum(ctx, f.Album)
+		}
+		if err != nil {
+			slog.Error("failed to find or create album", "op", "import", "album", f.Album, "err", err)
+			albumErrs[f.Album] = err
+			continue
+		}
+		albums[f.Album] = albumUUID
+	}
+
+	var wg sync.WaitGroup
+	for n, f := range export.Files {
+		report.Files[n] = ImportedFile{Name: f.Name, Matched: f.Metadata != nil, Album: f.Album}
+		wg.Add(1)
+		submitted := pool.Submit(JobRequest, 0, func() {
+			defer wg.Done()
+			i.importFile(ctx, f, &report.Files[n])
+		})
+		if !submitted {
+			wg.Done()
+			report.Files[n].Result, report.Files[n].Error = ImportFailed, "upload pool closed"
+		}
+	}
+	wg.Wait()
+
+	members := make(map[string][]uuid.UUID)
+	for n, f := range report.Files {
+		if f.Album == "" || f.AssetID == "" {
+			continue
+		}
+		if err := albumErrs[f.Album]; err != nil {
+			report.Files[n].Error = fmt.Sprintf("album not created: %s", err)
+			continue
+		}
+		asset := uuid.MustParse(f.AssetID)
+		if !slices.Contains(members[f.Album], asset) {
+			members[f.Album] = append(members[f.Album], asset)
+		}
+	}
+	for name, assets := range members {
+		if err := i.AddToAlbum(ctx, assets, albums[name]); err != nil {
+			slog.Error("failed to add files to album", "op", "import", "album", name, "err", err)
+			for n, f := range report.Files {
+				if f.Album == name && f.AssetID != "" {
+					report.Files[n].Error = fmt.Sprintf("not added to album: %s", err)
+				}
+			}
+		}
+	}
+	return report
+}
+
+func (i *ImmichServer) importFile(ctx context.Context, f *takeout.File, result *ImportedFile) {
+	if err := ctx.Err(); err != nil {
+		result.Result, result.Error = ImportFailed, err.Error()
+		return
+	}
+	name := path.Base(f.Name)
+	if _, err := mediaType(name); err != nil {
+		result.Result, result.Error = ImportUnsupported, err.Error()
+		return
+	}
+	h, err := hashTakeoutFile(f)
+	if err != nil {
+		result.Result, result.Error = ImportFailed, err.Error()
+		return
+	}
+
+	createdAt := f.ModTime
+	options := UploadOptions{}
+	metadata := AssetMetadata{}
+	if m := f.Metadata; m != nil {
+		if !m.TakenAt.IsZero() {
+			createdAt = m.TakenAt
+		}
+		options.Favorite = m.Favorite
+		if m.Description != "" {
+			metadata.Description = &m.Description
+		}
+		if m.HasLocation {
+			metadata.Latitude, metadata.Longitude = &m.Latitude, &m.Longitude
+		}
+	}
+	asset, deduped, err := i.uploadOnce(ctx, h, func() (uuid.UUID, error) {
+		r, err := f.Open()
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+		defer r.Close()
+		rawUUID, err := i.UploadReader(ctx, name, r, f.Size, h, createdAt, options)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+		return uuid.Parse(rawUUID)
+	})
+	if errors.Is(err, ErrUnsupportedFile) {
+		result.Result, result.Error = ImportUnsupported, err.Error()
+		return
+	}
+	if err != nil {
+		slog.Error("failed to import file", "op", "import", "path", f.Name, "err", err)
+		result.Result, result.Error = ImportFailed, err.Error()
+		return
+	}
+	result.AssetID = asset.String()
+	result.Result = ImportUploaded
+	if deduped {
+		result.Result = ImportLinked
+		if err = i.UpdateAssets(ctx, []uuid.UUID{asset}, UploadOptions{}, options); err != nil {
+			result.Error = fmt.Sprintf("favorite not set: %s", err)
+		}
+	}
+	if metadata != (AssetMetadata{}) {
+		if err = i.UpdateAsset(ctx, asset, metadata); err != nil {
+			slog.Warn("failed to set description and location", "op", "import", "path", f.Name, "asset_id", asset.String(), "err", err)
+			result.Error = fmt.Sprintf("description and location not set: %s", err)
+		}
+	}
+	slog.Info("imported file", "op", "import", "path", f.Name, "asset_id", asset.String(), "linked", deduped)
+}
+
+func hashTakeoutFile(f *takeout.File) (string, error) {
+	r, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha1.New()
+	if _, err = io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
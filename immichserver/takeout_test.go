@@ -0,0 +1,116 @@
+package immichserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/immichtest"
+	"github.com/JonaEnz/immich-sync/takeout"
+)
+
+func TestImportTakeout(t *testing.T) {
+	fake, server := newTestServer(t)
+	existing := fake.AddAlbum("Holiday")
+
+	root := t.TempDir()
+	photos := filepath.Join(root, "Takeout", "Google Photos")
+	sidecar := `{"title": "IMG_1.jpg", "description": "Beach", "photoTakenTime": {"timestamp": "1546300800"},
+		"geoData": {"latitude": 48.1, "longitude": 11.5}, "favorited": true}`
+	for name, data := range map[string]string{
+		"Photos from 2019/IMG_1.jpg":      "beach",
+		"Photos from 2019/IMG_1.jpg.json": sidecar,
+		"Photos from 2019/IMG_2.jpg":      "no sidecar",
+		"Photos from 2019/notes":          "unsupported",
+		"Holiday/IMG_1.jpg":               "beach",
+		"Holiday/IMG_1.jpg.json":          sidecar,
+		"Holiday/metadata.json":           `{"title": "Holiday"}`,
+		"Hike/IMG_3.jpg":                  "mountain",
+		"Hike/ORPHAN.jpg.json":            `{"title": "ORPHAN.jpg", "photoTakenTime": {"timestamp": "1"}}`,
+	} {
+		p := filepath.Join(photos, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	export, err := takeout.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Close()
+
+	report := server.ImportTakeout(context.Background(), export, newTestPool(t))
+
+	results := make(map[string]ImportResult)
+	for _, f := range report.Files {
+		results[filepath.Base(filepath.Dir(f.Name))+"/"+filepath.Base(f.Name)] = f.Result
+		if f.Error != "" && f.Result != ImportUnsupported {
+			t.Errorf("%s: unexpected error %s", f.Name, f.Error)
+		}
+	}
+	if results["Photos from 2019/notes"] != ImportUnsupported || results["Photos from 2019/IMG_2.jpg"] != ImportUploaded {
+		t.Errorf("Expected unsupported files to be skipped and files without sidecar uploaded, got %v", results)
+	}
+	if fake.Uploads() != 3 {
+		t.Errorf("Expected the photo in a year folder and an album to be uploaded once, got %d uploads", fake.Uploads())
+	}
+	if unmatched := report.Unmatched(); len(unmatched) != 2 {
+		t.Errorf("Expected IMG_2.jpg and IMG_3.jpg without sidecar, got %v", unmatched)
+	}
+	if len(report.UnusedSidecars) != 1 {
+		t.Errorf("Expected the orphaned sidecar in the report, got %v", report.UnusedSidecars)
+	}
+
+	asset, ok := fake.AssetByChecksum(writeFile(t, filepath.Join(t.TempDir(), "beach.jpg"), "beach", time.Now()))
+	if !ok {
+		t.Fatal("Expected IMG_1.jpg to be uploaded")
+	}
+	if !asset.FileCreatedAt.Equal(time.Unix(1546300800, 0)) {
+		t.Errorf("Expected the capture time of the sidecar, got %s", asset.FileCreatedAt)
+	}
+	if asset.Description != "Beach" || asset.Latitude == nil || *asset.Latitude != 48.1 || !asset.IsFavorite {
+		t.Errorf("Expected description, location and favorite of the sidecar, got %+v", asset)
+	}
+	if album, _ := fake.Album(existing); len(album.AssetIDs) != 1 || album.AssetIDs[0] != asset.ID {
+		t.Errorf("Expected IMG_1.jpg in the existing album, got %v", album.AssetIDs)
+	}
+	if hike, ok := fake.AlbumByName("Hike"); !ok || len(hike.AssetIDs) != 1 {
+		t.Errorf("Expected the album Hike to be created with IMG_3.jpg, got %+v", hike)
+	}
+}
+
+func TestImportTakeoutAlbumLookupFails(t *testing.T) {
+	fake, server := newTestServer(t)
+	fake.AlbumsError = &immichtest.StatusError{Code: http.StatusServiceUnavailable, Message: "maintenance"}
+	hike := filepath.Join(t.TempDir(), "Takeout", "Google Photos", "Hike")
+	if err := os.MkdirAll(hike, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(hike, "IMG_3.jpg"), "mountain", time.Now())
+	export, err := takeout.Open(filepath.Dir(filepath.Dir(filepath.Dir(hike))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Close()
+
+	report := server.ImportTakeout(context.Background(), export, newTestPool(t))
+	if _, ok := fake.AlbumByName("Hike"); ok {
+		t.Errorf("Expected no album to be created when the albums could not be listed")
+	}
+	if len(report.Files) != 1 || report.Files[0].Result != ImportUploaded || !strings.Contains(report.Files[0].Error, "album not created") {
+		t.Errorf("Expected the file uploaded with the album error in the report, got %+v", report.Files)
+	}
+
+	fake.AlbumsError = nil
+	if _, err := server.GetAlbumByUUIDOrName(context.Background(), "Hike"); !errors.Is(err, ErrAlbumNotFound) {
+		t.Errorf("Expected ErrAlbumNotFound for a missing album, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package immichserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// TransportConfig configures the HTTP connection to a server, e.g. for a reverse proxy with
+// a private CA, client certificates or access headers.
+type TransportConfig struct {
+	CAFile         string            `json:"ca_file" mapstructure:"ca_file"`
+	CertFile       string            `json:"cert_file" mapstructure:"cert_file"`
+	KeyFile        string            `json:"key_file" mapstructure:"key_file"`
+	Proxy          string            `json:"proxy" mapstructure:"proxy"`
+	ConnectTimeout time.Duration     `json:"connect_timeout" mapstructure:"connect_timeout"`
+	IdleTimeout    time.Duration     `json:"idle_timeout" mapstructure:"idle_timeout"`
+	Headers        map[string]string `json:"headers" mapstructure:"headers"`
+}
+
+// IsZero reports whether no transport option is set.
+func (t TransportConfig) IsZero() bool {
+	return t.CAFile == "" && t.CertFile == "" && t.KeyFile == "" && t.Proxy == "" &&
+		t.ConnectTimeout == 0 && t.IdleTimeout == 0 && len(t.Headers) == 0
+}
+
+// NewHTTPClient creates the HTTP client for a server. Without a proxy in the config
+// the proxy is taken from HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+func NewHTTPClient(cfg TransportConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.ConnectTimeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+		transport.TLSHandshakeTimeout = cfg.ConnectTimeout
+	}
+	if cfg.IdleTimeout > 0 {
+		transport.IdleConnTimeout = cfg.IdleTimeout
+	}
+	if len(cfg.Proxy) > 0 {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme '%s', expected http, https, socks5 or socks5h", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	var roundTripper http.RoundTripper = transport
+	if len(cfg.Headers) > 0 {
+		headers := make(http.Header, len(cfg.Headers))
+		for name, value := range cfg.Headers {
+			headers.Set(name, value)
+		}
+		roundTripper = &headerTransport{headers: headers, next: transport}
+	}
+	return &http.Client{Transport: roundTripper}, nil
+}
+
+func newTLSConfig(cfg TransportConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(cfg.CAFile) > 0 {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if (len(cfg.CertFile) > 0) != (len(cfg.KeyFile) > 0) {
+		return nil, errors.New("client certificates need both cert_file and key_file")
+	}
+	if len(cfg.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// headerTransport adds headers to every request, e.g. Cloudflare Access service tokens.
+type headerTransport struct {
+	headers http.Header
+	next    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+	return t.next.RoundTrip(req)
+}
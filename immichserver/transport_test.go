@@ -0,0 +1,54 @@
+package immichserver
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPClientCAAndHeaders(t *testing.T) {
+	var header string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("CF-Access-Client-Id")
+	}))
+	defer ts.Close()
+
+	client, err := NewHTTPClient(TransportConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Get(ts.URL); err == nil {
+		t.Errorf("Expected the self-signed certificate to be rejected without a CA bundle")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err = os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client, err = NewHTTPClient(TransportConfig{CAFile: caFile, Headers: map[string]string{"cf-access-client-id": "id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Get(ts.URL); err != nil {
+		t.Fatalf("Expected the certificate to be trusted with the CA bundle, got %v", err)
+	}
+	if header != "id" {
+		t.Errorf("Expected the extra header to be sent, got '%s'", header)
+	}
+}
+
+func TestHTTPClientInvalidConfig(t *testing.T) {
+	for name, cfg := range map[string]TransportConfig{
+		"cert without key": {CertFile: "cert.pem"},
+		"missing CA":       {CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"proxy scheme":     {Proxy: "ftp://proxy:21"},
+	} {
+		if _, err := NewHTTPClient(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
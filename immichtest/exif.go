@@ -0,0 +1,82 @@
+package immichtest
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// JPEG returns a minimal JPEG file with EXIF data: the capture time dateTimeOriginal (2006:01:02 15:04:05),
+// its time zone offsetTimeOriginal (+02:00) unless empty and, with gps, a position.
+func JPEG(dateTimeOriginal, offsetTimeOriginal string, gps bool) []byte {
+	type entry struct {
+		tag, typ uint16
+		count    uint32
+		data     []byte
+	}
+	le := binary.LittleEndian
+	var tiff bytes.Buffer
+	tiff.WriteString("II")
+	binary.Write(&tiff, le, uint16(42))
+	binary.Write(&tiff, le, uint32(8))
+	// writeIFD appends an IFD at the end of tiff, values longer than 4 bytes follow it
+	writeIFD := func(entries []entry) uint32 {
+		offset := uint32(tiff.Len())
+		dataOffset := offset + 2 + 12*uint32(len(entries)) + 4
+		var data bytes.Buffer
+		binary.Write(&tiff, le, uint16(len(entries)))
+		for _, e := range entries {
+			binary.Write(&tiff, le, e.tag)
+			binary.Write(&tiff, le, e.typ)
+			binary.Write(&tiff, le, e.count)
+			if len(e.data) <= 4 {
+				value := make([]byte, 4)
+				copy(value, e.data)
+				tiff.Write(value)
+				continue
+			}
+			binary.Write(&tiff, le, dataOffset+uint32(data.Len()))
+			data.Write(e.data)
+		}
+		binary.Write(&tiff, le, uint32(0))
+		tiff.Write(data.Bytes())
+		return offset
+	}
+	long := func(v uint32) []byte { return le.AppendUint32(nil, v) }
+	ascii := func(s string) []byte { return append([]byte(s), 0) }
+
+	// IFD0 points to the Exif and GPS IFDs written after it
+	ifd0Size := 2 + 12*2 + 4
+	exifEntries := []entry{{0x9003, 2, uint32(len(dateTimeOriginal) + 1), ascii(dateTimeOriginal)}}
+	if offsetTimeOriginal != "" {
+		exifEntries = append(exifEntries, entry{0x9011, 2, uint32(len(offsetTimeOriginal) + 1), ascii(offsetTimeOriginal)})
+	}
+	exifSize := 2 + 12*len(exifEntries) + 4
+	for _, e := range exifEntries {
+		exifSize += len(e.data)
+	}
+	exifOffset := uint32(8 + ifd0Size)
+	gpsOffset := exifOffset + uint32(exifSize)
+	ifd0 := []entry{{0x8769, 4, 1, long(exifOffset)}}
+	if gps {
+		ifd0 = append(ifd0, entry{0x8825, 4, 1, long(gpsOffset)})
+	} else {
+		// Keep the size of IFD0, 0x0131 is the Software tag
+		ifd0 = append(ifd0, entry{0x0131, 2, 2, ascii("x")})
+	}
+	writeIFD(ifd0)
+	writeIFD(exifEntries)
+	if gps {
+		latitude := make([]byte, 24)
+		le.PutUint32(latitude[0:], 48)
+		le.PutUint32(latitude[4:], 1)
+		writeIFD([]entry{{0x0002, 5, 3, latitude}})
+	}
+
+	var jpeg bytes.Buffer
+	jpeg.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&jpeg, binary.BigEndian, uint16(2+6+tiff.Len()))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiff.Bytes())
+	jpeg.Write([]byte{0xFF, 0xD9})
+	return jpeg.Bytes()
+}
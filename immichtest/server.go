@@ -0,0 +1,690 @@
+// Package immichtest provides a stateful in-memory Immich server for tests.
+// It implements the generated oapi.Handler for the endpoints used by immich-sync:
+// assets with checksums, albums, stacks, delta and full sync, metadata copy and trash.
+package immichtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JonaEnz/immich-sync/oapi"
+	"github.com/google/uuid"
+	"github.com/ogen-go/ogen/ogenerrors"
+)
+
+// Asset is an asset stored on the fake server.
+type Asset struct {
+	ID               string
+	Checksum         string // Hex encoded sha1
+	DeviceAssetID    string
+	DeviceID         string
+	OriginalFileName string
+	Data             []byte
+	FileCreatedAt    time.Time
+	UpdatedAt        time.Time
+	IsFavorite       bool
+	IsTrashed        bool
+	Visibility       oapi.AssetVisibility
+	Description      string
+	DateTimeOriginal string
+	TimeZone         string
+	Latitude         *float64
+	Longitude        *float64
+	StackID          string
+}
+
+// Album is an album stored on the fake server.
+type Album struct {
+	ID        string
+	Name      string
+	AssetIDs  []string
+	CreatedAt time.Time
+}
+
+type deletion struct {
+	id string
+	at time.Time
+}
+
+// Server is an in-memory Immich server. Its state can be inspected and seeded
+// with the exported methods while the code under test talks to URL.
+type Server struct {
+	oapi.UnimplementedHandler
+
+	// URL is the API url of the server, including /api
+	URL         string
+	APIKey      string
+	UserID      uuid.UUID
+	Version     oapi.ServerVersionResponseDto
+	Permissions []oapi.Permission
+	// AlbumsError makes listing the albums fail with it, e.g. a *StatusError
+	AlbumsError error
+
+	mu      sync.Mutex
+	assets  map[string]*Asset
+	albums  map[string]*Album
+	deleted []deletion
+	uploads int
+	http    *httptest.Server
+}
+
+// StatusError makes a handler respond with the status code instead of 500.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+// NewServer starts a fake server with API key "test-key", version 2.2.0 and all permissions.
+// It is closed when the test finishes.
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		APIKey:      "test-key",
+		UserID:      uuid.New(),
+		Version:     oapi.ServerVersionResponseDto{Major: 2, Minor: 2, Patch: 0},
+		Permissions: []oapi.Permission{oapi.PermissionAll},
+		assets:      make(map[string]*Asset),
+		albums:      make(map[string]*Album),
+	}
+	handler, err := oapi.NewServer(s, s, oapi.WithPathPrefix("/api"), oapi.WithErrorHandler(handleError))
+	if err != nil {
+		t.Fatalf("failed to create fake immich server: %s", err)
+	}
+	s.http = httptest.NewServer(addUploadMetadata(handler))
+	s.URL = s.http.URL + "/api"
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *Server) Close() {
+	s.http.Close()
+}
+
+// addUploadMetadata adds an empty "metadata" field to asset uploads. The generated server
+// requires the field, while Immich and the generated client treat it as optional.
+func addUploadMetadata(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if r.Method != http.MethodPost || r.URL.Path != "/api/assets" || err != nil || params["boundary"] == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		end := []byte("--" + params["boundary"] + "--")
+		if i := bytes.LastIndex(body, end); i >= 0 && !bytes.Contains(body, []byte(`name="metadata"`)) {
+			field := fmt.Sprintf("--%s\r\nContent-Disposition: form-data; name=\"metadata\"\r\n\r\n[]\r\n", params["boundary"])
+			body = slices.Concat(body[:i], []byte(field), body[i:])
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusErr.Code)
+		fmt.Fprintf(w, "{\"message\":%q}", statusErr.Message)
+		return
+	}
+	ogenerrors.DefaultErrorHandler(ctx, w, r, err)
+}
+
+// AddAsset stores an asset as if it had been uploaded and returns its id.
+func (s *Server) AddAsset(name string, data []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := sha1.Sum(data)
+	now := time.Now()
+	asset := &Asset{
+		ID:               uuid.NewString(),
+		Checksum:         hex.EncodeToString(h[:]),
+		OriginalFileName: name,
+		Data:             data,
+		FileCreatedAt:    now,
+		UpdatedAt:        now,
+		Visibility:       oapi.AssetVisibilityTimeline,
+	}
+	s.assets[asset.ID] = asset
+	return asset.ID
+}
+
+// AddAlbum creates an album containing the assets and returns its id.
+func (s *Server) AddAlbum(name string, assetIDs ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	album := &Album{ID: uuid.NewString(), Name: name, AssetIDs: slices.Clone(assetIDs), CreatedAt: time.Now()}
+	s.albums[album.ID] = album
+	return album.ID
+}
+
+// Asset returns a copy of the asset with the id.
+func (s *Server) Asset(id string) (Asset, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.assets[id]; ok {
+		return *a, true
+	}
+	return Asset{}, false
+}
+
+// AssetByChecksum returns a copy of the asset with the hex encoded sha1 checksum.
+func (s *Server) AssetByChecksum(checksum string) (Asset, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a := s.byChecksum(checksum); a != nil {
+		return *a, true
+	}
+	return Asset{}, false
+}
+
+// Assets returns copies of all assets, including trashed ones.
+func (s *Server) Assets() []Asset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]Asset, 0, len(s.assets))
+	for _, id := range slices.Sorted(maps.Keys(s.assets)) {
+		result = append(result, *s.assets[id])
+	}
+	return result
+}
+
+// Uploads returns the number of files uploaded, including duplicates.
+func (s *Server) Uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uploads
+}
+
+// Album returns a copy of the album with the id.
+func (s *Server) Album(id string) (Album, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.albums[id]; ok {
+		album := *a
+		album.AssetIDs = slices.Clone(a.AssetIDs)
+		return album, true
+	}
+	return Album{}, false
+}
+
+// AlbumByName returns a copy of the album with the name.
+func (s *Server) AlbumByName(name string) (Album, bool) {
+	s.mu.Lock()
+	for _, a := range s.albums {
+		if a.Name == name {
+			s.mu.Unlock()
+			return s.Album(a.ID)
+		}
+	}
+	s.mu.Unlock()
+	return Album{}, false
+}
+
+func (s *Server) byChecksum(checksum string) *Asset {
+	for _, a := range s.assets {
+		if a.Checksum == checksum {
+			return a
+		}
+	}
+	return nil
+}
+
+// normalizeChecksum converts a base64 encoded sha1 to hex, as the API accepts both.
+func normalizeChecksum(checksum string) string {
+	if len(checksum) == 2*sha1.Size {
+		return checksum
+	}
+	if raw, err := base64.StdEncoding.DecodeString(checksum); err == nil {
+		return hex.EncodeToString(raw)
+	}
+	return checksum
+}
+
+func (s *Server) owner() oapi.UserResponseDto {
+	return oapi.UserResponseDto{
+		AvatarColor: oapi.UserAvatarColorPrimary,
+		Email:       "test@example.com",
+		ID:          s.UserID.String(),
+		Name:        "test",
+	}
+}
+
+func (s *Server) assetDto(a *Asset) oapi.AssetResponseDto {
+	dto := oapi.AssetResponseDto{
+		Checksum:         base64.StdEncoding.EncodeToString(mustDecodeHex(a.Checksum)),
+		CreatedAt:        a.FileCreatedAt,
+		DeviceAssetId:    a.DeviceAssetID,
+		DeviceId:         a.DeviceID,
+		FileCreatedAt:    a.FileCreatedAt,
+		FileModifiedAt:   a.FileCreatedAt,
+		ID:               a.ID,
+		IsArchived:       a.Visibility == oapi.AssetVisibilityArchive,
+		IsFavorite:       a.IsFavorite,
+		IsTrashed:        a.IsTrashed,
+		LocalDateTime:    a.FileCreatedAt,
+		OriginalFileName: a.OriginalFileName,
+		OriginalPath:     "/upload/" + a.OriginalFileName,
+		OwnerId:          s.UserID.String(),
+		Type:             oapi.AssetTypeEnumIMAGE,
+		UpdatedAt:        a.UpdatedAt,
+		Visibility:       a.Visibility,
+	}
+	dto.Thumbhash.SetToNull()
+	return dto
+}
+
+func mustDecodeHex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+func (s *Server) albumDto(a *Album, withAssets bool) *oapi.AlbumResponseDto {
+	dto := &oapi.AlbumResponseDto{
+		AlbumName:  a.Name,
+		AssetCount: len(a.AssetIDs),
+		CreatedAt:  a.CreatedAt,
+		ID:         a.ID,
+		Owner:      s.owner(),
+		OwnerId:    s.UserID.String(),
+		UpdatedAt:  a.CreatedAt,
+	}
+	dto.AlbumThumbnailAssetId.SetToNull()
+	if withAssets {
+		for _, id := range a.AssetIDs {
+			if asset, ok := s.assets[id]; ok {
+				dto.Assets = append(dto.Assets, s.assetDto(asset))
+			}
+		}
+	}
+	return dto
+}
+
+func (s *Server) HandleAPIKey(ctx context.Context, operationName oapi.OperationName, t oapi.APIKey) (context.Context, error) {
+	if t.APIKey != s.APIKey {
+		return ctx, errors.New("invalid api key")
+	}
+	return ctx, nil
+}
+
+func (s *Server) HandleBearer(ctx context.Context, operationName oapi.OperationName, t oapi.Bearer) (context.Context, error) {
+	return ctx, errors.New("bearer authentication is not supported")
+}
+
+func (s *Server) HandleCookie(ctx context.Context, operationName oapi.OperationName, t oapi.Cookie) (context.Context, error) {
+	return ctx, errors.New("cookie authentication is not supported")
+}
+
+func (s *Server) PingServer(ctx context.Context) (*oapi.ServerPingResponse, error) {
+	return &oapi.ServerPingResponse{Res: "pong"}, nil
+}
+
+func (s *Server) GetServerVersion(ctx context.Context) (*oapi.ServerVersionResponseDto, error) {
+	version := s.Version
+	return &version, nil
+}
+
+func (s *Server) GetMyApiKey(ctx context.Context) (*oapi.APIKeyResponseDto, error) {
+	return &oapi.APIKeyResponseDto{ID: uuid.NewString(), Name: "test", Permissions: s.Permissions}, nil
+}
+
+func (s *Server) GetMyUser(ctx context.Context) (*oapi.UserAdminResponseDto, error) {
+	user := &oapi.UserAdminResponseDto{
+		AvatarColor: oapi.UserAvatarColorPrimary,
+		Email:       "test@example.com",
+		ID:          s.UserID.String(),
+		Name:        "test",
+		Status:      oapi.UserStatusActive,
+	}
+	user.DeletedAt.SetToNull()
+	user.License.SetToNull()
+	user.QuotaSizeInBytes.SetToNull()
+	user.QuotaUsageInBytes.SetToNull()
+	user.StorageLabel.SetToNull()
+	return user, nil
+}
+
+func (s *Server) UploadAsset(ctx context.Context, req *oapi.AssetMediaCreateDtoMultipart, params oapi.UploadAssetParams) (oapi.UploadAssetRes, error) {
+	data, err := io.ReadAll(req.AssetData.File)
+	if err != nil {
+		return nil, err
+	}
+	h := sha1.Sum(data)
+	checksum := hex.EncodeToString(h[:])
+	if params.XImmichChecksum.Set && normalizeChecksum(params.XImmichChecksum.Value) != checksum {
+		return nil, &StatusError{Code: http.StatusBadRequest, Message: "checksum does not match the uploaded data"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads += 1
+	// Like Immich, a checksum that already exists (even in the trash) is a duplicate
+	if existing := s.byChecksum(checksum); existing != nil {
+		return &oapi.UploadAssetOK{ID: existing.ID, Status: oapi.AssetMediaStatusDuplicate}, nil
+	}
+	asset := &Asset{
+		ID:               uuid.NewString(),
+		Checksum:         checksum,
+		DeviceAssetID:    req.DeviceAssetId,
+		DeviceID:         req.DeviceId,
+		OriginalFileName: req.AssetData.Name,
+		Data:             data,
+		FileCreatedAt:    req.FileCreatedAt,
+		UpdatedAt:        time.Now(),
+		IsFavorite:       req.IsFavorite.Or(false),
+		Visibility:       req.Visibility.Or(oapi.AssetVisibilityTimeline),
+	}
+	s.assets[asset.ID] = asset
+	return &oapi.UploadAssetCreated{ID: asset.ID, Status: oapi.AssetMediaStatusCreated}, nil
+}
+
+func (s *Server) CheckBulkUpload(ctx context.Context, req *oapi.AssetBulkUploadCheckDto) (*oapi.AssetBulkUploadCheckResponseDto, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	response := &oapi.AssetBulkUploadCheckResponseDto{Results: make([]oapi.AssetBulkUploadCheckResult, 0, len(req.Assets))}
+	for _, item := range req.Assets {
+		result := oapi.AssetBulkUploadCheckResult{ID: item.ID, Action: oapi.AssetBulkUploadCheckResultActionAccept}
+		if existing := s.byChecksum(normalizeChecksum(item.Checksum)); existing != nil {
+			result.Action = oapi.AssetBulkUploadCheckResultActionReject
+			result.Reason = oapi.NewOptAssetBulkUploadCheckResultReason(oapi.AssetBulkUploadCheckResultReasonDuplicate)
+			result.AssetId = oapi.NewOptString(existing.ID)
+			result.IsTrashed = oapi.NewOptBool(existing.IsTrashed)
+		}
+		response.Results = append(response.Results, result)
+	}
+	return response, nil
+}
+
+func (s *Server) DownloadAsset(ctx context.Context, params oapi.DownloadAssetParams) (oapi.DownloadAssetOK, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	asset, ok := s.assets[params.ID.String()]
+	if !ok {
+		return oapi.DownloadAssetOK{}, &StatusError{Code: http.StatusNotFound, Message: "asset not found"}
+	}
+	return oapi.DownloadAssetOK{Data: bytes.NewReader(asset.Data)}, nil
+}
+
+func (s *Server) UpdateAssets(ctx context.Context, req *oapi.AssetBulkUpdateDto) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range req.Ids {
+		if _, ok := s.assets[id.String()]; !ok {
+			return &StatusError{Code: http.StatusBadRequest, Message: fmt.Sprintf("asset %s not found", id)}
+		}
+	}
+	for _, id := range req.Ids {
+		a := s.assets[id.String()]
+		if v, ok := req.IsFavorite.Get(); ok {
+			a.IsFavorite = v
+		}
+		if v, ok := req.Visibility.Get(); ok {
+			a.Visibility = v
+		}
+		if v, ok := req.Description.Get(); ok {
+			a.Description = v
+		}
+		if v, ok := req.DateTimeOriginal.Get(); ok {
+			a.DateTimeOriginal = v
+		}
+		if v, ok := req.TimeZone.Get(); ok {
+			a.TimeZone = v
+		}
+		if v, ok := req.Latitude.Get(); ok {
+			a.Latitude = &v
+		}
+		if v, ok := req.Longitude.Get(); ok {
+			a.Longitude = &v
+		}
+		a.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *Server) UpdateAsset(ctx context.Context, req *oapi.UpdateAssetDto, params oapi.UpdateAssetParams) (*oapi.AssetResponseDto, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.assets[params.ID.String()]
+	if !ok {
+		return nil, &StatusError{Code: http.StatusBadRequest, Message: "asset not found"}
+	}
+	if v, ok := req.IsFavorite.Get(); ok {
+		a.IsFavorite = v
+	}
+	if v, ok := req.Visibility.Get(); ok {
+		a.Visibility = v
+	}
+	if v, ok := req.Description.Get(); ok {
+		a.Description = v
+	}
+	if v, ok := req.DateTimeOriginal.Get(); ok {
+		a.DateTimeOriginal = v
+	}
+	if v, ok := req.Latitude.Get(); ok {
+		a.Latitude = &v
+	}
+	if v, ok := req.Longitude.Get(); ok {
+		a.Longitude = &v
+	}
+	a.UpdatedAt = time.Now()
+	dto := s.assetDto(a)
+	return &dto, nil
+}
+
+// DeleteAssets moves the assets to the trash, or removes them with force.
+func (s *Server) DeleteAssets(ctx context.Context, req *oapi.AssetBulkDeleteDto) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, id := range req.Ids {
+		a, ok := s.assets[id.String()]
+		if !ok {
+			continue
+		}
+		if req.Force.Or(false) {
+			delete(s.assets, a.ID)
+			s.deleted = append(s.deleted, deletion{id: a.ID, at: now})
+			for _, album := range s.albums {
+				album.AssetIDs = slices.DeleteFunc(album.AssetIDs, func(assetID string) bool { return assetID == a.ID })
+			}
+			continue
+		}
+		a.IsTrashed = true
+		a.UpdatedAt = now
+	}
+	return nil
+}
+
+// CopyAsset copies albums, favorite and stack of the source asset to the target.
+func (s *Server) CopyAsset(ctx context.Context, req *oapi.AssetCopyDto) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	source, ok := s.assets[req.SourceId.String()]
+	target, ok2 := s.assets[req.TargetId.String()]
+	if !ok || !ok2 {
+		return &StatusError{Code: http.StatusBadRequest, Message: "asset not found"}
+	}
+	if req.Albums.Or(true) {
+		for _, album := range s.albums {
+			if slices.Contains(album.AssetIDs, source.ID) && !slices.Contains(album.AssetIDs, target.ID) {
+				album.AssetIDs = append(album.AssetIDs, target.ID)
+			}
+		}
+	}
+	if req.Favorite.Or(true) {
+		target.IsFavorite = source.IsFavorite
+	}
+	if req.Stack.Or(true) && len(source.StackID) > 0 {
+		target.StackID = source.StackID
+	}
+	target.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *Server) CreateStack(ctx context.Context, req *oapi.StackCreateDto) (*oapi.StackResponseDto, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(req.AssetIds) < 2 {
+		return nil, &StatusError{Code: http.StatusBadRequest, Message: "a stack needs at least two assets"}
+	}
+	// Assets that are already stacked are merged into the new stack
+	stackID := uuid.NewString()
+	response := &oapi.StackResponseDto{ID: stackID, PrimaryAssetId: req.AssetIds[0].String()}
+	for _, id := range req.AssetIds {
+		a, ok := s.assets[id.String()]
+		if !ok {
+			return nil, &StatusError{Code: http.StatusBadRequest, Message: "asset not found"}
+		}
+		if len(a.StackID) > 0 {
+			for _, other := range s.assets {
+				if other.StackID == a.StackID {
+					other.StackID = stackID
+				}
+			}
+		}
+		a.StackID = stackID
+	}
+	for _, a := range s.assets {
+		if a.StackID == stackID {
+			response.Assets = append(response.Assets, s.assetDto(a))
+		}
+	}
+	return response, nil
+}
+
+// StackMembers returns the ids of all assets in the stack, sorted.
+func (s *Server) StackMembers(stackID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := []string{}
+	for _, a := range s.assets {
+		if a.StackID == stackID {
+			members = append(members, a.ID)
+		}
+	}
+	slices.Sort(members)
+	return members
+}
+
+func (s *Server) CreateAlbum(ctx context.Context, req *oapi.CreateAlbumDto) (*oapi.AlbumResponseDto, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	album := &Album{ID: uuid.NewString(), Name: req.AlbumName, CreatedAt: time.Now()}
+	for _, id := range req.AssetIds {
+		album.AssetIDs = append(album.AssetIDs, id.String())
+	}
+	s.albums[album.ID] = album
+	return s.albumDto(album, true), nil
+}
+
+func (s *Server) GetAllAlbums(ctx context.Context, params oapi.GetAllAlbumsParams) ([]oapi.AlbumResponseDto, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.AlbumsError != nil {
+		return nil, s.AlbumsError
+	}
+	result := make([]oapi.AlbumResponseDto, 0, len(s.albums))
+	for _, id := range slices.Sorted(maps.Keys(s.albums)) {
+		album := s.albums[id]
+		if assetID, ok := params.AssetId.Get(); ok && !slices.Contains(album.AssetIDs, assetID.String()) {
+			continue
+		}
+		result = append(result, *s.albumDto(album, false))
+	}
+	return result, nil
+}
+
+func (s *Server) GetAlbumInfo(ctx context.Context, params oapi.GetAlbumInfoParams) (*oapi.AlbumResponseDto, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	album, ok := s.albums[params.ID.String()]
+	if !ok {
+		return nil, &StatusError{Code: http.StatusBadRequest, Message: "album not found"}
+	}
+	return s.albumDto(album, !params.WithoutAssets.Or(false)), nil
+}
+
+func (s *Server) AddAssetsToAlbum(ctx context.Context, req *oapi.BulkIdsDto, params oapi.AddAssetsToAlbumParams) ([]oapi.BulkIdResponseDto, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	album, ok := s.albums[params.ID.String()]
+	if !ok {
+		return nil, &StatusError{Code: http.StatusBadRequest, Message: "album not found"}
+	}
+	result := make([]oapi.BulkIdResponseDto, 0, len(req.Ids))
+	for _, id := range req.Ids {
+		response := oapi.BulkIdResponseDto{ID: id.String(), Success: true}
+		switch {
+		case s.assets[id.String()] == nil:
+			response.Success = false
+			response.Error = oapi.NewOptBulkIdResponseDtoError(oapi.BulkIdResponseDtoErrorNotFound)
+		case slices.Contains(album.AssetIDs, id.String()):
+			response.Success = false
+			response.Error = oapi.NewOptBulkIdResponseDtoError(oapi.BulkIdResponseDtoErrorDuplicate)
+		default:
+			album.AssetIDs = append(album.AssetIDs, id.String())
+		}
+		result = append(result, response)
+	}
+	return result, nil
+}
+
+// GetDeltaSync returns the assets changed and deleted after UpdatedAfter.
+func (s *Server) GetDeltaSync(ctx context.Context, req *oapi.AssetDeltaSyncDto) (*oapi.AssetDeltaSyncResponseDto, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	response := &oapi.AssetDeltaSyncResponseDto{Deleted: []string{}, Upserted: []oapi.AssetResponseDto{}}
+	for _, id := range slices.Sorted(maps.Keys(s.assets)) {
+		if a := s.assets[id]; a.UpdatedAt.After(req.UpdatedAfter) {
+			response.Upserted = append(response.Upserted, s.assetDto(a))
+		}
+	}
+	for _, d := range s.deleted {
+		if d.at.After(req.UpdatedAfter) {
+			response.Deleted = append(response.Deleted, d.id)
+		}
+	}
+	return response, nil
+}
+
+// GetFullSyncForUser pages through all assets updated until UpdatedUntil, ordered by id.
+func (s *Server) GetFullSyncForUser(ctx context.Context, req *oapi.AssetFullSyncDto) ([]oapi.AssetResponseDto, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]oapi.AssetResponseDto, 0)
+	for _, id := range slices.Sorted(maps.Keys(s.assets)) {
+		if lastID, ok := req.LastId.Get(); ok && id <= lastID.String() {
+			continue
+		}
+		if a := s.assets[id]; !a.UpdatedAt.After(req.UpdatedUntil) {
+			result = append(result, s.assetDto(a))
+		}
+		if len(result) == req.Limit {
+			break
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,55 @@
+// Package schedule computes the run times of periodic jobs from an interval or a cron expression.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the first run time after a given time.
+type Schedule interface {
+	// Next returns the first run time after t, the zero time if there is none.
+	Next(t time.Time) time.Time
+	// String returns the schedule in a form accepted by Parse.
+	String() string
+}
+
+// Parse reads a schedule: a number of minutes (15), a duration (1h30m),
+// a cron expression with five fields (30 3 * * *) or one of @hourly, @daily, @weekly, @monthly and @yearly.
+// "0" and "off" disable the schedule, Parse returns nil then.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "0" || spec == "off" {
+		return nil, nil
+	}
+	if minutes, err := strconv.Atoi(spec); err == nil {
+		if minutes < 0 {
+			return nil, fmt.Errorf("invalid schedule '%s', the interval needs to be positive", spec)
+		}
+		return Interval(time.Duration(minutes) * time.Minute), nil
+	}
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval < time.Second {
+			return nil, fmt.Errorf("invalid schedule '%s', the interval needs to be at least 1s", spec)
+		}
+		return Interval(interval), nil
+	}
+	c, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Interval runs every d, counted from the previous run.
+type Interval time.Duration
+
+func (d Interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(d))
+}
+
+func (d Interval) String() string {
+	return time.Duration(d).String()
+}
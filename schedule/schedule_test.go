@@ -0,0 +1,68 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	start := time.Date(2025, time.March, 14, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"15", start.Add(15 * time.Minute)},
+		{"1h30m", start.Add(90 * time.Minute)},
+		{"*/15 * * * *", time.Date(2025, time.March, 14, 10, 15, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2025, time.March, 15, 3, 30, 0, 0, time.UTC)},
+		{"0 12 * * mon-fri", time.Date(2025, time.March, 14, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2025, time.March, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, time.March, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2025, time.March, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2025, time.March, 21, 0, 0, 0, 0, time.UTC)}, // 13th or a Friday
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2025, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"0-10/5 8 * * *", time.Date(2025, time.March, 15, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.spec, err)
+			continue
+		}
+		if next := s.Next(start); !next.Equal(test.next) {
+			t.Errorf("%s: expected next run at %s, got %s", test.spec, test.next, next)
+		}
+		if again, err := Parse(s.String()); err != nil || !again.Next(start).Equal(test.next) {
+			t.Errorf("%s: expected String '%s' to parse to the same schedule, got %v", test.spec, s.String(), err)
+		}
+	}
+}
+
+func TestParseDisabled(t *testing.T) {
+	for _, spec := range []string{"", "0", "off"} {
+		if s, err := Parse(spec); s != nil || err != nil {
+			t.Errorf("'%s': expected a disabled schedule, got %v, %v", spec, s, err)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"-5", "10ms", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "5-1 * * * *", "*/0 * * * *", "* * * * funday"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("'%s': expected an error", spec)
+		}
+	}
+}
+
+func TestCronNeverMatches(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Expected no run on February 30th, got %s", next)
+	}
+}
@@ -3,7 +3,6 @@ package socketrpc
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"sync"
 	"time"
@@ -36,30 +35,41 @@ func (c *RPCClient) Close() {
 }
 
 func (c *RPCClient) SendMessage(cmd byte, jsonMsg string) (string, error) {
+	return c.SendMessageTimeout(cmd, jsonMsg, ResponseTimout)
+}
+
+// SendMessageTimeout sends a message and waits up to timeout for the answer, a zero timeout waits
+// until the command finished. Closing the connection while waiting cancels the command in the daemon.
+func (c *RPCClient) SendMessageTimeout(cmd byte, jsonMsg string, timeout time.Duration) (string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.conn == nil {
-		log.Fatal("RPC connection does not exist anymore.")
+		return "", errors.New("RPC connection does not exist anymore")
 	}
-	c.conn.Write([]byte{cmd})
-	if jsonMsg != "" {
-		c.conn.Write([]byte(jsonMsg))
+	if err := writeMessage(c.conn, append([]byte{cmd}, []byte(jsonMsg)...)); err != nil {
+		return "", err
 	}
 
-	c.conn.SetReadDeadline(time.Now().Add(ResponseTimout))
-	buf := make([]byte, 1<<14)
-	n, err := c.conn.Read(buf)
-	if err != nil || n == 0 {
-		log.Fatal(err)
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	c.conn.SetReadDeadline(deadline)
+	answer, err := readMessage(c.conn)
+	if err != nil {
+		return "", fmt.Errorf("failed to read RPC response: %w", err)
+	}
+	if len(answer) == 0 {
+		return "", errors.New("empty RPC response")
 	}
-	if buf[0] != ErrOk {
+	if answer[0] != ErrOk {
 		e := ""
-		if n > 1 {
-			e = fmt.Sprintf("Error code %x: %s\n", buf[0], string(buf[1:]))
+		if len(answer) > 1 {
+			e = fmt.Sprintf("Error code %x: %s\n", answer[0], string(answer[1:]))
 		} else {
-			e = fmt.Sprintf("Call returned error code %x\n", buf[0])
+			e = fmt.Sprintf("Call returned error code %x\n", answer[0])
 		}
 		return "", errors.New(e)
 	}
-	return string(buf[1:n]), nil
+	return string(answer[1:]), nil
 }
@@ -1,16 +1,27 @@
 package socketrpc
 
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
 var (
 	socketAddr        = "/tmp/immich-sync.sock"
 	CmdStatus         = byte(0x1)
 	CmdScanAll        = byte(0x2)
+	CmdReload         = byte(0x3)
+	CmdDryRun         = byte(0x4)
 	CmdUploadFile     = byte(0x5)
 	CmdAddDir         = byte(0x10)
 	CmdRmDir          = byte(0x11)
+	CmdFixDates       = byte(0x12)
 	CmdCreateAlbum    = byte(0x20)
 	CmdShowAlbum      = byte(0x21)
 	CmdAddAlbum       = byte(0x22)
 	CmdDownloadAlbum  = byte(0x23)
+	CmdLogLevel       = byte(0x30)
 	CmdExit           = byte(0xFF)
 	ErrOk             = byte(0x0)
 	ErrGeneric        = byte(0x1)
@@ -21,6 +32,76 @@ var (
 )
 
 type UploadFileRequest struct {
-	Paths []string `json:"paths"`
-	Album string   `json:"album"`
+	Paths  []string `json:"paths"`
+	Album  string   `json:"album"`
+	Server string   `json:"server"`
+	// GPX are GPX files and directories to geotag the uploaded files from
+	GPX       []string      `json:"gpx,omitempty"`
+	GPXOffset time.Duration `json:"gpxOffset,omitempty"`
+}
+
+// AddDirRequest watches a directory and uploads it to the server profile Server, the default profile if empty.
+type AddDirRequest struct {
+	Path   string `json:"path"`
+	Server string `json:"server,omitempty"`
+}
+
+type CreateAlbumRequest struct {
+	Album  string `json:"album"`
+	Server string `json:"server,omitempty"`
+}
+
+// AddToAlbumRequest adds the asset of the watched file Path to an album of the server that watches it.
+type AddToAlbumRequest struct {
+	Path  string `json:"path"`
+	Album string `json:"album"`
+}
+
+// DownloadAlbumRequest downloads the assets of an album to the directory Path.
+type DownloadAlbumRequest struct {
+	Album  string `json:"album"`
+	Path   string `json:"path"`
+	Server string `json:"server,omitempty"`
+}
+
+// FixDatesRequest corrects the capture times of the files uploaded from a watched directory.
+// TimeOffset and Timezone override the settings of the directory if set.
+type FixDatesRequest struct {
+	Path       string  `json:"path"`
+	TimeOffset *string `json:"timeOffset,omitempty"`
+	Timezone   *string `json:"timezone,omitempty"`
+}
+
+// SocketAddr returns the path of the daemon socket.
+func SocketAddr() string {
+	return socketAddr
+}
+
+// maxMessageSize limits the size of a single message, a larger length is a protocol error.
+const maxMessageSize = 64 << 20
+
+// writeMessage writes a message prefixed with its length, so answers of any size arrive complete.
+func writeMessage(w io.Writer, data []byte) error {
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readMessage reads a message written by writeMessage. io.EOF means the connection was closed between messages.
+func readMessage(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxMessageSize {
+		return nil, fmt.Errorf("RPC message of %d bytes exceeds the limit of %d bytes", size, maxMessageSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
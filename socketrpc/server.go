@@ -1,93 +1,179 @@
 package socketrpc
 
 import (
+	"context"
+	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"sync"
+	"time"
+)
+
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
 )
 
 type RPCServer struct {
 	mu        *sync.RWMutex
 	exit      chan interface{}
-	callbacks map[byte]func(string) (byte, string)
+	socket    net.Listener
+	ownSocket bool
+	cancel    context.CancelFunc
+	callbacks map[byte]func(context.Context, string) (byte, string)
 }
 
 func NewRPCServer() RPCServer {
 	s := RPCServer{
 		mu:        &sync.RWMutex{},
 		exit:      nil,
-		callbacks: make(map[byte]func(string) (byte, string)),
+		callbacks: make(map[byte]func(context.Context, string) (byte, string)),
 	}
 	s.callbacks[CmdScanAll] = nil
 	s.callbacks[CmdAddDir] = nil
 	return s
 }
 
-func (s *RPCServer) Start() {
-	s.mu.Lock()
-	s.exit = make(chan any)
-	s.mu.Unlock()
-
+// Start creates the unix socket and starts serving requests on it.
+func (s *RPCServer) Start() error {
 	if err := os.RemoveAll(socketAddr); err != nil {
-		log.Fatal(err)
+		return err
 	}
 	socket, err := net.Listen("unix", socketAddr)
-	os.Chmod(socketAddr, 0o777)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	go func(socket net.Listener) {
+	os.Chmod(socketAddr, 0o777)
+	s.StartWithListener(socket)
+	s.mu.Lock()
+	s.ownSocket = true
+	s.mu.Unlock()
+	return nil
+}
+
+// StartWithListener serves requests on an existing socket, e.g. one passed by systemd socket activation.
+func (s *RPCServer) StartWithListener(socket net.Listener) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.exit = make(chan any)
+	s.cancel = cancel
+	s.socket = socket
+	s.mu.Unlock()
+
+	go func(socket net.Listener, exit chan any) {
+		var delay time.Duration
 		for {
-			conn, _ := socket.Accept()
-			go func(conn net.Conn) {
-				for {
-					defer conn.Close()
-					buf := make([]byte, 1<<14)
-					n, err := conn.Read(buf)
-					if err == io.EOF {
-						return
-					}
-					if err != nil || n == 0 {
-						conn.Write([]byte{ErrGeneric})
-						log.Fatal(err)
-					}
-					cmd := buf[0]
-					message := string(buf[1:n])
-					callbackFunc, ok := s.callbacks[cmd]
-					if !ok {
-						conn.Write([]byte{ErrUnknownCmd})
-						return
-					}
-					if callbackFunc == nil {
-						conn.Write([]byte{ErrUnsupportedCmd})
-						return
-					}
-					result, resultString := callbackFunc(message)
-					conn.Write([]byte{result})
-					conn.Write([]byte(resultString))
+			conn, err := socket.Accept()
+			if err != nil {
+				select {
+				case <-exit:
+					return
+				default:
+				}
+				if errors.Is(err, net.ErrClosed) {
+					slog.Error("RPC socket closed, no longer accepting connections", "err", err)
+					return
 				}
-			}(conn)
-			select {
-			case <-s.exit:
-				return
-			default:
+				// Back off on errors like too many open files instead of spinning
+				delay = min(max(2*delay, minAcceptDelay), maxAcceptDelay)
+				slog.Error("failed to accept RPC connection", "err", err, "retry", delay)
+				select {
+				case <-exit:
+					return
+				case <-time.After(delay):
+				}
+				continue
 			}
+			delay = 0
+			go s.serve(ctx, conn)
 		}
-	}(socket)
-	log.Printf("Started RPC server on socket '%s'", socketAddr)
+	}(socket, s.exit)
+	slog.Info("started RPC server", "socket", socket.Addr().String())
+}
+
+type readResult struct {
+	message []byte
+	err     error
 }
 
+// serve handles the requests of one connection. The context passed to a callback is cancelled
+// when the client disconnects while the callback is running or when the server is closed.
+func (s *RPCServer) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	read := func() readResult {
+		message, err := readMessage(conn)
+		return readResult{message, err}
+	}
+	next := read()
+	for {
+		if next.err == io.EOF {
+			return
+		}
+		if next.err != nil || len(next.message) == 0 {
+			writeMessage(conn, []byte{ErrGeneric})
+			slog.Error("failed to read RPC message", "err", next.err)
+			return
+		}
+		cmd := next.message[0]
+		message := string(next.message[1:])
+		s.mu.RLock()
+		callbackFunc, ok := s.callbacks[cmd]
+		s.mu.RUnlock()
+		if !ok {
+			slog.Warn("unknown RPC command", "cmd", cmd)
+			writeMessage(conn, []byte{ErrUnknownCmd})
+			return
+		}
+		if callbackFunc == nil {
+			writeMessage(conn, []byte{ErrUnsupportedCmd})
+			return
+		}
+		slog.Debug("handling RPC command", "cmd", cmd)
+		callCtx, cancel := context.WithCancel(ctx)
+		// Keep reading while the callback runs, a failed read means the client is gone
+		nextRead := make(chan readResult, 1)
+		go func() {
+			r := read()
+			if r.err != nil {
+				cancel()
+			}
+			nextRead <- r
+		}()
+		result, resultString := callbackFunc(callCtx, message)
+		if callCtx.Err() != nil && ctx.Err() == nil {
+			slog.Info("RPC client disconnected, cancelled command", "cmd", cmd)
+		}
+		cancel()
+		writeMessage(conn, append([]byte{result}, []byte(resultString)...))
+		next = <-nextRead
+	}
+}
+
+// Close stops accepting connections and removes the socket if it was created by Start.
 func (s *RPCServer) Close() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.exit != nil {
-		close(s.exit)
+	if s.exit == nil {
+		return
+	}
+	select {
+	case <-s.exit:
+		return // Already closed
+	default:
+	}
+	close(s.exit)
+	s.cancel()
+	s.socket.Close()
+	if s.ownSocket {
+		os.Remove(socketAddr)
 	}
 }
 
-func (s *RPCServer) RegisterCallback(cmd byte, f func(string) (byte, string)) error {
+// RegisterCallback sets the function handling cmd. Its context is cancelled when the client
+// disconnects before the answer is sent or when the server is closed.
+func (s *RPCServer) RegisterCallback(cmd byte, f func(context.Context, string) (byte, string)) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.callbacks[cmd] = f
@@ -97,3 +183,10 @@ func (s *RPCServer) RegisterCallback(cmd byte, f func(string) (byte, string)) er
 func (s *RPCServer) WaitForExit() {
 	<-s.exit
 }
+
+// Exited returns a channel that is closed once the server is closed.
+func (s *RPCServer) Exited() <-chan any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.exit
+}
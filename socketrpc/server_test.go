@@ -0,0 +1,117 @@
+package socketrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCallbackCancelledOnDisconnect(t *testing.T) {
+	socket, err := net.Listen("unix", filepath.Join(t.TempDir(), "test.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewRPCServer()
+	cancelled := make(chan bool, 1)
+	s.RegisterCallback(CmdDownloadAlbum, func(ctx context.Context, _ string) (byte, string) {
+		select {
+		case <-ctx.Done():
+			cancelled <- true
+		case <-time.After(5 * time.Second):
+			cancelled <- false
+		}
+		return ErrOk, ""
+	})
+	s.StartWithListener(socket)
+	defer s.Close()
+
+	conn, err := net.Dial("unix", socket.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeMessage(conn, []byte{CmdDownloadAlbum})
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	if !<-cancelled {
+		t.Errorf("Expected the callback to be cancelled when the client disconnects")
+	}
+}
+
+func TestLargeStatus(t *testing.T) {
+	oldAddr := socketAddr
+	socketAddr = filepath.Join(t.TempDir(), "test.sock")
+	t.Cleanup(func() { socketAddr = oldAddr })
+	// The status of many directories with long errors is larger than a single read
+	directories := make([]map[string]string, 2000)
+	for n := range directories {
+		directories[n] = map[string]string{"path": fmt.Sprintf("/photos/%d", n), "lastError": strings.Repeat("x", 100)}
+	}
+	status, _ := json.Marshal(directories)
+	s := NewRPCServer()
+	s.RegisterCallback(CmdStatus, func(_ context.Context, path string) (byte, string) {
+		return ErrOk, string(status) + path
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	client, err := NewRPCClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for range 2 { // Messages on the same connection stay separate
+		answer, err := client.SendMessage(CmdStatus, "")
+		if err != nil || answer != string(status) {
+			t.Fatalf("Expected the complete status of %d bytes, got %d bytes (%v)", len(status), len(answer), err)
+		}
+	}
+	long := strings.Repeat("/photos", 10000)
+	if answer, err := client.SendMessage(CmdStatus, long); err != nil || !strings.HasSuffix(answer, long) {
+		t.Errorf("Expected the complete request to arrive, got %d bytes (%v)", len(answer), err)
+	}
+}
+
+// failingListener fails every Accept with err and counts the calls.
+type failingListener struct {
+	net.Listener
+	err     error
+	accepts atomic.Int64
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	return nil, l.err
+}
+
+func TestAcceptErrors(t *testing.T) {
+	socket, err := net.Listen("unix", filepath.Join(t.TempDir(), "test.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := &failingListener{Listener: socket, err: errors.New("too many open files")}
+	s := NewRPCServer()
+	s.StartWithListener(failing)
+	time.Sleep(100 * time.Millisecond)
+	s.Close()
+	if accepts := failing.accepts.Load(); accepts > 10 {
+		t.Errorf("Expected failed accepts to back off, got %d accepts in 100ms", accepts)
+	}
+
+	closed := &failingListener{Listener: socket, err: net.ErrClosed}
+	s = NewRPCServer()
+	s.StartWithListener(closed)
+	defer s.Close()
+	time.Sleep(50 * time.Millisecond)
+	if accepts := closed.accepts.Load(); accepts != 1 {
+		t.Errorf("Expected a closed listener to stop the accept loop, got %d accepts", accepts)
+	}
+}
@@ -0,0 +1,111 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify("READY=1"); sent || err != nil {
+		t.Errorf("Expected nothing to be sent without NOTIFY_SOCKET, got %v, %v", sent, err)
+	}
+
+	for _, name := range []string{filepath.Join(t.TempDir(), "notify.sock"), "@immich-sync-test-" + strconv.Itoa(os.Getpid())} {
+		addr := name
+		if addr[0] == '@' {
+			addr = "\x00" + addr[1:]
+		}
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("NOTIFY_SOCKET", name)
+		if sent, err := Notify("WATCHDOG=1\nSTATUS=ok"); !sent || err != nil {
+			t.Errorf("Expected the state to be sent to %s, got %v, %v", name, sent, err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "WATCHDOG=1\nSTATUS=ok" {
+			t.Errorf("Expected the state on %s, got %q, %v", name, buf[:n], err)
+		}
+		conn.Close()
+	}
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	if sent, err := Notify("READY=1"); sent || err == nil {
+		t.Errorf("Expected an error for a missing socket, got %v, %v", sent, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	cases := []struct {
+		usec, pid string
+		expected  time.Duration
+	}{
+		{"", "", 0},
+		{"invalid", "", 0},
+		{"-1", "", 0},
+		{"30000000", "", 30 * time.Second},
+		{"30000000", pid, 30 * time.Second},
+		{"30000000", "1", 0},
+	}
+	for _, c := range cases {
+		t.Setenv("WATCHDOG_USEC", c.usec)
+		t.Setenv("WATCHDOG_PID", c.pid)
+		if interval := WatchdogInterval(); interval != c.expected {
+			t.Errorf("Expected %s for WATCHDOG_USEC=%s WATCHDOG_PID=%s, got %s", c.expected, c.usec, c.pid, interval)
+		}
+	}
+}
+
+func TestListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	if listeners, err := Listeners(); len(listeners) != 0 || err != nil {
+		t.Errorf("Expected no sockets passed to another process, got %v, %v", listeners, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Errorf("Expected the environment to be unset")
+	}
+
+	socket, err := net.Listen("unix", filepath.Join(t.TempDir(), "test.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	file, err := socket.(*net.UnixListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	// listeners takes over the file descriptor, pass a copy nobody else closes
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	passed, err := listeners(fd)
+	if err != nil || len(passed) != 1 {
+		t.Fatalf("Expected the passed socket, got %v, %v", passed, err)
+	}
+	defer passed[0].Close()
+	go func() {
+		if conn, err := net.Dial("unix", socket.Addr().String()); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := passed[0].Accept()
+	if err != nil {
+		t.Fatalf("Expected to accept on the passed socket, got %s", err)
+	}
+	conn.Close()
+}
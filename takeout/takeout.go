@@ -0,0 +1,336 @@
+// Package takeout reads Google Photos exports of Google Takeout and matches the media files to their JSON sidecars.
+package takeout
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata is what Google Photos knows about a media file, read from its sidecar.
+type Metadata struct {
+	Title       string
+	Description string
+	// TakenAt is the capture time, zero if unknown
+	TakenAt     time.Time
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+	Favorite    bool
+}
+
+// File is a media file of the export.
+type File struct {
+	// Name is the slash separated path of the file in the export
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// Album is the name of the album of the folder of the file, empty for the folders by year
+	Album string
+	// Metadata is nil if no sidecar matched the file
+	Metadata *Metadata
+	open     func() (io.ReadCloser, error)
+}
+
+// Open returns the content of the file.
+func (f *File) Open() (io.ReadCloser, error) {
+	return f.open()
+}
+
+// Export is a Google Takeout export, read from folders and zip archives.
+// Takeout splits large exports into several archives, a sidecar may be in another archive than its media file.
+type Export struct {
+	Files []*File
+	// UnusedSidecars are the sidecars that matched no media file
+	UnusedSidecars []string
+	closers        []io.Closer
+}
+
+type entry struct {
+	name    string
+	size    int64
+	modTime time.Time
+	open    func() (io.ReadCloser, error)
+}
+
+type sidecar struct {
+	name     string
+	metadata Metadata
+	used     bool
+}
+
+// sidecarJSON is the part of a sidecar used for the import, timestamps are seconds since the epoch.
+type sidecarJSON struct {
+	Title          string `json:"title"`
+	Description    string `json:"description"`
+	PhotoTakenTime *struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+	GeoData     geoData `json:"geoData"`
+	GeoDataExif geoData `json:"geoDataExif"`
+	Favorited   bool    `json:"favorited"`
+}
+
+type geoData struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// maxSidecarSize skips JSON files too large to be a sidecar.
+const maxSidecarSize = 1 << 20
+
+var (
+	yearFolder = regexp.MustCompile(`^Photos from \d{4}$`)
+	duplicate  = regexp.MustCompile(`^(.*)(\(\d+\))$`)
+)
+
+// Open reads the exports at paths, each a folder or a zip archive.
+func Open(paths ...string) (*Export, error) {
+	export := &Export{}
+	var entries []entry
+	for _, p := range paths {
+		stat, err := os.Stat(p)
+		if err != nil {
+			export.Close()
+			return nil, err
+		}
+		var found []entry
+		if stat.IsDir() {
+			found, err = readFolder(p)
+		} else {
+			var archive *zip.ReadCloser
+			archive, err = zip.OpenReader(p)
+			if err == nil {
+				export.closers = append(export.closers, archive)
+				found = readArchive(&archive.Reader)
+			}
+		}
+		if err != nil {
+			export.Close()
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	if err := export.match(entries); err != nil {
+		export.Close()
+		return nil, err
+	}
+	return export, nil
+}
+
+// Close closes the zip archives of the export.
+func (e *Export) Close() error {
+	var errs []error
+	for _, c := range e.closers {
+		errs = append(errs, c.Close())
+	}
+	e.closers = nil
+	return errors.Join(errs...)
+}
+
+func readFolder(root string) ([]entry, error) {
+	var entries []entry
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{
+			name:    filepath.ToSlash(rel),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			open:    func() (io.ReadCloser, error) { return os.Open(p) },
+		})
+		return nil
+	})
+	return entries, err
+}
+
+func readArchive(archive *zip.Reader) []entry {
+	var entries []entry
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		entries = append(entries, entry{
+			name:    path.Clean(f.Name),
+			size:    int64(f.UncompressedSize64),
+			modTime: f.Modified,
+			open:    f.Open,
+		})
+	}
+	return entries
+}
+
+// match groups the entries by folder and matches the media files of each folder to its sidecars.
+func (e *Export) match(entries []entry) error {
+	folders := make(map[string][]entry)
+	for _, en := range entries {
+		dir := path.Dir(en.name)
+		folders[dir] = append(folders[dir], en)
+	}
+	for _, dir := range slices.Sorted(maps.Keys(folders)) {
+		album := ""
+		if name := path.Base(dir); dir != "." && !yearFolder.MatchString(name) && name != "Google Photos" && name != "Takeout" {
+			album = name
+		}
+		sidecars := make(map[string]*sidecar)
+		var media []entry
+		for _, en := range folders[dir] {
+			if !strings.EqualFold(path.Ext(en.name), ".json") {
+				media = append(media, en)
+				continue
+			}
+			if en.size > maxSidecarSize {
+				continue
+			}
+			data, err := readAll(en)
+			if err != nil {
+				return err
+			}
+			var parsed sidecarJSON
+			if json.Unmarshal(data, &parsed) != nil {
+				continue
+			}
+			if parsed.PhotoTakenTime == nil {
+				// Album metadata (metadata.json) names the album, other JSON files are not sidecars
+				if strings.HasPrefix(path.Base(en.name), "metadata") && album != "" && parsed.Title != "" {
+					album = parsed.Title
+				}
+				continue
+			}
+			sidecars[sidecarKey(path.Base(en.name))] = &sidecar{name: en.name, metadata: parsed.metadata()}
+		}
+		index := newSidecarIndex(sidecars)
+		for _, en := range media {
+			file := &File{Name: en.name, Size: en.size, ModTime: en.modTime, Album: album, open: en.open}
+			if s := index.find(path.Base(en.name)); s != nil {
+				s.used = true
+				metadata := s.metadata
+				file.Metadata = &metadata
+			}
+			e.Files = append(e.Files, file)
+		}
+		for _, s := range sidecars {
+			if !s.used {
+				e.UnusedSidecars = append(e.UnusedSidecars, s.name)
+			}
+		}
+	}
+	slices.Sort(e.UnusedSidecars)
+	return nil
+}
+
+func readAll(en entry) ([]byte, error) {
+	r, err := en.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (s sidecarJSON) metadata() Metadata {
+	m := Metadata{Title: s.Title, Description: s.Description, Favorite: s.Favorited}
+	if seconds, err := strconv.ParseInt(s.PhotoTakenTime.Timestamp, 10, 64); err == nil && seconds > 0 {
+		m.TakenAt = time.Unix(seconds, 0).UTC()
+	}
+	// 0, 0 is how Google Photos stores no location
+	for _, geo := range []geoData{s.GeoData, s.GeoDataExif} {
+		if geo.Latitude != 0 || geo.Longitude != 0 {
+			m.HasLocation, m.Latitude, m.Longitude = true, geo.Latitude, geo.Longitude
+			break
+		}
+	}
+	return m
+}
+
+// sidecarKey returns the name of the media file a sidecar belongs to, as far as its name tells.
+// IMG.jpg.json, IMG.jpg.supplemental-metadata.json and its truncations like IMG.jpg.supplemental-me.json
+// belong to IMG.jpg, IMG.jpg(1).json and IMG.jpg.supplemental-metadata(1).json to IMG(1).jpg, which is kept as IMG.jpg(1).
+func sidecarKey(name string) string {
+	stem := strings.TrimSuffix(name, path.Ext(name))
+	dup := ""
+	if m := duplicate.FindStringSubmatch(stem); m != nil {
+		stem, dup = m[1], m[2]
+	}
+	if dot := strings.LastIndex(stem, "."); dot >= 0 && len(stem)-dot > 1 && strings.HasPrefix(".supplemental-metadata", stem[dot:]) {
+		stem = stem[:dot]
+	}
+	return stem + dup
+}
+
+// sidecarIndex finds the sidecars of the media files of a folder.
+type sidecarIndex struct {
+	byKey map[string]*sidecar
+	// byTitle and byBase map the title of a sidecar, and the title without extension, to the first sidecar
+	// (by key) with it. Sidecars of duplicates are left out, their title is the name of the original.
+	byTitle map[string]*sidecar
+	byBase  map[string]*sidecar
+}
+
+// newSidecarIndex indexes the sidecars of a folder by their keys (see sidecarKey) and titles.
+func newSidecarIndex(sidecars map[string]*sidecar) *sidecarIndex {
+	index := &sidecarIndex{byKey: sidecars, byTitle: make(map[string]*sidecar), byBase: make(map[string]*sidecar)}
+	for _, key := range slices.Sorted(maps.Keys(sidecars)) {
+		if duplicate.MatchString(key) {
+			continue
+		}
+		s := sidecars[key]
+		title := s.metadata.Title
+		if _, ok := index.byTitle[title]; !ok {
+			index.byTitle[title] = s
+		}
+		base := strings.TrimSuffix(title, path.Ext(title))
+		if _, ok := index.byBase[base]; !ok {
+			index.byBase[base] = s
+		}
+	}
+	return index
+}
+
+// find returns the sidecar of a media file in the folder, nil if there is none.
+func (x *sidecarIndex) find(name string) *sidecar {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if s, ok := x.byKey[name]; ok {
+		return s
+	}
+	// IMG(1).jpg
+	if m := duplicate.FindStringSubmatch(base); m != nil {
+		if s, ok := x.byKey[m[1]+ext+m[2]]; ok {
+			return s
+		}
+	}
+	// Edited versions share the sidecar of the original
+	if original, ok := strings.CutSuffix(base, "-edited"); ok {
+		return x.find(original + ext)
+	}
+	// Long names are truncated in the name of the sidecar, its title is the full name
+	if s, ok := x.byTitle[name]; ok {
+		return s
+	}
+	// The video of a live photo (IMG.MP4) shares the sidecar of its image (IMG.HEIC)
+	return x.byBase[base]
+}
@@ -0,0 +1,154 @@
+package takeout
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func sidecarData(title string, taken int64) string {
+	return fmt.Sprintf(`{"title": %q, "description": "", "photoTakenTime": {"timestamp": "%d", "formatted": ""},
+		"geoData": {"latitude": 0.0, "longitude": 0.0}, "geoDataExif": {"latitude": 48.1, "longitude": 11.5}, "favorited": true}`, title, taken)
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, data := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMatchSidecars(t *testing.T) {
+	long := "a_very_long_file_name_that_google_truncates_x.jpg"
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"Takeout/Google Photos/Photos from 2019/IMG_1.jpg":                                  "1",
+		"Takeout/Google Photos/Photos from 2019/IMG_1.jpg.json":                             sidecarData("IMG_1.jpg", 1),
+		"Takeout/Google Photos/Photos from 2019/IMG_1-edited.jpg":                           "1e",
+		"Takeout/Google Photos/Photos from 2019/IMG_2.jpg":                                  "2",
+		"Takeout/Google Photos/Photos from 2019/IMG_2.jpg.supplemental-metadata.json":       sidecarData("IMG_2.jpg", 2),
+		"Takeout/Google Photos/Photos from 2019/IMG_2(1).jpg":                               "2 again",
+		"Takeout/Google Photos/Photos from 2019/IMG_2.jpg.supplemental-metadata(1).json":    sidecarData("IMG_2.jpg", 3),
+		"Takeout/Google Photos/Photos from 2019/IMG_3.jpg":                                  "3",
+		"Takeout/Google Photos/Photos from 2019/IMG_3.jpg.supplemental-me.json":             sidecarData("IMG_3.jpg", 4),
+		"Takeout/Google Photos/Photos from 2019/" + long:                                    "long",
+		"Takeout/Google Photos/Photos from 2019/a_very_long_file_name_that_google_tru.json": sidecarData(long, 5),
+		"Takeout/Google Photos/Photos from 2019/LIVE.HEIC":                                  "live",
+		"Takeout/Google Photos/Photos from 2019/LIVE.MP4":                                   "live video",
+		"Takeout/Google Photos/Photos from 2019/LIVE.HEIC.json":                             sidecarData("LIVE.HEIC", 6),
+		"Takeout/Google Photos/Photos from 2019/NOSIDECAR.jpg":                              "none",
+		"Takeout/Google Photos/Photos from 2019/ORPHAN.jpg.json":                            sidecarData("ORPHAN.jpg", 7),
+		"Takeout/Google Photos/Holiday/IMG_1.jpg":                                           "1",
+		"Takeout/Google Photos/Holiday/IMG_1.jpg.json":                                      sidecarData("IMG_1.jpg", 1),
+		"Takeout/Google Photos/Holiday/metadata.json":                                       `{"title": "Holiday 2019", "description": ""}`,
+		"Takeout/Google Photos/Untitled/IMG_4.jpg":                                          "4",
+	})
+
+	export, err := Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Close()
+
+	expected := map[string]struct {
+		album string
+		taken int64
+	}{
+		"Photos from 2019/IMG_1.jpg":        {"", 1},
+		"Photos from 2019/IMG_1-edited.jpg": {"", 1},
+		"Photos from 2019/IMG_2.jpg":        {"", 2},
+		"Photos from 2019/IMG_2(1).jpg":     {"", 3},
+		"Photos from 2019/IMG_3.jpg":        {"", 4},
+		"Photos from 2019/" + long:          {"", 5},
+		"Photos from 2019/LIVE.HEIC":        {"", 6},
+		"Photos from 2019/LIVE.MP4":         {"", 6},
+		"Photos from 2019/NOSIDECAR.jpg":    {"", 0},
+		"Holiday/IMG_1.jpg":                 {"Holiday 2019", 1},
+		"Untitled/IMG_4.jpg":                {"Untitled", 0},
+	}
+	if len(export.Files) != len(expected) {
+		t.Errorf("Expected %d media files, got %d", len(expected), len(export.Files))
+	}
+	for _, f := range export.Files {
+		rel, _ := filepath.Rel("Takeout/Google Photos", f.Name)
+		want, ok := expected[rel]
+		if !ok {
+			t.Errorf("Unexpected file %s", f.Name)
+			continue
+		}
+		if f.Album != want.album {
+			t.Errorf("%s: expected album '%s', got '%s'", rel, want.album, f.Album)
+		}
+		if want.taken == 0 {
+			if f.Metadata != nil {
+				t.Errorf("%s: expected no sidecar, got %+v", rel, f.Metadata)
+			}
+			continue
+		}
+		if f.Metadata == nil {
+			t.Errorf("%s: expected a sidecar", rel)
+			continue
+		}
+		if !f.Metadata.TakenAt.Equal(time.Unix(want.taken, 0)) {
+			t.Errorf("%s: expected the sidecar taken at %d, got %s", rel, want.taken, f.Metadata.TakenAt)
+		}
+		if !f.Metadata.Favorite || !f.Metadata.HasLocation || f.Metadata.Latitude != 48.1 {
+			t.Errorf("%s: expected favorite and the EXIF location, got %+v", rel, f.Metadata)
+		}
+	}
+	if len(export.UnusedSidecars) != 1 || filepath.Base(export.UnusedSidecars[0]) != "ORPHAN.jpg.json" {
+		t.Errorf("Expected ORPHAN.jpg.json to be unused, got %v", export.UnusedSidecars)
+	}
+}
+
+func TestOpenSplitArchives(t *testing.T) {
+	dir := t.TempDir()
+	writeZip := func(name string, files map[string]string) string {
+		p := filepath.Join(dir, name)
+		out, err := os.Create(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := zip.NewWriter(out)
+		for name, data := range files {
+			fw, err := w.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write([]byte(data))
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		out.Close()
+		return p
+	}
+	first := writeZip("takeout-001.zip", map[string]string{"Takeout/Google Photos/Trip/IMG.jpg": "image"})
+	second := writeZip("takeout-002.zip", map[string]string{"Takeout/Google Photos/Trip/IMG.jpg.json": sidecarData("IMG.jpg", 10)})
+
+	export, err := Open(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Close()
+	if len(export.Files) != 1 || export.Files[0].Metadata == nil || export.Files[0].Album != "Trip" {
+		t.Fatalf("Expected the file matched to the sidecar of the other archive, got %+v", export.Files)
+	}
+	r, err := export.Files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if data, _ := io.ReadAll(r); string(data) != "image" {
+		t.Errorf("Expected the content of the file, got '%s'", data)
+	}
+}